@@ -3,4 +3,7 @@
 set -e # immediately fail on error
 
 GOOS=linux GOARCH=arm64 go build -o ./cmd/getProgressLambda/bootstrap ./cmd/getProgressLambda/main.go
-GOOS=linux GOARCH=arm64 go build -o ./cmd/pushUpdatesLambda/bootstrap ./cmd/pushUpdatesLambda/main.go
\ No newline at end of file
+GOOS=linux GOARCH=arm64 go build -o ./cmd/pushUpdatesLambda/bootstrap ./cmd/pushUpdatesLambda/main.go
+GOOS=linux GOARCH=arm64 go build -o ./cmd/slackCommandsLambda/bootstrap ./cmd/slackCommandsLambda/main.go
+GOOS=linux GOARCH=arm64 go build -o ./cmd/fcmDevicesLambda/bootstrap ./cmd/fcmDevicesLambda/main.go
+GOOS=linux GOARCH=arm64 go build -o ./cmd/liveProgressLambda/bootstrap ./cmd/liveProgressLambda/main.go
@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+
 	"github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/metrics"
 	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awscloudwatch"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awscloudwatchactions"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsdynamodb"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambdaeventsources"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssns"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssnssubscriptions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssqs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/interfaces/interfacesawscloudwatch"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 )
@@ -18,22 +30,63 @@ const (
 	MemorySizeMB       = 128
 	MaxDurationSeconds = 20
 	Handler            = "bootstrap"
-	SecretName         = "StormlightArchive"
+	// PushUpdatesBatchSize is the most stream records one push updates invocation handles
+	PushUpdatesBatchSize = 10
+	// PushUpdatesRetryAttempts is how many times a failed stream record is retried before it is sent to the failed
+	// records queue. Records are delivered once per target, so retrying only resends to the targets that failed.
+	PushUpdatesRetryAttempts = 5
+	// LiveStreamMaxDurationSeconds bounds how long one live progress event stream stays open before the client
+	// reconnects
+	LiveStreamMaxDurationSeconds = 300
 )
 
+// logRetentionDays maps the retention periods CloudWatch Logs accepts to their CDK names
+var logRetentionDays = map[int]awslogs.RetentionDays{
+	1:    awslogs.RetentionDays_ONE_DAY,
+	3:    awslogs.RetentionDays_THREE_DAYS,
+	5:    awslogs.RetentionDays_FIVE_DAYS,
+	7:    awslogs.RetentionDays_ONE_WEEK,
+	14:   awslogs.RetentionDays_TWO_WEEKS,
+	30:   awslogs.RetentionDays_ONE_MONTH,
+	60:   awslogs.RetentionDays_TWO_MONTHS,
+	90:   awslogs.RetentionDays_THREE_MONTHS,
+	120:  awslogs.RetentionDays_FOUR_MONTHS,
+	150:  awslogs.RetentionDays_FIVE_MONTHS,
+	180:  awslogs.RetentionDays_SIX_MONTHS,
+	365:  awslogs.RetentionDays_ONE_YEAR,
+	400:  awslogs.RetentionDays_THIRTEEN_MONTHS,
+	545:  awslogs.RetentionDays_EIGHTEEN_MONTHS,
+	731:  awslogs.RetentionDays_TWO_YEARS,
+	1096: awslogs.RetentionDays_THREE_YEARS,
+	1827: awslogs.RetentionDays_FIVE_YEARS,
+	2192: awslogs.RetentionDays_SIX_YEARS,
+	2557: awslogs.RetentionDays_SEVEN_YEARS,
+	2922: awslogs.RetentionDays_EIGHT_YEARS,
+	3288: awslogs.RetentionDays_NINE_YEARS,
+	3653: awslogs.RetentionDays_TEN_YEARS,
+}
+
 type StormWatchCdkStackProps struct {
 	awscdk.StackProps
+	// Config is the environment the stack is for. It names the tables and secret, and sets the schedules, log retention
+	// and FCM topic. Defaults to the prod profile.
+	Config config.Config
 }
 
 func NewCdkStack(scope constructs.Construct, id string, props *StormWatchCdkStackProps) awscdk.Stack {
 	var sprops awscdk.StackProps
+	cfg := config.Profiles[config.ProfileProd]
 	if props != nil {
 		sprops = props.StackProps
+		if props.Config.Profile != "" {
+			cfg = props.Config
+		}
 	}
 	stack := awscdk.NewStack(scope, &id, &sprops)
+	logRetention := logRetentionDays[cfg.LogRetentionDays]
 
 	progressCheckLogGroup := awslogs.NewLogGroup(stack, jsii.String("ProgressCheckLogs"), &awslogs.LogGroupProps{
-		Retention:     awslogs.RetentionDays_ONE_DAY,
+		Retention:     logRetention,
 		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
 	})
 	progressCheckFunction := awslambda.NewFunction(stack, jsii.String("ProgressCheck"), &awslambda.FunctionProps{
@@ -42,6 +95,7 @@ func NewCdkStack(scope constructs.Construct, id string, props *StormWatchCdkStac
 		MemorySize:   jsii.Number(MemorySizeMB),
 		Timeout:      awscdk.Duration_Seconds(jsii.Number(MaxDurationSeconds)),
 		Code:         awslambda.AssetCode_FromAsset(jsii.String("./cmd/getProgressLambda"), nil),
+		Environment:  lambdaEnvironment(cfg, nil),
 		LogGroup:     progressCheckLogGroup,
 		Handler:      jsii.String(Handler),
 	})
@@ -53,12 +107,12 @@ func NewCdkStack(scope constructs.Construct, id string, props *StormWatchCdkStac
 	})
 
 	awsevents.NewRule(stack, jsii.String("storm-check"), &awsevents.RuleProps{
-		Schedule: awsevents.Schedule_Expression(jsii.String("rate(5 minutes)")),
+		Schedule: awsevents.Schedule_Rate(awscdk.Duration_Minutes(jsii.Number(cfg.CheckInterval.Minutes()))),
 		Targets:  &[]awsevents.IRuleTarget{awseventstargets.NewLambdaFunction(progressCheckFunction, nil)},
 	})
 
-	history := awsdynamodb.NewTableV2(stack, jsii.String(config.HistoryDynamoTableName), &awsdynamodb.TablePropsV2{
-		TableName: jsii.String(config.HistoryDynamoTableName),
+	history := awsdynamodb.NewTableV2(stack, jsii.String("storm-charts"), &awsdynamodb.TablePropsV2{
+		TableName: jsii.String(cfg.HistoryTable),
 		PartitionKey: &awsdynamodb.Attribute{
 			Name: jsii.String("ID"),
 			Type: awsdynamodb.AttributeType_STRING,
@@ -83,7 +137,7 @@ func NewCdkStack(scope constructs.Construct, id string, props *StormWatchCdkStac
 	}))
 
 	pushUpdatesLogGroup := awslogs.NewLogGroup(stack, jsii.String("PushUpdatesLogs"), &awslogs.LogGroupProps{
-		Retention:     awslogs.RetentionDays_ONE_DAY,
+		Retention:     logRetention,
 		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
 	})
 	pushUpdatesFunction := awslambda.NewFunction(stack, jsii.String("PushUpdates"), &awslambda.FunctionProps{
@@ -94,6 +148,9 @@ func NewCdkStack(scope constructs.Construct, id string, props *StormWatchCdkStac
 		Code:         awslambda.AssetCode_FromAsset(jsii.String("./cmd/pushUpdatesLambda"), nil),
 		LogGroup:     pushUpdatesLogGroup,
 		Handler:      jsii.String(Handler),
+		Environment: lambdaEnvironment(cfg, map[string]*string{
+			config.EnvPrefix + "STATUS_PAGE_URL": progressCheckFunctionUrl.Url(),
+		}),
 	})
 	pushUpdatesFunctionUrl := pushUpdatesFunction.AddFunctionUrl(&awslambda.FunctionUrlOptions{
 		AuthType: awslambda.FunctionUrlAuthType_NONE,
@@ -106,6 +163,8 @@ func NewCdkStack(scope constructs.Construct, id string, props *StormWatchCdkStac
 			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
 				Actions: jsii.Strings(
 					"dynamodb:Query",
+					"dynamodb:PutItem",
+					"dynamodb:DeleteItem",
 				),
 				Resources: jsii.Strings(*history.TableArn()),
 			}),
@@ -113,31 +172,390 @@ func NewCdkStack(scope constructs.Construct, id string, props *StormWatchCdkStac
 	}))
 	history.GrantStreamRead(pushUpdatesFunction)
 
+	// Flushes coalesced notifications once their window closes. An event with no records only triggers the flush.
+	if cfg.CoalesceWindow.Duration > 0 {
+		awsevents.NewRule(stack, jsii.String("storm-flush"), &awsevents.RuleProps{
+			Schedule: awsevents.Schedule_Rate(awscdk.Duration_Minutes(jsii.Number(cfg.FlushInterval.Minutes()))),
+			Targets: &[]awsevents.IRuleTarget{awseventstargets.NewLambdaFunction(pushUpdatesFunction, &awseventstargets.LambdaFunctionProps{
+				Event: awsevents.RuleTargetInput_FromObject(map[string]interface{}{
+					"Records": []interface{}{},
+				}),
+			})},
+		})
+	}
+
+	// Records that still fail once their retries are exhausted are kept here, so that they can be inspected and replayed
+	failedPushRecords := awssqs.NewQueue(stack, jsii.String("storm-push-updates-failed"), &awssqs.QueueProps{
+		RetentionPeriod: awscdk.Duration_Days(jsii.Number(14)),
+		RemovalPolicy:   awscdk.RemovalPolicy_DESTROY,
+	})
 	pushUpdatesFunction.AddEventSourceMapping(jsii.String("push-updates-dynamo-trigger"), &awslambda.EventSourceMappingOptions{
-		BatchSize:               jsii.Number(1),
+		BatchSize:               jsii.Number(PushUpdatesBatchSize),
 		StartingPosition:        awslambda.StartingPosition_LATEST,
 		EventSourceArn:          history.TableStreamArn(),
 		BisectBatchOnError:      jsii.Bool(false),
-		RetryAttempts:           jsii.Number(0),
+		RetryAttempts:           jsii.Number(PushUpdatesRetryAttempts),
+		OnFailure:               awslambdaeventsources.NewSqsDlq(failedPushRecords),
 		ParallelizationFactor:   jsii.Number(1),
 		ReportBatchItemFailures: jsii.Bool(true),
 	})
 
-	secret := awssecretsmanager.Secret_FromSecretNameV2(stack, jsii.String(SecretName+"SecretID"), jsii.String(SecretName))
+	slackSubscriptions := awsdynamodb.NewTableV2(stack, jsii.String("storm-slack-subscriptions"), &awsdynamodb.TablePropsV2{
+		TableName: jsii.String(cfg.SlackSubscriptionsTable),
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("ChannelID"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+	})
+	slackSubscriptions.GrantReadWriteData(pushUpdatesFunction) // Channels that were deleted or archived are unsubscribed
+
+	slackCommandsLogGroup := awslogs.NewLogGroup(stack, jsii.String("SlackCommandsLogs"), &awslogs.LogGroupProps{
+		Retention:     logRetention,
+		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+	})
+	slackCommandsFunction := awslambda.NewFunction(stack, jsii.String("SlackCommands"), &awslambda.FunctionProps{
+		Runtime:      awslambda.Runtime_PROVIDED_AL2023(),
+		Architecture: awslambda.Architecture_ARM_64(),
+		MemorySize:   jsii.Number(MemorySizeMB),
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(MaxDurationSeconds)),
+		Code:         awslambda.AssetCode_FromAsset(jsii.String("./cmd/slackCommandsLambda"), nil),
+		Environment:  lambdaEnvironment(cfg, nil),
+		LogGroup:     slackCommandsLogGroup,
+		Handler:      jsii.String(Handler),
+	})
+	slackCommandsFunctionUrl := slackCommandsFunction.AddFunctionUrl(&awslambda.FunctionUrlOptions{
+		AuthType: awslambda.FunctionUrlAuthType_NONE, // Requests are authenticated with Slack's request signature
+	})
+	awscdk.NewCfnOutput(stack, jsii.String("slackCommandsFunctionUrlOutput"), &awscdk.CfnOutputProps{
+		Value: slackCommandsFunctionUrl.Url(),
+	})
+	slackCommandsFunction.Role().AttachInlinePolicy(awsiam.NewPolicy(stack, jsii.String("slack-commands-dynamo"), &awsiam.PolicyProps{
+		Statements: &[]awsiam.PolicyStatement{
+			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+				Actions: jsii.Strings(
+					"dynamodb:Query",
+				),
+				Resources: jsii.Strings(*history.TableArn()),
+			}),
+		},
+	}))
+	slackSubscriptions.GrantReadWriteData(slackCommandsFunction)
+
+	fcmDevices := awsdynamodb.NewTableV2(stack, jsii.String("storm-fcm-devices"), &awsdynamodb.TablePropsV2{
+		TableName: jsii.String(cfg.FCMDevicesTable),
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("Token"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+	})
+	fcmDevices.GrantReadWriteData(pushUpdatesFunction) // Stale tokens are unregistered as they are found
+
+	fcmDevicesLogGroup := awslogs.NewLogGroup(stack, jsii.String("FCMDevicesLogs"), &awslogs.LogGroupProps{
+		Retention:     logRetention,
+		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+	})
+	fcmDevicesFunction := awslambda.NewFunction(stack, jsii.String("FCMDevices"), &awslambda.FunctionProps{
+		Runtime:      awslambda.Runtime_PROVIDED_AL2023(),
+		Architecture: awslambda.Architecture_ARM_64(),
+		MemorySize:   jsii.Number(MemorySizeMB),
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(MaxDurationSeconds)),
+		Code:         awslambda.AssetCode_FromAsset(jsii.String("./cmd/fcmDevicesLambda"), nil),
+		Environment:  lambdaEnvironment(cfg, nil),
+		LogGroup:     fcmDevicesLogGroup,
+		Handler:      jsii.String(Handler),
+	})
+	fcmDevicesFunctionUrl := fcmDevicesFunction.AddFunctionUrl(&awslambda.FunctionUrlOptions{
+		AuthType: awslambda.FunctionUrlAuthType_NONE, // Registration tokens are checked with FCM before they are stored
+	})
+	awscdk.NewCfnOutput(stack, jsii.String("fcmDevicesFunctionUrlOutput"), &awscdk.CfnOutputProps{
+		Value: fcmDevicesFunctionUrl.Url(),
+	})
+	fcmDevices.GrantReadWriteData(fcmDevicesFunction)
+
+	liveProgressLogGroup := awslogs.NewLogGroup(stack, jsii.String("LiveProgressLogs"), &awslogs.LogGroupProps{
+		Retention:     logRetention,
+		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+	})
+	liveProgressFunction := awslambda.NewFunction(stack, jsii.String("LiveProgress"), &awslambda.FunctionProps{
+		Runtime:      awslambda.Runtime_PROVIDED_AL2023(),
+		Architecture: awslambda.Architecture_ARM_64(),
+		MemorySize:   jsii.Number(MemorySizeMB),
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(LiveStreamMaxDurationSeconds)),
+		Code:         awslambda.AssetCode_FromAsset(jsii.String("./cmd/liveProgressLambda"), nil),
+		Environment:  lambdaEnvironment(cfg, nil),
+		LogGroup:     liveProgressLogGroup,
+		Handler:      jsii.String(Handler),
+	})
+	liveProgressFunctionUrl := liveProgressFunction.AddFunctionUrl(&awslambda.FunctionUrlOptions{
+		AuthType:   awslambda.FunctionUrlAuthType_NONE,
+		InvokeMode: awslambda.InvokeMode_RESPONSE_STREAM,
+	})
+	awscdk.NewCfnOutput(stack, jsii.String("liveProgressFunctionUrlOutput"), &awscdk.CfnOutputProps{
+		Value: liveProgressFunctionUrl.Url(),
+	})
+	liveProgressFunction.Role().AttachInlinePolicy(awsiam.NewPolicy(stack, jsii.String("live-progress-dynamo"), &awsiam.PolicyProps{
+		Statements: &[]awsiam.PolicyStatement{
+			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+				Actions: jsii.Strings(
+					"dynamodb:Query",
+				),
+				Resources: jsii.Strings(*history.TableArn()),
+			}),
+		},
+	}))
+
+	webPushSubscriptions := awsdynamodb.NewTableV2(stack, jsii.String("storm-webpush-subscriptions"), &awsdynamodb.TablePropsV2{
+		TableName: jsii.String(cfg.WebPushSubscriptionsTable),
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("Endpoint"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+	})
+	webPushSubscriptions.GrantReadWriteData(progressCheckFunction)
+	webPushSubscriptions.GrantReadWriteData(pushUpdatesFunction) // Expired subscriptions are removed as they are found
+
+	secret := awssecretsmanager.Secret_FromSecretNameV2(stack, jsii.String("StormlightArchiveSecretID"), jsii.String(cfg.SecretName))
+	secret.GrantRead(progressCheckFunction, nil) // For the VAPID public key
 	secret.GrantRead(pushUpdatesFunction, nil)
+	secret.GrantRead(slackCommandsFunction, nil)
+	secret.GrantRead(fcmDevicesFunction, nil) // For the Firebase credentials that registration tokens are checked with
+
+	newMonitoring(stack, cfg, failedPushRecords, []monitoredFunction{
+		// A single failed check is usually the site being briefly unavailable. ScrapeFailing covers the rest.
+		{progressCheckFunction, 3},
+		{pushUpdatesFunction, 1},
+		{slackCommandsFunction, 1},
+		{fcmDevicesFunction, 1},
+		{liveProgressFunction, 1},
+	})
 
 	return stack
 }
 
+// monitoredFunction is a function that is alarmed on when it errors in evaluationPeriods consecutive check intervals
+type monitoredFunction struct {
+	function          awslambda.Function
+	evaluationPeriods float64
+}
+
+// newMonitoring adds alarms on the metrics the functions record and on the functions themselves, routed to an SNS
+// topic, and a dashboard summarizing the system's health
+func newMonitoring(stack awscdk.Stack, cfg config.Config, failedPushRecords awssqs.Queue, functions []monitoredFunction) {
+	period := awscdk.Duration_Minutes(jsii.Number(cfg.CheckInterval.Minutes()))
+	metric := func(name, statistic string) awscloudwatch.Metric {
+		return awscloudwatch.NewMetric(&awscloudwatch.MetricProps{
+			Namespace:  jsii.String(metrics.Namespace),
+			MetricName: jsii.String(name),
+			DimensionsMap: &map[string]*string{
+				metrics.EnvironmentDimension: jsii.String(cfg.Profile),
+			},
+			Statistic: jsii.String(statistic),
+			Period:    period,
+		})
+	}
+	// perTarget graphs a metric for every push target that has recorded it
+	perTarget := func(name string) awscloudwatch.MathExpression {
+		return awscloudwatch.NewMathExpression(&awscloudwatch.MathExpressionProps{
+			Expression: jsii.String(fmt.Sprintf(`SEARCH('{%s,%s,%s} MetricName="%s" %s="%s"', 'Sum', %d)`,
+				metrics.Namespace, metrics.EnvironmentDimension, metrics.TargetDimension, name,
+				metrics.EnvironmentDimension, cfg.Profile, int(cfg.CheckInterval.Seconds()))),
+			Label:        jsii.String(""),
+			Period:       period,
+			UsingMetrics: &map[string]awscloudwatch.IMetric{},
+		})
+	}
+
+	topic := awssns.NewTopic(stack, jsii.String("storm-alarms"), &awssns.TopicProps{
+		DisplayName: jsii.String(cfg.NamePrefix + "StormWatch alarms"),
+	})
+	if cfg.AlarmEmail != "" {
+		topic.AddSubscription(awssnssubscriptions.NewEmailSubscription(jsii.String(cfg.AlarmEmail), nil))
+	}
+	awscdk.NewCfnOutput(stack, jsii.String("alarmTopicArnOutput"), &awscdk.CfnOutputProps{
+		Value: topic.TopicArn(),
+	})
+	alarmAction := awscloudwatchactions.NewSnsAction(topic)
+
+	var alarms []interfacesawscloudwatch.IAlarmRef
+	addAlarm := func(id string, props *awscloudwatch.AlarmProps) {
+		alarm := awscloudwatch.NewAlarm(stack, jsii.String(id), props)
+		alarm.AddAlarmAction(alarmAction)
+		alarm.AddOkAction(alarmAction)
+		alarms = append(alarms, alarm)
+	}
+
+	addAlarm("ScrapeFailing", &awscloudwatch.AlarmProps{
+		AlarmDescription:   jsii.String("Progress could not be scraped in three consecutive checks"),
+		Metric:             metric(metrics.ScrapeFailures, "Sum"),
+		Threshold:          jsii.Number(1),
+		EvaluationPeriods:  jsii.Number(3),
+		ComparisonOperator: awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD,
+		TreatMissingData:   awscloudwatch.TreatMissingData_NOT_BREACHING, // ProgressCheckMissing covers missing checks
+	})
+	addAlarm("ProgressCheckMissing", &awscloudwatch.AlarmProps{
+		AlarmDescription:   jsii.String("The scheduled progress check has not run in three check intervals"),
+		Metric:             metric(metrics.ScrapeLatency, "SampleCount"),
+		Threshold:          jsii.Number(1),
+		EvaluationPeriods:  jsii.Number(3),
+		ComparisonOperator: awscloudwatch.ComparisonOperator_LESS_THAN_THRESHOLD,
+		TreatMissingData:   awscloudwatch.TreatMissingData_BREACHING,
+	})
+	addAlarm("ScrapeLatencyHigh", &awscloudwatch.AlarmProps{
+		AlarmDescription:   jsii.String("Scraping progress is close to timing out"),
+		Metric:             metric(metrics.ScrapeLatency, "p90"),
+		Threshold:          jsii.Number(MaxDurationSeconds * 1000 / 2),
+		EvaluationPeriods:  jsii.Number(3),
+		ComparisonOperator: awscloudwatch.ComparisonOperator_GREATER_THAN_THRESHOLD,
+		TreatMissingData:   awscloudwatch.TreatMissingData_NOT_BREACHING,
+	})
+	addAlarm("PushesFailing", &awscloudwatch.AlarmProps{
+		AlarmDescription:   jsii.String("Notifications could not be sent to a push target"),
+		Metric:             metric(metrics.PushesFailed, "Sum"),
+		Threshold:          jsii.Number(1),
+		EvaluationPeriods:  jsii.Number(1),
+		ComparisonOperator: awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD,
+		TreatMissingData:   awscloudwatch.TreatMissingData_NOT_BREACHING,
+	})
+	addAlarm("PushRecordsDropped", &awscloudwatch.AlarmProps{
+		AlarmDescription: jsii.String("Stream records could not be pushed after every retry and were sent to the failed records queue"),
+		Metric: failedPushRecords.MetricApproximateNumberOfMessagesVisible(&awscloudwatch.MetricOptions{
+			Period:    period,
+			Statistic: jsii.String("Maximum"),
+		}),
+		Threshold:          jsii.Number(1),
+		EvaluationPeriods:  jsii.Number(1),
+		ComparisonOperator: awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD,
+		TreatMissingData:   awscloudwatch.TreatMissingData_NOT_BREACHING,
+	})
+	for _, monitored := range functions {
+		addAlarm(*monitored.function.Node().Id()+"Errors", &awscloudwatch.AlarmProps{
+			AlarmDescription: jsii.String(*monitored.function.Node().Id() + " is failing"),
+			Metric: monitored.function.MetricErrors(&awscloudwatch.MetricOptions{
+				Period:    period,
+				Statistic: jsii.String("Sum"),
+			}),
+			Threshold:          jsii.Number(1),
+			EvaluationPeriods:  jsii.Number(monitored.evaluationPeriods),
+			ComparisonOperator: awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD,
+			TreatMissingData:   awscloudwatch.TreatMissingData_NOT_BREACHING,
+		})
+	}
+
+	var invocations, errors, durations []awscloudwatch.IMetric
+	for _, monitored := range functions {
+		options := &awscloudwatch.MetricOptions{
+			Period: period,
+			Label:  monitored.function.Node().Id(),
+		}
+		invocations = append(invocations, monitored.function.MetricInvocations(options))
+		errors = append(errors, monitored.function.MetricErrors(options))
+		durations = append(durations, monitored.function.MetricDuration(&awscloudwatch.MetricOptions{
+			Period:    period,
+			Label:     monitored.function.Node().Id(),
+			Statistic: jsii.String("p90"),
+		}))
+	}
+
+	awscloudwatch.NewDashboard(stack, jsii.String("storm-dashboard"), &awscloudwatch.DashboardProps{
+		DashboardName: jsii.String(cfg.NamePrefix + "StormWatch"),
+		Widgets: &[]*[]awscloudwatch.IWidget{
+			{
+				awscloudwatch.NewAlarmStatusWidget(&awscloudwatch.AlarmStatusWidgetProps{
+					Title:  jsii.String("Alarms"),
+					Alarms: &alarms,
+					Width:  jsii.Number(24),
+				}),
+			},
+			{
+				awscloudwatch.NewGraphWidget(&awscloudwatch.GraphWidgetProps{
+					Title: jsii.String("Scrape latency"),
+					Left: &[]awscloudwatch.IMetric{
+						metric(metrics.ScrapeLatency, "p50"),
+						metric(metrics.ScrapeLatency, "p90"),
+						metric(metrics.ScrapeLatency, "Maximum"),
+					},
+					Width: jsii.Number(12),
+				}),
+				awscloudwatch.NewGraphWidget(&awscloudwatch.GraphWidgetProps{
+					Title: jsii.String("Scrapes"),
+					Left: &[]awscloudwatch.IMetric{
+						metric(metrics.ScrapeFailures, "Sum"),
+						metric(metrics.EntriesWritten, "Sum"),
+					},
+					Width: jsii.Number(12),
+				}),
+			},
+			{
+				awscloudwatch.NewGraphWidget(&awscloudwatch.GraphWidgetProps{
+					Title: jsii.String("Pushes sent"),
+					Left:  &[]awscloudwatch.IMetric{perTarget(metrics.PushesSent)},
+					Width: jsii.Number(12),
+				}),
+				awscloudwatch.NewGraphWidget(&awscloudwatch.GraphWidgetProps{
+					Title: jsii.String("Pushes failed"),
+					Left:  &[]awscloudwatch.IMetric{perTarget(metrics.PushesFailed)},
+					Width: jsii.Number(12),
+				}),
+			},
+			{
+				awscloudwatch.NewGraphWidget(&awscloudwatch.GraphWidgetProps{
+					Title: jsii.String("Invocations"),
+					Left:  &invocations,
+					Width: jsii.Number(8),
+				}),
+				awscloudwatch.NewGraphWidget(&awscloudwatch.GraphWidgetProps{
+					Title: jsii.String("Errors"),
+					Left:  &errors,
+					Width: jsii.Number(8),
+				}),
+				awscloudwatch.NewGraphWidget(&awscloudwatch.GraphWidgetProps{
+					Title: jsii.String("Duration (p90)"),
+					Left:  &durations,
+					Width: jsii.Number(8),
+				}),
+			},
+		},
+	})
+}
+
+// StackName is the environment's stack name. Prod keeps the original, unprefixed name.
+func StackName(cfg config.Config) string {
+	return cfg.NamePrefix + "StormWatchStack"
+}
+
+// lambdaEnvironment hands the config to a function, so that it uses the same tables and secret as the stack
+func lambdaEnvironment(cfg config.Config, extra map[string]*string) *map[string]*string {
+	env := map[string]*string{}
+	for key, value := range cfg.Environment() {
+		env[key] = jsii.String(value)
+	}
+	for key, value := range extra {
+		env[key] = value
+	}
+	return &env
+}
+
 func main() {
 	defer jsii.Close()
 
 	app := awscdk.NewApp(nil)
 
-	NewCdkStack(app, "StormWatchStack", &StormWatchCdkStackProps{
-		awscdk.StackProps{
+	// Select the environment with `cdk deploy -c profile=staging` or STORMWATCH_PROFILE. Each environment is its own
+	// stack with its own tables, so staging can be deployed alongside prod.
+	profile, _ := app.Node().TryGetContext(jsii.String("profile")).(string)
+	cfg, err := config.Load(context.Background(), config.LoadOptions{Profile: profile})
+	if err != nil {
+		log.Fatalf("load config: %s", err)
+	}
+
+	NewCdkStack(app, StackName(cfg), &StormWatchCdkStackProps{
+		StackProps: awscdk.StackProps{
 			Env: env(),
 		},
+		Config: cfg,
 	})
 
 	app.Synth(nil)
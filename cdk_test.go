@@ -1,26 +1,386 @@
 package main
 
-// import (
-// 	"testing"
-
-// 	"github.com/aws/aws-cdk-go/awscdk/v2"
-// 	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
-// 	"github.com/aws/jsii-runtime-go"
-// )
-
-// example tests. To run these tests, uncomment this file along with the
-// example resource in cdk_test.go
-// func TestCdkStack(t *testing.T) {
-// 	// GIVEN
-// 	app := awscdk.NewApp(nil)
-
-// 	// WHEN
-// 	stack := NewCdkStack(app, "MyStack", nil)
-
-// 	// THEN
-// 	template := assertions.Template_FromStack(stack, nil)
-
-// 	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
-// 		"VisibilityTimeout": 300,
-// 	})
-// }
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/metrics"
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/jsii-runtime-go"
+	"github.com/stretchr/testify/require"
+)
+
+var updateSnapshots = flag.Bool("update", false, "rewrite the template snapshots in testdata")
+
+// synth synthesizes the stack for the profile
+func synth(t *testing.T, profile string) assertions.Template {
+	t.Helper()
+	return synthConfig(t, config.Profiles[profile])
+}
+
+// synthConfig synthesizes the stack for the config
+func synthConfig(t *testing.T, cfg config.Config) assertions.Template {
+	t.Helper()
+	app := awscdk.NewApp(nil)
+	stack := NewCdkStack(app, StackName(cfg), &StormWatchCdkStackProps{Config: cfg})
+	return assertions.Template_FromStack(stack, nil)
+}
+
+// logicalID returns the ID of the only resource of the type whose ID starts with the construct ID
+func logicalID(t *testing.T, template assertions.Template, resourceType, constructID string) string {
+	t.Helper()
+	ids := []string{}
+	for id := range *template.FindResources(jsii.String(resourceType), map[string]any{}) {
+		if strings.HasPrefix(id, constructID) {
+			ids = append(ids, id)
+		}
+	}
+	require.Len(t, ids, 1, "%s %s", resourceType, constructID)
+	return ids[0]
+}
+
+// tableID returns the ID of the table with the name
+func tableID(t *testing.T, template assertions.Template, tableName string) string {
+	t.Helper()
+	tables := *template.FindResources(jsii.String("AWS::DynamoDB::GlobalTable"), map[string]any{
+		"Properties": map[string]any{"TableName": tableName},
+	})
+	require.Len(t, tables, 1, tableName)
+	for id := range tables {
+		return id
+	}
+	return ""
+}
+
+func TestCdkStack_Resources(t *testing.T) {
+	template := synth(t, config.ProfileProd)
+
+	counts := map[string]float64{
+		"AWS::DynamoDB::GlobalTable":      4,
+		"AWS::Lambda::Function":           5,
+		"AWS::Lambda::Url":                5,
+		"AWS::Logs::LogGroup":             5,
+		"AWS::Events::Rule":               1,
+		"AWS::Lambda::EventSourceMapping": 1,
+	}
+	for resourceType, count := range counts {
+		template.ResourceCountIs(jsii.String(resourceType), jsii.Number(count))
+	}
+
+	template.HasResourceProperties(jsii.String("AWS::DynamoDB::GlobalTable"), map[string]any{
+		"TableName": "storm-charts",
+		"KeySchema": []any{
+			map[string]any{"AttributeName": "ID", "KeyType": "HASH"},
+			map[string]any{"AttributeName": "TimestampUnixNano", "KeyType": "RANGE"},
+		},
+		"StreamSpecification": map[string]any{"StreamViewType": "NEW_IMAGE"},
+	})
+	partitionKeys := map[string]string{
+		"storm-slack-subscriptions":   "ChannelID",
+		"storm-fcm-devices":           "Token",
+		"storm-webpush-subscriptions": "Endpoint",
+	}
+	for tableName, partitionKey := range partitionKeys {
+		template.HasResourceProperties(jsii.String("AWS::DynamoDB::GlobalTable"), map[string]any{
+			"TableName": tableName,
+			"KeySchema": []any{map[string]any{"AttributeName": partitionKey, "KeyType": "HASH"}},
+		})
+	}
+
+	timeouts := map[string]float64{
+		"ProgressCheck": MaxDurationSeconds,
+		"PushUpdates":   MaxDurationSeconds,
+		"SlackCommands": MaxDurationSeconds,
+		"FCMDevices":    MaxDurationSeconds,
+		"LiveProgress":  LiveStreamMaxDurationSeconds,
+	}
+	for function, timeout := range timeouts {
+		t.Run(function, func(t *testing.T) {
+			functionID := logicalID(t, template, "AWS::Lambda::Function", function)
+			function := (*template.FindResources(jsii.String("AWS::Lambda::Function"), map[string]any{}))[functionID]
+			properties := (*function)["Properties"].(map[string]any)
+			require.Equal(t, "provided.al2023", properties["Runtime"])
+			require.Equal(t, []any{"arm64"}, properties["Architectures"])
+			require.Equal(t, Handler, properties["Handler"])
+			require.EqualValues(t, MemorySizeMB, properties["MemorySize"])
+			require.EqualValues(t, timeout, properties["Timeout"])
+			env := properties["Environment"].(map[string]any)["Variables"].(map[string]any)
+			require.Equal(t, "prod", env["STORMWATCH_PROFILE"])
+			require.Equal(t, "storm-charts", env["STORMWATCH_HISTORY_TABLE"])
+		})
+	}
+	template.HasResourceProperties(jsii.String("AWS::Logs::LogGroup"), map[string]any{
+		"RetentionInDays": 1,
+	})
+}
+
+func TestCdkStack_Triggers(t *testing.T) {
+	template := synth(t, config.ProfileProd)
+	progressCheck := logicalID(t, template, "AWS::Lambda::Function", "ProgressCheck")
+	pushUpdates := logicalID(t, template, "AWS::Lambda::Function", "PushUpdates")
+	history := tableID(t, template, "storm-charts")
+
+	template.HasResourceProperties(jsii.String("AWS::Events::Rule"), map[string]any{
+		"ScheduleExpression": "rate(5 minutes)",
+		"Targets": []any{map[string]any{
+			"Arn": map[string]any{"Fn::GetAtt": []any{progressCheck, "Arn"}},
+		}},
+	})
+
+	template.HasResourceProperties(jsii.String("AWS::Lambda::EventSourceMapping"), map[string]any{
+		"EventSourceArn":             map[string]any{"Fn::GetAtt": []any{history, "StreamArn"}},
+		"FunctionName":               map[string]any{"Ref": pushUpdates},
+		"BatchSize":                  PushUpdatesBatchSize,
+		"StartingPosition":           "LATEST",
+		"BisectBatchOnFunctionError": false,
+		"MaximumRetryAttempts":       PushUpdatesRetryAttempts,
+		"ParallelizationFactor":      1,
+		"FunctionResponseTypes":      []any{"ReportBatchItemFailures"},
+		"DestinationConfig": map[string]any{"OnFailure": map[string]any{
+			"Destination": map[string]any{"Fn::GetAtt": []any{logicalID(t, template, "AWS::SQS::Queue", "stormpushupdatesfailed"), "Arn"}},
+		}},
+	})
+	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]any{
+		"MessageRetentionPeriod": 14 * 24 * 60 * 60,
+	})
+
+	for _, function := range []string{"ProgressCheck", "PushUpdates", "SlackCommands", "FCMDevices"} {
+		template.HasResourceProperties(jsii.String("AWS::Lambda::Url"), map[string]any{
+			"AuthType":          "NONE",
+			"TargetFunctionArn": map[string]any{"Fn::GetAtt": []any{logicalID(t, template, "AWS::Lambda::Function", function), "Arn"}},
+			"InvokeMode":        assertions.Match_Absent(),
+		})
+	}
+	template.HasResourceProperties(jsii.String("AWS::Lambda::Url"), map[string]any{
+		"AuthType":          "NONE",
+		"InvokeMode":        "RESPONSE_STREAM",
+		"TargetFunctionArn": map[string]any{"Fn::GetAtt": []any{logicalID(t, template, "AWS::Lambda::Function", "LiveProgress"), "Arn"}},
+	})
+}
+
+func TestCdkStack_FlushSchedule(t *testing.T) {
+	// Without a coalescing window there is nothing to flush
+	synth(t, config.ProfileProd).ResourcePropertiesCountIs(jsii.String("AWS::Events::Rule"), map[string]any{
+		"Targets": []any{map[string]any{"Input": `{"Records":[]}`}},
+	}, jsii.Number(0))
+
+	cfg := config.Profiles[config.ProfileProd]
+	cfg.CoalesceWindow = config.Duration{Duration: 30 * time.Minute}
+	template := synthConfig(t, cfg)
+	template.ResourceCountIs(jsii.String("AWS::Events::Rule"), jsii.Number(2))
+	template.HasResourceProperties(jsii.String("AWS::Events::Rule"), map[string]any{
+		"ScheduleExpression": "rate(1 minute)",
+		"Targets": []any{map[string]any{
+			"Arn":   map[string]any{"Fn::GetAtt": []any{logicalID(t, template, "AWS::Lambda::Function", "PushUpdates"), "Arn"}},
+			"Input": `{"Records":[]}`,
+		}},
+	})
+}
+
+func TestCdkStack_Permissions(t *testing.T) {
+	template := synth(t, config.ProfileProd)
+
+	// Actions are listed in the order CDK grants them
+	readWrite := []any{"dynamodb:Scan", "dynamodb:PutItem", "dynamodb:DeleteItem"}
+	testCases := []struct {
+		function string
+		actions  []any
+		table    string
+		// stream is true for permissions on the table's stream rather than the table
+		stream bool
+	}{
+		{function: "ProgressCheck", actions: []any{"dynamodb:Query", "dynamodb:PutItem"}, table: "storm-charts"},
+		{function: "ProgressCheck", actions: readWrite, table: "storm-webpush-subscriptions"},
+		{function: "PushUpdates", actions: []any{"dynamodb:Query", "dynamodb:PutItem", "dynamodb:DeleteItem"}, table: "storm-charts"},
+		{function: "PushUpdates", actions: []any{"dynamodb:GetRecords", "dynamodb:GetShardIterator"}, table: "storm-charts", stream: true},
+		{function: "PushUpdates", actions: readWrite, table: "storm-slack-subscriptions"},
+		{function: "PushUpdates", actions: readWrite, table: "storm-fcm-devices"},
+		{function: "PushUpdates", actions: readWrite, table: "storm-webpush-subscriptions"},
+		{function: "SlackCommands", actions: []any{"dynamodb:Query"}, table: "storm-charts"},
+		{function: "SlackCommands", actions: readWrite, table: "storm-slack-subscriptions"},
+		{function: "FCMDevices", actions: readWrite, table: "storm-fcm-devices"},
+		{function: "LiveProgress", actions: []any{"dynamodb:Query"}, table: "storm-charts"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.function+" "+tc.table, func(t *testing.T) {
+			attribute := "Arn"
+			if tc.stream {
+				attribute = "StreamArn"
+			}
+			var action any = assertions.Match_ArrayWith(&tc.actions)
+			if len(tc.actions) == 1 {
+				action = tc.actions[0] // A single action isn't a list
+			}
+			hasPolicy(t, template, tc.function, map[string]any{
+				"Effect":   "Allow",
+				"Action":   action,
+				"Resource": map[string]any{"Fn::GetAtt": []any{tableID(t, template, tc.table), attribute}},
+			})
+		})
+	}
+
+	// Only the functions that need the secret can read it
+	secretActions := []any{"secretsmanager:GetSecretValue"}
+	for _, function := range []string{"ProgressCheck", "PushUpdates", "SlackCommands", "FCMDevices"} {
+		hasPolicy(t, template, function, map[string]any{
+			"Effect": "Allow",
+			"Action": assertions.Match_ArrayWith(&secretActions),
+		})
+	}
+	for id, policy := range *template.FindResources(jsii.String("AWS::IAM::Policy"), map[string]any{}) {
+		document, _ := json.Marshal(policy)
+		if strings.Contains(string(document), "secretsmanager:") {
+			require.False(t, strings.HasPrefix(id, "LiveProgress"), id)
+		}
+	}
+}
+
+// hasPolicy checks that a policy attached to the function's role has the statement
+func hasPolicy(t *testing.T, template assertions.Template, function string, statement map[string]any) {
+	t.Helper()
+	role := logicalID(t, template, "AWS::IAM::Role", function+"ServiceRole")
+	statements := []any{assertions.Match_ObjectLike(&statement)}
+	roles := []any{map[string]any{"Ref": role}}
+	template.HasResourceProperties(jsii.String("AWS::IAM::Policy"), map[string]any{
+		"PolicyDocument": map[string]any{"Statement": assertions.Match_ArrayWith(&statements)},
+		"Roles":          assertions.Match_ArrayWith(&roles),
+	})
+}
+
+func TestCdkStack_Monitoring(t *testing.T) {
+	template := synth(t, config.ProfileProd)
+	template.ResourceCountIs(jsii.String("AWS::SNS::Topic"), jsii.Number(1))
+	template.ResourceCountIs(jsii.String("AWS::SNS::Subscription"), jsii.Number(0))
+	template.ResourceCountIs(jsii.String("AWS::CloudWatch::Alarm"), jsii.Number(10))
+	template.HasResourceProperties(jsii.String("AWS::CloudWatch::Dashboard"), map[string]any{
+		"DashboardName": "StormWatch",
+	})
+
+	topicID := logicalID(t, template, "AWS::SNS::Topic", "stormalarms")
+	for id, alarm := range *template.FindResources(jsii.String("AWS::CloudWatch::Alarm"), map[string]any{}) {
+		properties := (*alarm)["Properties"].(map[string]any)
+		require.Equal(t, []any{map[string]any{"Ref": topicID}}, properties["AlarmActions"], id)
+		require.Equal(t, []any{map[string]any{"Ref": topicID}}, properties["OKActions"], id)
+	}
+
+	metricAlarms := []struct {
+		metric, statistic, comparison, missingData string
+	}{
+		{metrics.ScrapeFailures, "Sum", "GreaterThanOrEqualToThreshold", "notBreaching"},
+		{metrics.ScrapeLatency, "SampleCount", "LessThanThreshold", "breaching"},
+		{metrics.PushesFailed, "Sum", "GreaterThanOrEqualToThreshold", "notBreaching"},
+	}
+	for _, alarm := range metricAlarms {
+		template.HasResourceProperties(jsii.String("AWS::CloudWatch::Alarm"), map[string]any{
+			"Namespace":          metrics.Namespace,
+			"MetricName":         alarm.metric,
+			"Statistic":          alarm.statistic,
+			"Period":             300,
+			"Dimensions":         []any{map[string]any{"Name": metrics.EnvironmentDimension, "Value": "prod"}},
+			"ComparisonOperator": alarm.comparison,
+			"TreatMissingData":   alarm.missingData,
+		})
+	}
+	template.HasResourceProperties(jsii.String("AWS::CloudWatch::Alarm"), map[string]any{
+		"Namespace":  "AWS/SQS",
+		"MetricName": "ApproximateNumberOfMessagesVisible",
+		"Dimensions": []any{map[string]any{
+			"Name":  "QueueName",
+			"Value": map[string]any{"Fn::GetAtt": []any{logicalID(t, template, "AWS::SQS::Queue", "stormpushupdatesfailed"), "QueueName"}},
+		}},
+	})
+	for _, function := range []string{"ProgressCheck", "PushUpdates", "SlackCommands", "FCMDevices", "LiveProgress"} {
+		template.HasResourceProperties(jsii.String("AWS::CloudWatch::Alarm"), map[string]any{
+			"Namespace":  "AWS/Lambda",
+			"MetricName": "Errors",
+			"Dimensions": []any{map[string]any{
+				"Name":  "FunctionName",
+				"Value": map[string]any{"Ref": logicalID(t, template, "AWS::Lambda::Function", function)},
+			}},
+		})
+	}
+
+	cfg := config.Profiles[config.ProfileStaging]
+	cfg.AlarmEmail = "ops@example.com"
+	stack := NewCdkStack(awscdk.NewApp(nil), StackName(cfg), &StormWatchCdkStackProps{Config: cfg})
+	staging := assertions.Template_FromStack(stack, nil)
+	staging.HasResourceProperties(jsii.String("AWS::SNS::Subscription"), map[string]any{
+		"Protocol": "email",
+		"Endpoint": "ops@example.com",
+	})
+	staging.HasResourceProperties(jsii.String("AWS::CloudWatch::Alarm"), map[string]any{
+		"MetricName": metrics.ScrapeFailures,
+		"Dimensions": []any{map[string]any{"Name": metrics.EnvironmentDimension, "Value": "staging"}},
+	})
+	staging.HasResourceProperties(jsii.String("AWS::CloudWatch::Dashboard"), map[string]any{
+		"DashboardName": "staging-StormWatch",
+	})
+}
+
+func TestCdkStack_Environments(t *testing.T) {
+	prodTables := tableNames(synth(t, config.ProfileProd))
+	stagingTables := tableNames(synth(t, config.ProfileStaging))
+	require.Len(t, stagingTables, 4)
+	for _, table := range stagingTables {
+		require.True(t, strings.HasPrefix(table, "staging-"), table)
+		require.NotContains(t, prodTables, table)
+	}
+	require.Equal(t, "staging-StormWatchStack", StackName(config.Profiles[config.ProfileStaging]))
+	require.Equal(t, "StormWatchStack", StackName(config.Profiles[config.ProfileProd]))
+}
+
+func tableNames(template assertions.Template) []string {
+	names := []string{}
+	for _, table := range *template.FindResources(jsii.String("AWS::DynamoDB::GlobalTable"), map[string]any{}) {
+		names = append(names, (*table)["Properties"].(map[string]any)["TableName"].(string))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TestCdkStack_Snapshot compares the synthesized templates with testdata. Run `go test -run Snapshot -update .` to
+// accept intended changes, and review the diff.
+func TestCdkStack_Snapshot(t *testing.T) {
+	for _, profile := range []string{config.ProfileProd, config.ProfileStaging} {
+		t.Run(profile, func(t *testing.T) {
+			template := *synth(t, profile).ToJSON()
+			normalizeAssets(template)
+			var buf bytes.Buffer
+			encoder := json.NewEncoder(&buf)
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("", "  ")
+			require.NoError(t, encoder.Encode(template))
+			actual := buf.Bytes()
+
+			path := filepath.Join("testdata", StackName(config.Profiles[profile])+".template.json")
+			if *updateSnapshots {
+				require.NoError(t, os.MkdirAll("testdata", 0o755))
+				require.NoError(t, os.WriteFile(path, actual, 0o644))
+			}
+			expected, err := os.ReadFile(path)
+			require.NoError(t, err, "run with -update to create the snapshot")
+			require.Equal(t, string(expected), string(actual))
+		})
+	}
+}
+
+// normalizeAssets replaces the Lambda code asset hashes, which change with every code change, with a placeholder
+func normalizeAssets(template map[string]any) {
+	for _, resource := range template["Resources"].(map[string]any) {
+		resource := resource.(map[string]any)
+		if resource["Type"] != "AWS::Lambda::Function" {
+			continue
+		}
+		code := resource["Properties"].(map[string]any)["Code"].(map[string]any)
+		code["S3Key"] = "<asset>"
+	}
+}
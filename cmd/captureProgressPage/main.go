@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/backfill"
+	"github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+// Saves a capture of the progress page to the parser's fixture corpus, so that the parser is tested against its layout.
+// The capture is named after when it was archived and the layout it shows, e.g. 20231020150312-shopify.html.
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: captureProgressPage [flags] <layout>")
+		flag.PrintDefaults()
+	}
+	wayback := flag.String("wayback", "", "Wayback Machine timestamp of an archived copy to capture, e.g. 20160412083015 (default the live page)")
+	url := flag.String("url", "", "page to capture (default the configured progress URL)")
+	dir := flag.String("dir", filepath.Join("internal", "progress", "testdata", "captures"), "fixture corpus directory")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	layout := flag.Arg(0)
+
+	ctx := context.Background()
+	if *url == "" {
+		cfg, err := config.Load(ctx, config.LoadOptions{})
+		if err != nil {
+			log.Fatalf("load config: %s", err)
+		}
+		*url = cfg.ProgressURL
+	}
+
+	var (
+		timestamp time.Time
+		html      string
+		err       error
+	)
+	if *wayback != "" {
+		timestamp, err = time.Parse(backfill.WaybackTimestampLayout, *wayback)
+		if err != nil {
+			log.Fatalf("-wayback %q is not a Wayback timestamp, e.g. 20160412083015", *wayback)
+		}
+		source := backfill.WaybackSource{URL: *url}
+		html, err = source.Fetch(ctx, source.SnapshotAt(timestamp))
+	} else {
+		timestamp = time.Now()
+		html, err = fetch(ctx, *url)
+	}
+	if err != nil {
+		log.Fatalf("fetch page: %s", err)
+	}
+
+	name := fmt.Sprintf("%s-%s", timestamp.UTC().Format(backfill.WaybackTimestampLayout), layout)
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		log.Fatalf("create corpus directory: %s", err)
+	}
+	path := filepath.Join(*dir, name+".html")
+	if err := os.WriteFile(path, []byte(html), 0o644); err != nil {
+		log.Fatalf("write capture: %s", err)
+	}
+	fmt.Println("Saved", path)
+
+	wips, err := progress.ParseProgressFromHTML(html)
+	if err != nil {
+		fmt.Println("\tThe parser cannot read it:", err)
+	}
+	for _, wip := range wips {
+		fmt.Println("\t", wip.String())
+	}
+	fmt.Printf("Write its golden file with: go test ./internal/progress -run TestParseProgressFromHTML_Captures/%s -update\n", name)
+}
+
+func fetch(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("new request: %w", err)
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("execute request: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: status %s", url, response.Status)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("read response body: %w", err)
+	}
+	return string(body), nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/webpush"
+)
+
+// Generates a VAPID key for web push. Store the private key as VAPID_PRIVATE_KEY in the profile's secret. Replacing
+// the key invalidates every existing subscription.
+func main() {
+	cfg, err := config.Load(context.Background(), config.LoadOptions{})
+	if err != nil {
+		panic(err)
+	}
+	key, err := webpush.GenerateVAPIDKey()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Secret:           ", cfg.SecretName)
+	fmt.Println("VAPID_PRIVATE_KEY:", key.PrivateKey())
+	fmt.Println("Public key:       ", key.PublicKey())
+}
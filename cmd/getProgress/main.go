@@ -1,20 +1,26 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 
+	"github.com/Rhionin/SanderServer/internal/config"
 	"github.com/Rhionin/SanderServer/internal/progress"
 )
 
 func main() {
+	cfg, err := config.Load(context.Background(), config.LoadOptions{})
+	if err != nil {
+		log.Fatalf("load config: %s", err)
+	}
 	checker := progress.WebProgressChecker{
-		URL: "http://brandonsanderson.com",
+		URL: cfg.ProgressURL,
 	}
 
-	latestProgress, err := checker.GetProgress()
+	latestProgress, err := checker.GetProgress(context.Background())
 	if err != nil {
 		log.Fatalf("get progress: %s", err)
 	}
@@ -29,7 +35,7 @@ func main() {
 		fmt.Println("\tNo works in progress detected...")
 		page = progress.ErrorPageContent
 	} else {
-		page, err = progress.CreateStatusPage(latestProgress)
+		page, err = progress.CreateStatusPage(latestProgress, "")
 		if err != nil {
 			log.Fatal(err)
 		}
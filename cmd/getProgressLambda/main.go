@@ -2,15 +2,16 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"reflect"
-	"time"
 
+	"github.com/Rhionin/SanderServer/internal/config"
 	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/metrics"
 	"github.com/Rhionin/SanderServer/internal/progress"
-	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 )
 
 type httpResponse struct {
@@ -20,52 +21,75 @@ type httpResponse struct {
 }
 
 func main() {
-	lambda.Start(GetProgress)
+	storminglambdas.Start("ProgressCheck", GetProgress)
 }
 
-func GetProgress(ctx context.Context) (interface{}, error) {
-	checker := progress.WebProgressChecker{
-		URL: "http://brandonsanderson.com",
+// GetProgress serves the status page and its web push endpoints through the Function URL. Scheduled invocations
+// carry no request and only check for progress.
+func GetProgress(ctx context.Context, req events.LambdaFunctionURLRequest) (interface{}, error) {
+	ctx = logging.WithLambdaRequest(ctx)
+	switch req.RawPath {
+	case "/service-worker.js":
+		return httpResponse{
+			StatusCode: 200,
+			Headers: map[string]string{
+				"Content-Type": "text/javascript",
+			},
+			Body: string(progress.ServiceWorkerContent),
+		}, nil
+	case storminglambdas.WebPushSubscriptionsPath:
+		webPushHandler, err := storminglambdas.ContainerWebPushHandler(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("new web push handler: %w", err)
+		}
+		return webPushHandler.HandleSubscriptionRequest(ctx, req), nil
 	}
 
-	latestProgress, err := checker.GetProgress()
+	cfg, err := config.Load(ctx, config.LoadOptions{})
 	if err != nil {
-		return "", fmt.Errorf("get progress: %w", err)
+		return nil, fmt.Errorf("load config: %w", err)
 	}
-
-	historyClient, err := history.NewDynamoClientFromContext(ctx)
+	if err := logging.SetLevel(cfg.LogLevel); err != nil {
+		return nil, fmt.Errorf("set log level: %w", err)
+	}
+	awsCfg, err := cfg.AWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	historyClient, err := history.NewDynamoClient(dynamodb.NewFromConfig(awsCfg), cfg.HistoryTable)
 	if err != nil {
 		return nil, fmt.Errorf("new dynamo client: %w", err)
 	}
-	latestProgressFromHistory, err := historyClient.GetLatestProgressEntry(ctx)
-	if err != nil && !errors.Is(err, history.ErrEmptyHistory) {
-		return nil, fmt.Errorf("get latest progress entry from history: %w", err)
+	checker := storminglambdas.ProgressChecker{
+		Checker: progress.WebProgressChecker{
+			URL: cfg.ProgressURL,
+		},
+		History: historyClient,
+	}
+	scheduled := req.RequestContext.HTTP.Method == ""
+	if scheduled {
+		// Only scheduled checks are recorded, so that page views cannot hide a stalled schedule from the alarms
+		checker.Metrics = metrics.New(cfg.Profile)
+	}
+	// The correlation ID is stored with the entry, so that the pushes it causes can be found in the logs
+	ctx = logging.WithCorrelationID(ctx, logging.NewCorrelationID())
+	result, err := checker.Check(ctx)
+	if err != nil {
+		return nil, err
 	}
+	latestProgress := result.Latest.WorksInProgress
 
-	shouldAddHistoryEntry := errors.Is(err, history.ErrEmptyHistory) || !reflect.DeepEqual(latestProgressFromHistory.WorksInProgress, latestProgress)
-	if shouldAddHistoryEntry {
-		progressEntry := history.ProgressEntry{
-			Timestamp:       time.Now(),
-			WorksInProgress: latestProgress,
-		}
-		if errors.Is(err, history.ErrEmptyHistory) {
-			fmt.Println("History does not have any entries yet. Adding new entry with timestamp", progressEntry.Timestamp)
+	vapidPublicKey := ""
+	if !scheduled {
+		// Without web push, the page is still worth serving
+		if webPushHandler, err := storminglambdas.ContainerWebPushHandler(ctx); err != nil {
+			logging.FromContext(ctx).Warn("Web push is unavailable", "error", err)
 		} else {
-			fmt.Println("Current progress is different from previous history entry. Adding new entry with timestamp", progressEntry.Timestamp)
-			latestBytes, _ := json.Marshal(latestProgress)
-			fmt.Println("Current progress:", string(latestBytes))
-
-			previousBytes, _ := json.Marshal(latestProgressFromHistory)
-			fmt.Println("Previous progress:", string(previousBytes))
-		}
-		if err = historyClient.AddNewProgressEntry(ctx, progressEntry); err != nil {
-			return nil, fmt.Errorf("add new history entry: %w", err)
+			vapidPublicKey = webPushHandler.PublicKey
 		}
-	} else {
-		fmt.Println("No progress change.")
 	}
 
-	page, err := progress.CreateStatusPage(latestProgress)
+	page, err := progress.CreateStatusPage(latestProgress, vapidPublicKey)
 	if err != nil {
 		return "", fmt.Errorf("create status page: %w", err)
 	}
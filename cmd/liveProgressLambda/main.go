@@ -0,0 +1,9 @@
+package main
+
+import (
+	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+)
+
+func main() {
+	storminglambdas.Start("LiveProgress", storminglambdas.HandleLiveProgress)
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
+	_ "time/tzdata" // Notification quiet hours need time zone data, which the Lambda runtime does not provide
+
 	"github.com/Rhionin/SanderServer/internal/storminglambdas"
-	"github.com/aws/aws-lambda-go/lambda"
 )
 
 func main() {
-	lambda.Start(storminglambdas.PushUpdates)
+	storminglambdas.Start("PushUpdates", storminglambdas.PushUpdates)
 }
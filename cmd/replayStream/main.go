@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/backfill"
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/replay"
+)
+
+// Replays saved copies of the progress page through the progress check and the push handler, as the DynamoDB stream
+// would, and prints what each push target received. Nothing is sent and no AWS resources are used.
+//
+//	go run ./cmd/replayStream internal/backfill/testdata/snapshots
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: replayStream [flags] <snapshot dir>")
+		fmt.Fprintln(flag.CommandLine.Output(), "Snapshots are HTML files named after their Wayback timestamp, e.g. 20230815120000.html.")
+		flag.PrintDefaults()
+	}
+	seed := flag.String("seed", "", "history to start from, as exported by stormctl (default empty)")
+	seedFormat := flag.String("seed-format", history.FormatJSONL, "format of -seed: "+strings.Join(history.Formats, " or "))
+	targets := flag.String("targets", "demo", "comma-separated names of the recording push targets")
+	coalesce := flag.Duration("coalesce", 0, "coalescing window, e.g. 30m (default disabled)")
+	verbose := flag.Bool("v", false, "log the check and push handler")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelInfo
+	}
+	ctx := logging.WithLogger(context.Background(), logging.New(os.Stderr, level))
+
+	snapshots, err := replay.ReadSnapshots(ctx, backfill.DirectorySource{Dir: flag.Arg(0)})
+	if err != nil {
+		log.Fatalf("read snapshots: %s", err)
+	}
+	if len(snapshots) == 0 {
+		log.Fatalf("no readable snapshots in %s", flag.Arg(0))
+	}
+
+	store := history.NewMemoryStore()
+	if *seed != "" {
+		if err := seedHistory(ctx, store, *seed, *seedFormat); err != nil {
+			log.Fatalf("seed history: %s", err)
+		}
+	}
+
+	harness := &replay.Harness{
+		History:        store,
+		Targets:        replay.NewRecordingTargets(strings.Split(*targets, ",")...),
+		CoalesceWindow: *coalesce,
+	}
+	steps, err := harness.Replay(ctx, snapshots)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *coalesce > 0 {
+		// Close the last coalescing window
+		step, err := harness.Flush(ctx, snapshots[len(snapshots)-1].Timestamp.Add(*coalesce))
+		if err != nil {
+			log.Fatal(err)
+		}
+		steps = append(steps, step)
+	}
+
+	for _, step := range steps {
+		printStep(step)
+	}
+}
+
+func seedHistory(ctx context.Context, store history.Store, path, format string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = history.Import(ctx, store, file, format)
+	return err
+}
+
+func printStep(step replay.Step) {
+	timestamp := step.Snapshot.Timestamp.UTC().Format(time.RFC3339)
+	switch {
+	case step.Record != nil:
+		fmt.Printf("%s  INSERT record %s\n", timestamp, step.Record.Change.SequenceNumber)
+	case step.Snapshot.WorksInProgress != nil:
+		fmt.Printf("%s  unchanged\n", timestamp)
+	default:
+		fmt.Printf("%s  scheduled flush\n", timestamp)
+	}
+	for _, failure := range step.Response.BatchItemFailures {
+		fmt.Printf("\tfailed record %s\n", failure.ItemIdentifier)
+	}
+	for _, delivery := range step.Deliveries {
+		fmt.Printf("\t%s <- %s\n", delivery.Target, progress.Summarize(delivery.Updates))
+	}
+}
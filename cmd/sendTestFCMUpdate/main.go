@@ -2,25 +2,40 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
 
+	"firebase.google.com/go/v4/messaging"
+	"github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/dryrun"
 	"github.com/Rhionin/SanderServer/internal/firebase"
+	"github.com/Rhionin/SanderServer/internal/message"
 	"github.com/Rhionin/SanderServer/internal/progress"
 )
 
 func main() {
+	dryRun := flag.Bool("dry-run", false, "write the messages to stdout instead of sending them")
+	flag.Parse()
+
 	firebaseCredentialsConfigPath := os.Getenv("FIREBASE_CONFIG")
-	if len(firebaseCredentialsConfigPath) == 0 {
+	if len(firebaseCredentialsConfigPath) == 0 && !*dryRun {
 		panic("Must provide a FIREBASE_CONFIG path")
 	}
 
 	ctx := context.Background()
-	firebaseClient, err := firebase.NewMessagingClient(ctx, firebaseCredentialsConfigPath)
+	cfg, err := config.Load(ctx, config.LoadOptions{})
 	if err != nil {
-		fmt.Println(err)
-		panic("Failed to initialize Firebase messaging client")
+		panic(err)
+	}
+	var firebaseClient *messaging.Client
+	if !*dryRun {
+		firebaseClient, err = firebase.NewMessagingClient(ctx, firebaseCredentialsConfigPath)
+		if err != nil {
+			fmt.Println(err)
+			panic("Failed to initialize Firebase messaging client")
+		}
 	}
 
 	someConstant, err := strconv.Atoi(os.Getenv("EXTRA_CONSTANT"))
@@ -34,9 +49,27 @@ func main() {
 		{Title: "Book 4", Progress: 100, PrevProgress: 80},
 	}
 
-	response, err := firebase.SendFCMUpdate(ctx, firebaseClient, wips, "flutter_devprogress")
-	if err != nil {
+	if cfg.FCMTopic == "" {
+		panic("No FCM_TOPIC is configured. Set STORMWATCH_FCM_TOPIC, e.g. to flutter_devprogress")
+	}
+	client := firebase.NewUpdateClient(firebaseClient, cfg.FCMTopic)
+	client.Locale = os.Getenv("LOCALE")
+	client.Link = cfg.StatusPageURL
+	if *dryRun {
+		client.DryRun = dryrun.New(os.Stdout)
+	}
+	if messageTemplatesPath := os.Getenv("MESSAGE_TEMPLATES"); messageTemplatesPath != "" {
+		configs, err := message.LoadConfigFile(messageTemplatesPath)
+		if err != nil {
+			panic(err)
+		}
+		client.Messages, err = message.New("fcm", configs["fcm"], firebase.DefaultMessages)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if err := client.SendUpdate(ctx, wips); err != nil {
 		fmt.Printf("Error sending flutter FCM update: %s\n", err)
 	}
-	fmt.Println(response)
 }
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"os"
 
+	"github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/message"
 	"github.com/Rhionin/SanderServer/internal/progress"
 	"github.com/Rhionin/SanderServer/internal/slack"
 )
@@ -14,8 +18,30 @@ var (
 )
 
 func main() {
+	dryRun := flag.Bool("dry-run", false, "write the post to stdout instead of sending it")
+	flag.Parse()
+
+	cfg, err := config.Load(context.Background(), config.LoadOptions{})
+	if err != nil {
+		log.Fatalf("Load config failed: %s", err)
+	}
 	channelOverride := "#cjc-slack-testing"
 	updateClient := slack.NewUpdateClient(slackWebhookURL, channelOverride)
+	updateClient.Locale = os.Getenv("LOCALE")
+	updateClient.StatusPageURL = cfg.StatusPageURL
+	if *dryRun {
+		updateClient.DryRun = dryrun.New(os.Stdout)
+	}
+	if messageTemplatesPath := os.Getenv("MESSAGE_TEMPLATES"); messageTemplatesPath != "" {
+		configs, err := message.LoadConfigFile(messageTemplatesPath)
+		if err != nil {
+			log.Fatalf("Load message templates failed: %s", err)
+		}
+		updateClient.Messages, err = message.New(updateClient.GetName(), configs[updateClient.GetName()], slack.DefaultMessages)
+		if err != nil {
+			log.Fatalf("Load message templates failed: %s", err)
+		}
+	}
 
 	updates := []progress.ProgressUpdate{
 		{Title: "Book 1", Progress: 25},
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/backfill"
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+)
+
+type (
+	cli struct {
+		store history.Store
+		out   io.Writer
+		in    io.Reader
+		// progressURL is the page whose archived copies backfill uses
+		progressURL string
+		// newPushTargets builds the push targets, which write their requests to dryRunOutput if it is set. It is only
+		// called by push, as it needs the secrets.
+		newPushTargets func(ctx context.Context, dryRunOutput string) ([]storminglambdas.PushTarget, error)
+	}
+
+	command struct {
+		summary string
+		run     func(c *cli, ctx context.Context, args []string) error
+	}
+)
+
+var (
+	commands = map[string]command{
+		"list":     {"list the latest entries, newest first: list [-limit n]", (*cli).list},
+		"show":     {"show an entry: show <timestamp>", (*cli).show},
+		"diff":     {"show what changed between two entries: diff <from> <to>", (*cli).diff},
+		"delete":   {"delete an entry: delete <timestamp>", (*cli).delete},
+		"correct":  {"set or remove works in an entry: correct <timestamp> <title>=<percent>... (an empty percent removes the work)", (*cli).correct},
+		"export":   {"write every entry, oldest first: export [-format jsonl|csv] [-o file]", (*cli).export},
+		"import":   {"add the entries in an export, skipping timestamps already in the history: import [-format jsonl|csv] [-i file]", (*cli).importEntries},
+		"push":     {"send the notifications for an entry again: push <timestamp>", (*cli).push},
+		"backfill": {"add entries from before the history began, from archived copies of the progress page: backfill [-dir path] [-from date] [-to date] [-dry-run]", (*cli).backfill},
+	}
+	commandNames = []string{"list", "show", "diff", "delete", "correct", "export", "import", "push", "backfill"}
+)
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	limit := flags.Int("limit", 20, "number of entries to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	entries, err := c.store.GetProgressEntries(ctx, int32(*limit))
+	if err != nil {
+		return fmt.Errorf("get progress entries: %w", err)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", formatTimestamp(entry.Timestamp), entry.Timestamp.UnixNano(), formatWorks(entry.WorksInProgress))
+	}
+	return w.Flush()
+}
+
+func (c *cli) show(ctx context.Context, args []string) error {
+	entry, err := c.getEntry(ctx, args, 1)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, formatTimestamp(entry.Timestamp))
+	for _, wip := range entry.WorksInProgress {
+		fmt.Fprintln(c.out, "\t"+wip.String())
+	}
+	return nil
+}
+
+func (c *cli) diff(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("diff needs two timestamps")
+	}
+	from, err := c.getEntry(ctx, args[:1], 1)
+	if err != nil {
+		return err
+	}
+	to, err := c.getEntry(ctx, args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	changes := diffEntries(from.WorksInProgress, to.WorksInProgress)
+	if len(changes) == 0 {
+		fmt.Fprintln(c.out, "No changes")
+	}
+	for _, change := range changes {
+		fmt.Fprintln(c.out, change)
+	}
+	return nil
+}
+
+func (c *cli) delete(ctx context.Context, args []string) error {
+	entry, err := c.getEntry(ctx, args, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.store.DeleteProgressEntry(ctx, entry.Timestamp); err != nil {
+		return fmt.Errorf("delete entry: %w", err)
+	}
+	fmt.Fprintf(c.out, "Deleted %s: %s\n", formatTimestamp(entry.Timestamp), formatWorks(entry.WorksInProgress))
+	return nil
+}
+
+// correct rewrites an entry in place. The stream sees a modification rather than an insert, so nothing is pushed.
+func (c *cli) correct(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errors.New("correct needs a timestamp and at least one <title>=<percent>")
+	}
+	entry, err := c.getEntry(ctx, args[:1], 1)
+	if err != nil {
+		return err
+	}
+
+	works := slices.Clone(entry.WorksInProgress)
+	for _, arg := range args[1:] {
+		title, percent, ok := strings.Cut(arg, "=")
+		if !ok || title == "" {
+			return fmt.Errorf("invalid correction %q, expected <title>=<percent>", arg)
+		}
+		i := slices.IndexFunc(works, func(wip progress.WorkInProgress) bool { return wip.Title == title })
+		if percent == "" {
+			if i < 0 {
+				return fmt.Errorf("entry has no work titled %q", title)
+			}
+			works = slices.Delete(works, i, i+1)
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSuffix(percent, "%"))
+		if err != nil || value < 0 || value > 100 {
+			return fmt.Errorf("invalid percent %q for %q", percent, title)
+		}
+		if i < 0 {
+			works = append(works, progress.WorkInProgress{Title: title, Progress: value})
+		} else {
+			works[i].Progress = value
+		}
+	}
+	if len(works) == 0 {
+		return errors.New("an entry must keep at least one work; delete it instead")
+	}
+
+	entry.WorksInProgress = works
+	if err := c.store.AddNewProgressEntry(ctx, entry); err != nil {
+		return fmt.Errorf("write entry: %w", err)
+	}
+	fmt.Fprintf(c.out, "Corrected %s: %s\n", formatTimestamp(entry.Timestamp), formatWorks(entry.WorksInProgress))
+	return nil
+}
+
+func (c *cli) export(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", history.FormatJSONL, "export format: "+strings.Join(history.Formats, " or "))
+	output := flags.String("o", "", "file to write (default stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	out := c.out
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("create export file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	count, err := history.Export(ctx, c.store, out, *format)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	if *output != "" {
+		fmt.Fprintf(c.out, "Exported %d entries to %s\n", count, *output)
+	}
+	return nil
+}
+
+// importEntries adds the entries in an export, marked historical so that no notification is pushed for them. Entries
+// with the timestamp of an existing entry are skipped.
+func (c *cli) importEntries(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", history.FormatJSONL, "import format: "+strings.Join(history.Formats, " or "))
+	input := flags.String("i", "", "file to read (default stdin)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	in := c.in
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("open import file: %w", err)
+		}
+		defer file.Close()
+		in = file
+	}
+	result, err := history.Import(ctx, c.store, in, *format)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	fmt.Fprintf(c.out, "Imported %d entries, skipped %d duplicates\n", result.Imported, result.Duplicates)
+	return nil
+}
+
+// push sends the notifications for the changes between an entry and the one before it, as the stream would have
+func (c *cli) push(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("push", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "write the requests instead of sending them")
+	output := flags.String("o", dryrun.Stdout, "file to write the requests to, with -dry-run (default stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	entry, err := c.getEntry(ctx, flags.Args(), 1)
+	if err != nil {
+		return err
+	}
+	previous, err := c.store.GetLatestProgressEntryBeforeID(ctx, history.ProgressDynamoEntry{
+		TimestampUnixNano: entry.Timestamp.UnixNano(),
+	})
+	if errors.Is(err, history.ErrNoEntryBeforeTarget) {
+		return errors.New("the entry is the first in the history, so there is nothing to push")
+	} else if err != nil {
+		return fmt.Errorf("get previous entry: %w", err)
+	}
+
+	dryRunOutput := ""
+	if *dryRun {
+		dryRunOutput = *output
+	}
+	pushTargets, err := c.newPushTargets(ctx, dryRunOutput)
+	if err != nil {
+		return fmt.Errorf("new push targets: %w", err)
+	}
+	if len(pushTargets) == 0 {
+		return errors.New("no push targets are configured")
+	}
+
+	// Coalescing is left disabled, so that the entry is pushed straight away
+	handler := &storminglambdas.PushUpdateHandler{
+		History:     c.store,
+		PushTargets: pushTargets,
+		DryRun:      *dryRun,
+	}
+	if err := handler.PushEntry(ctx, entry, previous); err != nil {
+		return fmt.Errorf("push entry: %w", err)
+	}
+	verb := "Pushed"
+	if *dryRun {
+		verb = "Rendered"
+	}
+	fmt.Fprintf(c.out, "%s %s\n", verb, progress.Summarize(progress.GetProgressUpdate(entry.WorksInProgress, previous.WorksInProgress)))
+	return nil
+}
+
+// backfill adds entries from the Wayback Machine, or from a directory of saved copies. They are marked historical, so
+// that no notification is pushed for them.
+func (c *cli) backfill(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory of saved copies named after their Wayback timestamps, e.g. 20230815120000.html (default the Wayback Machine)")
+	from := flags.String("from", "", "only use copies archived on or after this date, e.g. 2021-01-31")
+	to := flags.String("to", "", "only use copies archived on or before this date")
+	dryRun := flags.Bool("dry-run", false, "list the entries without writing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var source backfill.Source = backfill.DirectorySource{Dir: *dir}
+	if *dir == "" {
+		wayback := &backfill.WaybackSource{URL: c.progressURL}
+		var err error
+		if wayback.From, err = parseDate(*from); err != nil {
+			return err
+		}
+		if wayback.To, err = parseDate(*to); err != nil {
+			return err
+		}
+		if !wayback.To.IsZero() {
+			wayback.To = wayback.To.Add(24*time.Hour - time.Second)
+		}
+		source = wayback
+	} else if *from != "" || *to != "" {
+		return errors.New("-from and -to only apply to the Wayback Machine")
+	}
+
+	backfiller := &backfill.Backfiller{Source: source, History: c.store, DryRun: *dryRun}
+	result, err := backfiller.Run(ctx)
+	if err != nil {
+		return fmt.Errorf("backfill: %w", err)
+	}
+	for _, entry := range result.Entries {
+		fmt.Fprintf(c.out, "%s  %s\n", formatTimestamp(entry.Timestamp), formatWorks(entry.WorksInProgress))
+	}
+	verb := "Added"
+	if *dryRun {
+		verb = "Would add"
+	}
+	fmt.Fprintf(c.out, "%s %d entries; skipped %d unchanged, %d unparseable and %d overlapping snapshots\n",
+		verb, len(result.Entries), result.Unchanged, result.Unparseable, result.Overlapping)
+	return nil
+}
+
+// parseDate parses a date such as 2021-01-31, or returns the zero time for ""
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected e.g. 2021-01-31", s)
+	}
+	return date, nil
+}
+
+// getEntry returns the entry at the timestamp in args, which must hold exactly n arguments
+func (c *cli) getEntry(ctx context.Context, args []string, n int) (history.ProgressEntry, error) {
+	if len(args) != n {
+		return history.ProgressEntry{}, errors.New("expected a timestamp")
+	}
+	timestamp, err := parseTimestamp(args[0])
+	if err != nil {
+		return history.ProgressEntry{}, err
+	}
+	entry, err := c.store.GetProgressEntry(ctx, timestamp)
+	if err != nil {
+		return history.ProgressEntry{}, fmt.Errorf("get entry %s: %w", args[0], err)
+	}
+	return entry, nil
+}
+
+// parseTimestamp parses an RFC 3339 timestamp, or Unix nanoseconds
+func parseTimestamp(s string) (time.Time, error) {
+	if nanos, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, nanos).UTC(), nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q, expected RFC 3339 or Unix nanoseconds", s)
+	}
+	return timestamp, nil
+}
+
+func formatTimestamp(timestamp time.Time) string {
+	return timestamp.UTC().Format(time.RFC3339Nano)
+}
+
+func formatWorks(wips []progress.WorkInProgress) string {
+	works := make([]string, len(wips))
+	for i, wip := range wips {
+		works[i] = wip.String()
+	}
+	return strings.Join(works, ", ")
+}
+
+// diffEntries describes how every work changed between two entries, in the order of the later entry followed by the
+// works that were removed
+func diffEntries(from, to []progress.WorkInProgress) []string {
+	previous := map[string]int{}
+	for _, wip := range from {
+		previous[wip.Title] = wip.Progress
+	}
+
+	changes := []string{}
+	for _, wip := range to {
+		prev, ok := previous[wip.Title]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("+ %s: %d%%", wip.Title, wip.Progress))
+		case prev != wip.Progress:
+			changes = append(changes, fmt.Sprintf("~ %s: %d%% => %d%%", wip.Title, prev, wip.Progress))
+		}
+		delete(previous, wip.Title)
+	}
+	for _, wip := range from {
+		if _, removed := previous[wip.Title]; removed {
+			changes = append(changes, fmt.Sprintf("- %s: %d%%", wip.Title, wip.Progress))
+		}
+	}
+	return changes
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePushTarget struct {
+	updates [][]progress.ProgressUpdate
+}
+
+func (t *fakePushTarget) GetName() string { return "fake" }
+
+func (t *fakePushTarget) SendUpdate(ctx context.Context, updates []progress.ProgressUpdate) error {
+	t.updates = append(t.updates, updates)
+	return nil
+}
+
+var (
+	first = history.ProgressEntry{Timestamp: time.Unix(100, 0).UTC(), WorksInProgress: []progress.WorkInProgress{
+		{Title: "Moment Zero 2.0", Progress: 80},
+		{Title: "Skyward Flight", Progress: 10},
+	}}
+	second = history.ProgressEntry{Timestamp: time.Unix(200, 0).UTC(), WorksInProgress: []progress.WorkInProgress{
+		{Title: "Moment Zero 2.0", Progress: 90},
+		{Title: "Dragonsteel", Progress: 5},
+	}}
+)
+
+func newTestCLI(t *testing.T, entries ...history.ProgressEntry) (*cli, *bytes.Buffer, *fakePushTarget) {
+	store := history.NewMemoryStore()
+	for _, entry := range entries {
+		require.NoError(t, store.AddNewProgressEntry(context.Background(), entry))
+	}
+	out := &bytes.Buffer{}
+	target := &fakePushTarget{}
+	return &cli{
+		store: store,
+		out:   out,
+		in:    strings.NewReader(""),
+		newPushTargets: func(ctx context.Context, dryRunOutput string) ([]storminglambdas.PushTarget, error) {
+			return []storminglambdas.PushTarget{target}, nil
+		},
+	}, out, target
+}
+
+func run(c *cli, args ...string) error {
+	return commands[args[0]].run(c, context.Background(), args[1:])
+}
+
+func nanos(entry history.ProgressEntry) string {
+	return strconv.FormatInt(entry.Timestamp.UnixNano(), 10)
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+		err      string
+	}{
+		{
+			name:     "list",
+			args:     []string{"list", "-limit", "1"},
+			expected: "1970-01-01T00:03:20Z  200000000000  Moment Zero 2.0 (90%), Dragonsteel (5%)\n",
+		},
+		{
+			name:     "show by RFC 3339",
+			args:     []string{"show", "1970-01-01T00:01:40Z"},
+			expected: "1970-01-01T00:01:40Z\n\tMoment Zero 2.0 (80%)\n\tSkyward Flight (10%)\n",
+		},
+		{
+			name: "show unknown entry",
+			args: []string{"show", "1"},
+			err:  history.ErrEntryNotFound.Error(),
+		},
+		{
+			name: "show invalid timestamp",
+			args: []string{"show", "yesterday"},
+			err:  "invalid timestamp",
+		},
+		{
+			name:     "diff",
+			args:     []string{"diff", nanos(first), nanos(second)},
+			expected: "~ Moment Zero 2.0: 80% => 90%\n+ Dragonsteel: 5%\n- Skyward Flight: 10%\n",
+		},
+		{
+			name:     "diff unchanged",
+			args:     []string{"diff", nanos(first), nanos(first)},
+			expected: "No changes\n",
+		},
+		{
+			name:     "correct",
+			args:     []string{"correct", nanos(second), "Moment Zero 2.0=95", "Dragonsteel=", "Isles of the Emberdark=1%"},
+			expected: "Corrected 1970-01-01T00:03:20Z: Moment Zero 2.0 (95%), Isles of the Emberdark (1%)\n",
+		},
+		{
+			name: "correct invalid percent",
+			args: []string{"correct", nanos(second), "Dragonsteel=101"},
+			err:  `invalid percent "101"`,
+		},
+		{
+			name: "correct removing every work",
+			args: []string{"correct", nanos(first), "Moment Zero 2.0=", "Skyward Flight="},
+			err:  "delete it instead",
+		},
+		{
+			name:     "delete",
+			args:     []string{"delete", nanos(first)},
+			expected: "Deleted 1970-01-01T00:01:40Z: Moment Zero 2.0 (80%), Skyward Flight (10%)\n",
+		},
+		{
+			name: "push first entry",
+			args: []string{"push", nanos(first)},
+			err:  "nothing to push",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, out, _ := newTestCLI(t, first, second)
+			err := run(c, tc.args...)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func TestCommands_Delete(t *testing.T) {
+	c, _, _ := newTestCLI(t, first, second)
+	require.NoError(t, run(c, "delete", nanos(second)))
+
+	entries, err := c.store.GetProgressEntries(context.Background(), 10)
+	require.NoError(t, err)
+	require.Equal(t, []history.ProgressEntry{first}, entries)
+}
+
+func TestCommands_ExportImport(t *testing.T) {
+	for _, format := range history.Formats {
+		t.Run(format, func(t *testing.T) {
+			c, out, _ := newTestCLI(t, second, first)
+			require.NoError(t, run(c, "export", "-format", format))
+			exported := out.String()
+
+			imported, out, _ := newTestCLI(t, first)
+			imported.in = strings.NewReader(exported)
+			require.NoError(t, run(imported, "import", "-format", format))
+			require.Equal(t, "Imported 1 entries, skipped 1 duplicates\n", out.String())
+
+			entries, err := imported.store.GetProgressEntries(context.Background(), 10)
+			require.NoError(t, err)
+			historical := second
+			historical.Historical = true
+			require.Equal(t, []history.ProgressEntry{historical, first}, entries)
+		})
+	}
+}
+
+func TestCommands_PushDryRun(t *testing.T) {
+	c, out, target := newTestCLI(t, first, second)
+	var dryRunOutput string
+	c.newPushTargets = func(ctx context.Context, output string) ([]storminglambdas.PushTarget, error) {
+		dryRunOutput = output
+		return []storminglambdas.PushTarget{target}, nil
+	}
+	require.NoError(t, run(c, "push", "-dry-run", "-o", "requests.jsonl", nanos(second)))
+
+	require.Equal(t, "requests.jsonl", dryRunOutput)
+	require.Len(t, target.updates, 1)
+	require.True(t, strings.HasPrefix(out.String(), "Rendered "))
+}
+
+func TestCommands_Push(t *testing.T) {
+	c, out, target := newTestCLI(t, first, second)
+	require.NoError(t, run(c, "push", nanos(second)))
+
+	require.Len(t, target.updates, 1)
+	require.Equal(t, progress.GetProgressUpdate(second.WorksInProgress, first.WorksInProgress), target.updates[0])
+	require.True(t, strings.HasPrefix(out.String(), "Pushed "))
+}
+
+func TestCommands_Backfill(t *testing.T) {
+	c, out, _ := newTestCLI(t)
+	require.NoError(t, run(c, "backfill", "-dir", "../../internal/backfill/testdata/snapshots", "-dry-run"))
+	require.Equal(t, `2021-01-01T00:00:00Z  Stormlight 4 (30%), Mistborn Era 2, Book 4 (10%)
+2021-03-01T00:00:00Z  Stormlight 4 (55%), Mistborn Era 2, Book 4 (10%)
+Would add 2 entries; skipped 2 unchanged, 1 unparseable and 0 overlapping snapshots
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, run(c, "backfill", "-dir", "../../internal/backfill/testdata/snapshots"))
+	entries, err := c.store.GetProgressEntries(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		require.True(t, entry.Historical)
+	}
+}
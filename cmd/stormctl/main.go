@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	appconfig "github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+)
+
+// Inspects and manages the progress history. Entries are identified by their timestamp, as printed by `list`, in
+// RFC 3339 or as Unix nanoseconds.
+func main() {
+	flag.Usage = usage
+	configFile := flag.String("config", "", "config file, layered over the profile (default $"+appconfig.FileEnvVar+")")
+	profile := flag.String("profile", "", "config profile: dev, staging or prod (default $"+appconfig.ProfileEnvVar+", then prod)")
+	historyKind := flag.String("history", storminglambdas.HistoryDynamo, "history to manage: file or dynamo")
+	historyFile := flag.String("history-file", "stormwatch-history.json", "history file, with -history=file")
+	secretsFile := flag.String("secrets", "", "JSON file in the format of the StormlightArchive secret, for push. With -history=dynamo the secret is loaded from Secrets Manager instead.")
+	flag.Parse()
+
+	name := flag.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := appconfig.Load(ctx, appconfig.LoadOptions{
+		Profile:    *profile,
+		File:       *configFile,
+		LoadSecret: name == "push" && *historyKind == storminglambdas.HistoryDynamo,
+		SecretFile: *secretsFile,
+	})
+	if err != nil {
+		fatal(fmt.Errorf("load config: %w", err))
+	}
+	store, stores, err := storminglambdas.OpenHistory(ctx, cfg, *historyKind, *historyFile)
+	if err != nil {
+		fatal(fmt.Errorf("open history: %w", err))
+	}
+
+	c := &cli{
+		store:       store,
+		out:         os.Stdout,
+		in:          os.Stdin,
+		progressURL: cfg.ProgressURL,
+		newPushTargets: func(ctx context.Context, dryRunOutput string) ([]storminglambdas.PushTarget, error) {
+			secrets := storminglambdas.StormlightArchive{}
+			if cfg.Secret != nil {
+				secrets, err = storminglambdas.ParseStormlightArchive(cfg.Secret)
+				if err != nil {
+					return nil, err
+				}
+			}
+			targetCfg := cfg
+			if dryRunOutput != "" {
+				targetCfg.DryRunOutput = dryRunOutput
+			}
+			return storminglambdas.NewPushTargets(ctx, targetCfg, secrets, stores)
+		},
+	}
+	if err := cmd.run(c, ctx, flag.Args()[1:]); err != nil {
+		fatal(err)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "Usage: stormctl [flags] <command> [arguments]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, name := range commandNames {
+		fmt.Fprintf(out, "  %-8s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Flags:")
+	flag.PrintDefaults()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "stormctl:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	appconfig "github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/server"
+	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+	"github.com/Rhionin/SanderServer/internal/tracing"
+	"github.com/Rhionin/SanderServer/internal/webpush"
+)
+
+// Runs the whole service on a single box: progress checks on a ticker, notifications pushed in-process, and the
+// status page, API and live progress over HTTP.
+//
+// Slack subscriptions, FCM devices and web push subscriptions are only kept in DynamoDB, so without -history=dynamo
+// only the targets that need no subscribers (Slack webhooks and the FCM topic) are notified. Don't run this against
+// the DynamoDB history while the Lambdas are deployed, or every update is pushed twice.
+func main() {
+	configFile := flag.String("config", "", "config file, layered over the profile (default $"+appconfig.FileEnvVar+")")
+	profile := flag.String("profile", "", "config profile: dev, staging or prod (default $"+appconfig.ProfileEnvVar+", then prod)")
+	addr := flag.String("addr", ":8080", "address to serve HTTP on")
+	historyKind := flag.String("history", "file", "where to keep the history: memory, file or dynamo")
+	historyFile := flag.String("history-file", "stormwatch-history.json", "history file, with -history=file")
+	secretsFile := flag.String("secrets", "", "JSON file in the format of the StormlightArchive secret. With -history=dynamo the secret is loaded from Secrets Manager instead; otherwise no notifications are sent without it.")
+	dryRun := flag.Bool("dry-run", false, "write the notifications to stdout instead of sending them, unless $"+appconfig.EnvPrefix+"DRY_RUN_OUTPUT names a file")
+	flag.Parse()
+	logging.Init()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := appconfig.Load(ctx, appconfig.LoadOptions{
+		Profile:    *profile,
+		File:       *configFile,
+		LoadSecret: *historyKind == storminglambdas.HistoryDynamo,
+		SecretFile: *secretsFile,
+	})
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	if *dryRun && cfg.DryRunOutput == "" {
+		cfg.DryRunOutput = dryrun.Stdout
+	}
+	if err := logging.SetLevel(cfg.LogLevel); err != nil {
+		fatal("Failed to set log level", err)
+	}
+	if err := tracing.Setup(ctx, tracing.Options{
+		Exporter:     cfg.TraceExporter,
+		OTLPEndpoint: cfg.OTLPEndpoint,
+		ServiceName:  "stormwatchd",
+	}); err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracing.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Failed to export spans", "error", err)
+		}
+	}()
+
+	historyStore, stores, err := storminglambdas.OpenHistory(ctx, cfg, *historyKind, *historyFile)
+	if err != nil {
+		fatal("Failed to create history store", err)
+	}
+
+	secrets := storminglambdas.StormlightArchive{}
+	if cfg.Secret != nil {
+		secrets, err = storminglambdas.ParseStormlightArchive(cfg.Secret)
+		if err != nil {
+			fatal("Failed to parse secrets", err)
+		}
+	}
+	pushTargets, err := storminglambdas.NewPushTargets(ctx, cfg, secrets, stores)
+	if err != nil {
+		fatal("Failed to create push targets", err)
+	}
+	for _, target := range pushTargets {
+		slog.Info("Pushing updates", "target", target.GetName())
+	}
+
+	checker := &storminglambdas.ProgressChecker{
+		Checker: progress.WebProgressChecker{URL: cfg.ProgressURL},
+		History: historyStore,
+	}
+	srv := server.New(checker, historyStore, pushTargets, cfg.CoalesceWindow.Duration)
+	srv.CheckInterval = cfg.CheckInterval.Duration
+	srv.Pusher.DryRun = cfg.DryRunOutput != ""
+	if secrets.VAPIDPrivateKey != "" && stores.WebPushSubscriptions != nil {
+		vapidKey, err := webpush.ParseVAPIDKey(secrets.VAPIDPrivateKey)
+		if err != nil {
+			fatal("Failed to parse VAPID key", err)
+		}
+		srv.WebPush = &storminglambdas.WebPushHandler{
+			PublicKey:     vapidKey.PublicKey(),
+			Subscriptions: stores.WebPushSubscriptions,
+		}
+	}
+
+	go srv.Run(ctx)
+
+	httpServer := &http.Server{Addr: *addr, Handler: srv.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving", "addr", *addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal("Failed to serve", err)
+	}
+}
+
+// fatal logs the error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
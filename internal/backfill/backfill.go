@@ -0,0 +1,99 @@
+// Package backfill fills in the history from before the scraper was deployed, using archived copies of the progress
+// page.
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+type (
+	// Backfiller writes an entry for every snapshot whose progress differs from the snapshot before it. The entries are
+	// marked historical, so that they are not pushed.
+	Backfiller struct {
+		Source  Source
+		History history.Store
+		// DryRun finds the entries without writing them
+		DryRun bool
+	}
+
+	// Result describes a backfill
+	Result struct {
+		// Entries are the entries written, oldest first, or that would have been written with DryRun
+		Entries []history.ProgressEntry
+		// Unchanged is the number of snapshots skipped because their progress matched the snapshot before them
+		Unchanged int
+		// Unparseable is the number of snapshots skipped because no progress could be read from them, e.g. because the
+		// site looked different at the time
+		Unparseable int
+		// Overlapping is the number of snapshots skipped because they are not older than the history
+		Overlapping int
+	}
+)
+
+// Run backfills the history. Only snapshots older than the earliest entry already in the history are used, so that a
+// backfill never interleaves with scraped entries and running it again writes nothing new. Every snapshot is fetched
+// and parsed before anything is written.
+func (b *Backfiller) Run(ctx context.Context) (Result, error) {
+	snapshots, err := b.Source.Snapshots(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("list snapshots: %w", err)
+	}
+	slices.SortFunc(snapshots, func(a, b Snapshot) int { return a.Timestamp.Compare(b.Timestamp) })
+
+	earliest, err := b.History.GetEarliestProgressEntryAfter(ctx, time.Unix(0, math.MinInt64))
+	hasHistory := err == nil
+	if err != nil && !errors.Is(err, history.ErrNoEntryAfterTarget) {
+		return Result{}, fmt.Errorf("get earliest entry: %w", err)
+	}
+
+	result := Result{}
+	var previous []progress.WorkInProgress
+	for _, snapshot := range snapshots {
+		if hasHistory && !snapshot.Timestamp.Before(earliest.Timestamp) {
+			result.Overlapping++
+			continue
+		}
+
+		html, err := b.Source.Fetch(ctx, snapshot)
+		if err != nil {
+			return Result{}, fmt.Errorf("fetch snapshot %s: %w", snapshot.Location, err)
+		}
+		wips, err := progress.ParseProgressFromHTML(html)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Skipping unparseable snapshot", "location", snapshot.Location, "error", err)
+			result.Unparseable++
+			continue
+		}
+		if slices.Equal(wips, previous) {
+			result.Unchanged++
+			continue
+		}
+		result.Entries = append(result.Entries, history.ProgressEntry{Timestamp: snapshot.Timestamp, WorksInProgress: wips, Historical: true})
+		previous = wips
+	}
+
+	// The earliest scraped entry already records the last snapshot's progress
+	if hasHistory && len(result.Entries) > 0 && slices.Equal(previous, earliest.WorksInProgress) {
+		result.Entries = result.Entries[:len(result.Entries)-1]
+		result.Unchanged++
+	}
+
+	if b.DryRun {
+		return result, nil
+	}
+	for _, entry := range result.Entries {
+		if err := b.History.AddNewProgressEntry(ctx, entry); err != nil {
+			return result, fmt.Errorf("add entry %s: %w", entry.Timestamp, err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,227 @@
+package backfill
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+const snapshotDir = "testdata/snapshots"
+
+var (
+	firstProgress = []progress.WorkInProgress{
+		{Title: "Stormlight 4", Progress: 30},
+		{Title: "Mistborn Era 2, Book 4", Progress: 10},
+	}
+	secondProgress = []progress.WorkInProgress{
+		{Title: "Stormlight 4", Progress: 55},
+		{Title: "Mistborn Era 2, Book 4", Progress: 10},
+	}
+)
+
+func date(month time.Month, day int) time.Time {
+	return time.Date(2021, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestBackfiller_Run(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []history.ProgressEntry
+		dryRun   bool
+		expected Result
+	}{
+		{
+			name: "empty history",
+			expected: Result{
+				Entries: []history.ProgressEntry{
+					{Timestamp: date(time.January, 1), WorksInProgress: firstProgress, Historical: true},
+					{Timestamp: date(time.March, 1), WorksInProgress: secondProgress, Historical: true},
+				},
+				Unchanged:   2,
+				Unparseable: 1,
+			},
+		},
+		{
+			name:   "dry run",
+			dryRun: true,
+			expected: Result{
+				Entries: []history.ProgressEntry{
+					{Timestamp: date(time.January, 1), WorksInProgress: firstProgress, Historical: true},
+					{Timestamp: date(time.March, 1), WorksInProgress: secondProgress, Historical: true},
+				},
+				Unchanged:   2,
+				Unparseable: 1,
+			},
+		},
+		{
+			name: "history starting with the last snapshot's progress",
+			existing: []history.ProgressEntry{
+				{Timestamp: date(time.March, 15), WorksInProgress: secondProgress},
+			},
+			expected: Result{
+				Entries: []history.ProgressEntry{
+					{Timestamp: date(time.January, 1), WorksInProgress: firstProgress, Historical: true},
+				},
+				Unchanged:   2,
+				Unparseable: 1,
+				Overlapping: 1,
+			},
+		},
+		{
+			name: "history starting before every snapshot",
+			existing: []history.ProgressEntry{
+				{Timestamp: date(time.January, 1), WorksInProgress: firstProgress},
+			},
+			expected: Result{
+				Unparseable: 1,
+				Overlapping: 4,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := history.NewMemoryStore()
+			for _, entry := range tc.existing {
+				require.NoError(t, store.AddNewProgressEntry(ctx, entry))
+			}
+
+			backfiller := &Backfiller{Source: DirectorySource{Dir: snapshotDir}, History: store, DryRun: tc.dryRun}
+			result, err := backfiller.Run(ctx)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, result)
+
+			count, err := store.GetEntryCount(ctx)
+			require.NoError(t, err)
+			expectedCount := len(tc.existing)
+			if !tc.dryRun {
+				expectedCount += len(tc.expected.Entries)
+			}
+			require.EqualValues(t, expectedCount, count)
+
+			// Running again writes nothing new
+			result, err = backfiller.Run(ctx)
+			require.NoError(t, err)
+			if !tc.dryRun {
+				require.Empty(t, result.Entries)
+			}
+		})
+	}
+}
+
+func TestDirectorySource_BadName(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "latest.html"), nil, 0o644))
+
+	_, err := DirectorySource{Dir: dir}.Snapshots(context.Background())
+	require.ErrorContains(t, err, `"latest.html" is not named after its Wayback timestamp`)
+}
+
+func TestWaybackSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/cdx/search/cdx":
+			query := r.URL.Query()
+			require.Equal(t, "brandonsanderson.com", query.Get("url"))
+			require.Equal(t, "20210101000000", query.Get("from"))
+			require.Equal(t, "digest", query.Get("collapse"))
+			w.Write([]byte(`[["timestamp","original"],
+				["20210301000000","https://brandonsanderson.com/"],
+				["20210101000000","https://brandonsanderson.com/"]]`))
+		case strings.HasPrefix(r.URL.Path, "/web/"):
+			timestamp, original, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/web/"), "id_/")
+			require.Equal(t, "https://brandonsanderson.com/", original)
+			http.ServeFile(w, r, filepath.Join(snapshotDir, timestamp+".html"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := &WaybackSource{URL: "brandonsanderson.com", From: date(time.January, 1), BaseURL: server.URL + "/", Interval: time.Millisecond}
+	backfiller := &Backfiller{Source: source, History: history.NewMemoryStore()}
+	result, err := backfiller.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, Result{Entries: []history.ProgressEntry{
+		{Timestamp: date(time.January, 1), WorksInProgress: firstProgress, Historical: true},
+		{Timestamp: date(time.March, 1), WorksInProgress: secondProgress, Historical: true},
+	}}, result)
+}
+
+func TestWaybackSource_Retries(t *testing.T) {
+	requests := []time.Time{}
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, time.Now())
+		status := statuses[len(requests)-1]
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`[["timestamp","original"]]`))
+	}))
+	defer server.Close()
+
+	source := &WaybackSource{URL: "brandonsanderson.com", BaseURL: server.URL, Interval: 20 * time.Millisecond, Backoff: time.Millisecond}
+	snapshots, err := source.Snapshots(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, snapshots)
+	require.Len(t, requests, 3)
+	for i := 1; i < len(requests); i++ {
+		require.GreaterOrEqual(t, requests[i].Sub(requests[i-1]), 20*time.Millisecond, "requests are spaced by the interval")
+	}
+}
+
+func TestWaybackSource_Error(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	source := &WaybackSource{URL: "brandonsanderson.com", BaseURL: server.URL, Interval: time.Millisecond, MaxRetries: 2, Backoff: time.Millisecond}
+	_, err := source.Snapshots(context.Background())
+	require.ErrorContains(t, err, "429 Too Many Requests")
+	require.Equal(t, 3, requests)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{header: "", expected: 0},
+		{header: "120", expected: 2 * time.Minute},
+		{header: "soon", expected: 0},
+		{header: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), expected: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.header, func(t *testing.T) {
+			require.Equal(t, tc.expected, parseRetryAfter(tc.header))
+		})
+	}
+
+	// Dates are relative to now
+	wait := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	require.InDelta(t, time.Hour, wait, float64(2*time.Second))
+}
+
+func TestWaybackSource_SnapshotAt(t *testing.T) {
+	snapshot := (&WaybackSource{URL: "brandonsanderson.com"}).SnapshotAt(date(time.March, 1))
+	require.Equal(t, Snapshot{
+		Timestamp: date(time.March, 1),
+		Location:  "https://web.archive.org/web/20210301000000id_/brandonsanderson.com",
+	}, snapshot)
+}
@@ -0,0 +1,301 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/logging"
+)
+
+// WaybackTimestampLayout is how the Wayback Machine writes snapshot timestamps, always in UTC
+const WaybackTimestampLayout = "20060102150405"
+
+// DefaultWaybackURL is the Internet Archive's Wayback Machine
+const DefaultWaybackURL = "https://web.archive.org"
+
+// Defaults that keep a backfill within the Wayback Machine's rate limits
+const (
+	DefaultWaybackInterval = 2 * time.Second
+	DefaultWaybackRetries  = 5
+	DefaultWaybackBackoff  = 5 * time.Second
+)
+
+type (
+	// Snapshot is an archived copy of the progress page
+	Snapshot struct {
+		// Timestamp is when the copy was archived
+		Timestamp time.Time
+		// Location is where the copy is fetched from, e.g. a URL or a file path
+		Location string
+	}
+
+	// Source lists and fetches archived copies of the progress page
+	Source interface {
+		// Snapshots lists the copies, in any order
+		Snapshots(ctx context.Context) ([]Snapshot, error)
+		// Fetch returns a copy's HTML
+		Fetch(ctx context.Context, snapshot Snapshot) (string, error)
+	}
+
+	// WaybackSource lists snapshots with the Wayback Machine's CDX API. Requests are spaced out, and retried when the
+	// Wayback Machine is rate limiting or failing.
+	WaybackSource struct {
+		// URL is the archived page, e.g. brandonsanderson.com
+		URL string
+		// From and To limit the snapshots to those archived between them, if they are set
+		From, To time.Time
+		// BaseURL is the Wayback Machine's URL. Defaults to DefaultWaybackURL.
+		BaseURL string
+		// Client defaults to http.DefaultClient
+		Client *http.Client
+		// Interval is the least time between requests. Defaults to DefaultWaybackInterval.
+		Interval time.Duration
+		// MaxRetries is how often a request answered with 429 or a 5xx status is retried. Defaults to
+		// DefaultWaybackRetries.
+		MaxRetries int
+		// Backoff is the wait before the first retry, doubled for each retry after it. A Retry-After header takes
+		// precedence. Defaults to DefaultWaybackBackoff.
+		Backoff time.Duration
+
+		mu          sync.Mutex
+		lastRequest time.Time
+	}
+
+	// retryableError is a response worth retrying, as the Wayback Machine was rate limiting or failing
+	retryableError struct {
+		err error
+		// retryAfter is the wait the response asked for, if it did
+		retryAfter time.Duration
+	}
+
+	// DirectorySource reads saved copies from a directory. Each file is named after its Wayback timestamp, e.g.
+	// 20230815120000.html. Files without the .html extension are ignored.
+	DirectorySource struct {
+		Dir string
+	}
+)
+
+// Snapshots lists the successful captures, leaving out captures identical to the one before them
+func (s *WaybackSource) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	query := url.Values{
+		"url":      {s.URL},
+		"output":   {"json"},
+		"fl":       {"timestamp,original"},
+		"filter":   {"statuscode:200"},
+		"collapse": {"digest"},
+	}
+	if !s.From.IsZero() {
+		query.Set("from", s.From.UTC().Format(WaybackTimestampLayout))
+	}
+	if !s.To.IsZero() {
+		query.Set("to", s.To.UTC().Format(WaybackTimestampLayout))
+	}
+
+	body, err := s.get(ctx, s.baseURL()+"/cdx/search/cdx?"+query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("search CDX: %w", err)
+	}
+
+	// The first row is the header
+	var rows [][]string
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, fmt.Errorf("unmarshal CDX results: %w", err)
+	}
+	snapshots := []Snapshot{}
+	for i, row := range rows {
+		if i == 0 {
+			continue
+		}
+		if len(row) != 2 {
+			return nil, fmt.Errorf("CDX result %d has %d fields, expected 2", i, len(row))
+		}
+		timestamp, err := time.Parse(WaybackTimestampLayout, row[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse CDX timestamp %q: %w", row[0], err)
+		}
+		snapshots = append(snapshots, Snapshot{Timestamp: timestamp, Location: s.snapshotURL(row[0], row[1])})
+	}
+	return snapshots, nil
+}
+
+// SnapshotAt returns the snapshot of URL archived at the timestamp. Fetching it returns the closest snapshot to the
+// timestamp if there is none at it.
+func (s *WaybackSource) SnapshotAt(timestamp time.Time) Snapshot {
+	waybackTimestamp := timestamp.UTC().Format(WaybackTimestampLayout)
+	return Snapshot{Timestamp: timestamp, Location: s.snapshotURL(waybackTimestamp, s.URL)}
+}
+
+// Fetch downloads a snapshot
+func (s *WaybackSource) Fetch(ctx context.Context, snapshot Snapshot) (string, error) {
+	body, err := s.get(ctx, snapshot.Location)
+	return string(body), err
+}
+
+// get fetches the URL, waiting out the interval since the last request and retrying with backoff
+func (s *WaybackSource) get(ctx context.Context, url string) ([]byte, error) {
+	backoff := s.Backoff
+	if backoff <= 0 {
+		backoff = DefaultWaybackBackoff
+	}
+	maxRetries := s.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultWaybackRetries
+	}
+
+	for retry := 0; ; retry++ {
+		if err := s.throttle(ctx); err != nil {
+			return nil, err
+		}
+		body, err := s.getOnce(ctx, url)
+		var retryable *retryableError
+		if !errors.As(err, &retryable) || retry == maxRetries {
+			return body, err
+		}
+
+		wait := backoff
+		if retryable.retryAfter > 0 {
+			wait = retryable.retryAfter
+		}
+		logging.FromContext(ctx).Warn("Retrying Wayback Machine request", "url", url, "error", err, "wait", wait)
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// throttle waits until Interval has passed since the last request
+func (s *WaybackSource) throttle(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultWaybackInterval
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.lastRequest.IsZero() {
+		if err := sleep(ctx, time.Until(s.lastRequest.Add(interval))); err != nil {
+			return err
+		}
+	}
+	s.lastRequest = time.Now()
+	return nil
+}
+
+func (s *WaybackSource) getOnce(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+		return nil, &retryableError{
+			err:        fmt.Errorf("GET %s: status %s", url, response.Status),
+			retryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+		}
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %s", url, response.Status)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+	return body, nil
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// parseRetryAfter returns the wait a Retry-After header asks for, given in seconds or as a date. It returns zero if
+// there is none.
+func parseRetryAfter(header string) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// sleep waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// snapshotURL returns where the snapshot of the original URL is. The id_ suffix returns the page as it was archived,
+// without the Wayback Machine's banner.
+func (s *WaybackSource) snapshotURL(waybackTimestamp, original string) string {
+	return fmt.Sprintf("%s/web/%sid_/%s", s.baseURL(), waybackTimestamp, original)
+}
+
+func (s *WaybackSource) baseURL() string {
+	if s.BaseURL == "" {
+		return DefaultWaybackURL
+	}
+	return strings.TrimSuffix(s.BaseURL, "/")
+}
+
+// Snapshots lists the directory's HTML files
+func (s DirectorySource) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	files, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot directory: %w", err)
+	}
+	snapshots := []Snapshot{}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || filepath.Ext(name) != ".html" {
+			continue
+		}
+		timestamp, err := time.Parse(WaybackTimestampLayout, strings.TrimSuffix(name, ".html"))
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %q is not named after its Wayback timestamp, e.g. 20230815120000.html", name)
+		}
+		snapshots = append(snapshots, Snapshot{Timestamp: timestamp, Location: filepath.Join(s.Dir, name)})
+	}
+	return snapshots, nil
+}
+
+// Fetch reads a snapshot's file
+func (s DirectorySource) Fetch(ctx context.Context, snapshot Snapshot) (string, error) {
+	contents, err := os.ReadFile(snapshot.Location)
+	if err != nil {
+		return "", fmt.Errorf("read snapshot: %w", err)
+	}
+	return string(contents), nil
+}
@@ -1,6 +1,465 @@
+// Package config loads the service's configuration. Values are layered, each layer overriding the ones before it:
+//
+//  1. the built-in profile (dev, staging or prod)
+//  2. the config file, then the file's section for the profile
+//  3. the Secrets Manager secret, if loaded
+//  4. STORMWATCH_* environment variables
+//
+// Every layer uses the same keys, e.g. CHECK_INTERVAL in a file or the secret and STORMWATCH_CHECK_INTERVAL in the
+// environment. A layer that sets a key to an empty or zero value clears it, e.g. "NAME_PREFIX": "" or STORMWATCH_NAME_PREFIX=.
 package config
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
 const (
-	AWSRegion              = "us-west-2"
-	HistoryDynamoTableName = "storm-charts"
+	ProfileDev     = "dev"
+	ProfileStaging = "staging"
+	ProfileProd    = "prod"
+
+	// EnvPrefix prefixes every key in the environment
+	EnvPrefix = "STORMWATCH_"
+	// ProfileEnvVar selects the profile when LoadOptions.Profile is empty
+	ProfileEnvVar = EnvPrefix + "PROFILE"
+	// FileEnvVar names the config file when LoadOptions.File is empty
+	FileEnvVar = EnvPrefix + "CONFIG_FILE"
+
+	// minInterval is the shortest EventBridge schedule
+	minInterval = time.Minute
+)
+
+var (
+	ErrUnknownProfile = errors.New("unknown profile")
+	ErrInvalidConfig  = errors.New("invalid config")
+)
+
+type (
+	Config struct {
+		// Profile is the profile the config was loaded for. It is not a key.
+		Profile string `json:"-"`
+
+		// NamePrefix sets environments apart. It prefixes the stack's name, and the table names of the built-in profiles.
+		NamePrefix string `json:"NAME_PREFIX,omitempty"`
+		AWSRegion  string `json:"AWS_REGION,omitempty"`
+		SecretName string `json:"SECRET_NAME,omitempty"`
+		// ProgressURL is the page progress is scraped from
+		ProgressURL string `json:"PROGRESS_URL,omitempty"`
+		// StatusPageURL is the public status page linked from notifications. It is optional.
+		StatusPageURL string `json:"STATUS_PAGE_URL,omitempty"`
+		// CheckInterval is how often progress is checked
+		CheckInterval Duration `json:"CHECK_INTERVAL,omitzero"`
+		// FlushInterval is how often coalesced notifications are flushed
+		FlushInterval Duration `json:"FLUSH_INTERVAL,omitzero"`
+		// CoalesceWindow merges updates that occur within the window into a single notification. Zero disables
+		// coalescing, and with it the flush schedule.
+		CoalesceWindow Duration `json:"COALESCE_WINDOW,omitzero"`
+		// LogRetentionDays is how long Lambda logs are kept. CloudWatch only accepts certain values.
+		LogRetentionDays int `json:"LOG_RETENTION_DAYS,omitempty"`
+		// FCMTopic, if set, is notified of every update instead of the registered devices, which then cannot register.
+		// The two are exclusive so that no device is notified twice, so no profile sets it.
+		FCMTopic string `json:"FCM_TOPIC,omitempty"`
+		// LogLevel is the minimum level logged: debug, info, warn or error
+		LogLevel string `json:"LOG_LEVEL,omitempty"`
+		// TraceExporter is where spans are sent: none, stdout or otlp
+		TraceExporter string `json:"TRACE_EXPORTER,omitempty"`
+		// OTLPEndpoint is the OTLP/HTTP collector URL used by the otlp trace exporter. It defaults to the standard
+		// OTEL_EXPORTER_OTLP_* environment variables.
+		OTLPEndpoint string `json:"OTLP_ENDPOINT,omitempty"`
+		// AlarmEmail, if set, is subscribed to the stack's alarm topic
+		AlarmEmail string `json:"ALARM_EMAIL,omitempty"`
+		// DryRunOutput, if set, makes the push targets write the requests they would send to this file, or to stdout
+		// for "-", instead of sending them
+		DryRunOutput string `json:"DRY_RUN_OUTPUT,omitempty"`
+
+		HistoryTable              string `json:"HISTORY_TABLE,omitempty"`
+		SlackSubscriptionsTable   string `json:"SLACK_SUBSCRIPTIONS_TABLE,omitempty"`
+		FCMDevicesTable           string `json:"FCM_DEVICES_TABLE,omitempty"`
+		WebPushSubscriptionsTable string `json:"WEBPUSH_SUBSCRIPTIONS_TABLE,omitempty"`
+
+		// Secret is the raw secret, if it was loaded
+		Secret json.RawMessage `json:"-"`
+	}
+
+	// Duration is a time.Duration written like "5m" in files and the environment
+	Duration struct {
+		time.Duration
+	}
+
+	LoadOptions struct {
+		// Profile defaults to $STORMWATCH_PROFILE, then prod
+		Profile string
+		// File is the JSON config file. It defaults to $STORMWATCH_CONFIG_FILE, and is optional.
+		File string
+		// LoadSecret loads the secret named by SecretName from Secrets Manager
+		LoadSecret bool
+		// SecretFile, if set, is read instead of Secrets Manager when loading the secret
+		SecretFile string
+		// SecretsManager defaults to a client for the configured region
+		SecretsManager SecretsManager
+		// LookupEnv defaults to os.LookupEnv
+		LookupEnv func(string) (string, bool)
+	}
+
+	SecretsManager interface {
+		GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+	}
+
+	// layer is a config layer and the keys it sets, which override the layers before it even when they are zero
+	layer struct {
+		Config
+		keys map[string]bool
+	}
 )
+
+// Profiles are the built-in profiles. Each environment gets its own stack, tables and secret.
+var Profiles = map[string]Config{
+	ProfileDev:     newProfile(ProfileDev, "dev-", "debug"),
+	ProfileStaging: newProfile(ProfileStaging, "staging-", "info"),
+	ProfileProd:    newProfile(ProfileProd, "", "info"),
+}
+
+// traceExporters are the supported trace exporters
+var traceExporters = []string{"none", "stdout", "otlp"}
+
+// logRetentionDays are the retention periods CloudWatch Logs accepts
+var logRetentionDays = []int{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
+func newProfile(name, prefix, logLevel string) Config {
+	secretName := "StormlightArchive"
+	if name != ProfileProd {
+		secretName += "-" + name
+	}
+	return Config{
+		Profile:                   name,
+		NamePrefix:                prefix,
+		AWSRegion:                 "us-west-2",
+		SecretName:                secretName,
+		ProgressURL:               "http://brandonsanderson.com",
+		CheckInterval:             Duration{5 * time.Minute},
+		FlushInterval:             Duration{time.Minute},
+		LogRetentionDays:          1,
+		LogLevel:                  logLevel,
+		TraceExporter:             "none",
+		HistoryTable:              prefix + "storm-charts",
+		SlackSubscriptionsTable:   prefix + "storm-slack-subscriptions",
+		FCMDevicesTable:           prefix + "storm-fcm-devices",
+		WebPushSubscriptionsTable: prefix + "storm-webpush-subscriptions",
+	}
+}
+
+// Load builds the config from its layers and validates it
+func Load(ctx context.Context, options LoadOptions) (Config, error) {
+	lookupEnv := options.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+	profile := options.Profile
+	if profile == "" {
+		profile, _ = lookupEnv(ProfileEnvVar)
+	}
+	if profile == "" {
+		profile = ProfileProd
+	}
+	cfg, ok := Profiles[profile]
+	if !ok {
+		return Config{}, fmt.Errorf("%w %q", ErrUnknownProfile, profile)
+	}
+
+	path := options.File
+	if path == "" {
+		path, _ = lookupEnv(FileEnvVar)
+	}
+	if path != "" {
+		fileLayers, err := loadFile(path, profile)
+		if err != nil {
+			return Config{}, err
+		}
+		for _, fileLayer := range fileLayers {
+			cfg.merge(fileLayer)
+		}
+	}
+
+	env, err := fromEnvironment(lookupEnv)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if options.LoadSecret || options.SecretFile != "" {
+		var secret json.RawMessage
+		if options.SecretFile != "" {
+			secret, err = os.ReadFile(options.SecretFile)
+			if err != nil {
+				return Config{}, fmt.Errorf("read secret file: %w", err)
+			}
+		} else {
+			// The secret's name and region can't come from the secret itself
+			secretCfg := cfg
+			secretCfg.merge(env)
+			secret, err = loadSecret(ctx, secretCfg, options.SecretsManager)
+			if err != nil {
+				return Config{}, err
+			}
+		}
+		fromSecret, err := parseLayer(secret)
+		if err != nil {
+			return Config{}, fmt.Errorf("unmarshal secret: %w", err)
+		}
+		delete(fromSecret.keys, "AWS_REGION")
+		delete(fromSecret.keys, "SECRET_NAME")
+		cfg.merge(fromSecret)
+		cfg.Secret = secret
+	}
+
+	cfg.merge(env)
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that every value is usable
+func (c Config) Validate() error {
+	var errs []error
+	required := []struct{ key, value string }{
+		{"AWS_REGION", c.AWSRegion},
+		{"SECRET_NAME", c.SecretName},
+		{"HISTORY_TABLE", c.HistoryTable},
+		{"SLACK_SUBSCRIPTIONS_TABLE", c.SlackSubscriptionsTable},
+		{"FCM_DEVICES_TABLE", c.FCMDevicesTable},
+		{"WEBPUSH_SUBSCRIPTIONS_TABLE", c.WebPushSubscriptionsTable},
+	}
+	for _, field := range required {
+		if field.value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", field.key))
+		}
+	}
+
+	if !isHTTPURL(c.ProgressURL) {
+		errs = append(errs, fmt.Errorf("PROGRESS_URL must be an http or https URL, got %q", c.ProgressURL))
+	}
+	if c.StatusPageURL != "" && !isHTTPURL(c.StatusPageURL) {
+		errs = append(errs, fmt.Errorf("STATUS_PAGE_URL must be an http or https URL, got %q", c.StatusPageURL))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+	if !slices.Contains(traceExporters, c.TraceExporter) {
+		errs = append(errs, fmt.Errorf("TRACE_EXPORTER must be one of %v, got %q", traceExporters, c.TraceExporter))
+	}
+	if c.OTLPEndpoint != "" && !isHTTPURL(c.OTLPEndpoint) {
+		errs = append(errs, fmt.Errorf("OTLP_ENDPOINT must be an http or https URL, got %q", c.OTLPEndpoint))
+	}
+	if c.AlarmEmail != "" && !strings.Contains(c.AlarmEmail, "@") {
+		errs = append(errs, fmt.Errorf("ALARM_EMAIL must be an email address, got %q", c.AlarmEmail))
+	}
+	intervals := []struct {
+		key   string
+		value Duration
+	}{
+		{"CHECK_INTERVAL", c.CheckInterval},
+		{"FLUSH_INTERVAL", c.FlushInterval},
+	}
+	for _, interval := range intervals {
+		if interval.value.Duration < minInterval || interval.value.Duration%time.Minute != 0 {
+			errs = append(errs, fmt.Errorf("%s must be a whole number of minutes, got %s", interval.key, interval.value))
+		}
+	}
+
+	if c.CoalesceWindow.Duration < 0 {
+		errs = append(errs, fmt.Errorf("COALESCE_WINDOW must not be negative, got %s", c.CoalesceWindow))
+	}
+
+	if !slices.Contains(logRetentionDays, c.LogRetentionDays) {
+		errs = append(errs, fmt.Errorf("LOG_RETENTION_DAYS must be one of %v, got %d", logRetentionDays, c.LogRetentionDays))
+	}
+
+	tables := []string{c.HistoryTable, c.SlackSubscriptionsTable, c.FCMDevicesTable, c.WebPushSubscriptionsTable}
+	if len(slices.Compact(slices.Sorted(slices.Values(tables)))) != len(tables) {
+		errs = append(errs, errors.New("table names must be distinct"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+	return nil
+}
+
+// Environment returns the config as STORMWATCH_* environment variables, so that it can be handed to another process
+// such as a Lambda function. Keys the profile sets that the config clears are included, empty or zero.
+func (c Config) Environment() map[string]string {
+	env := map[string]string{ProfileEnvVar: c.Profile}
+	value := reflect.ValueOf(c)
+	profile := reflect.ValueOf(Profiles[c.Profile])
+	for i, field := range reflect.VisibleFields(value.Type()) {
+		key := fieldKey(field)
+		if key == "" || (value.Field(i).IsZero() && profile.Field(i).IsZero()) {
+			continue
+		}
+		env[EnvPrefix+key] = fmt.Sprint(value.Field(i).Interface())
+	}
+	return env
+}
+
+// AWSConfig returns the default AWS config for the configured region
+func (c Config) AWSConfig(ctx context.Context) (aws.Config, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(c.AWSRegion))
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("load default config: %w", err)
+	}
+	return cfg, nil
+}
+
+// merge sets every key that the layer sets
+func (c *Config) merge(l layer) {
+	dst := reflect.ValueOf(c).Elem()
+	src := reflect.ValueOf(l.Config)
+	for i, field := range reflect.VisibleFields(dst.Type()) {
+		if key := fieldKey(field); key != "" && l.keys[key] {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+func fromEnvironment(lookupEnv func(string) (string, bool)) (layer, error) {
+	env := layer{keys: map[string]bool{}}
+	value := reflect.ValueOf(&env.Config).Elem()
+	for i, field := range reflect.VisibleFields(value.Type()) {
+		key := fieldKey(field)
+		if key == "" {
+			continue
+		}
+		envValue, ok := lookupEnv(EnvPrefix + key)
+		if !ok {
+			continue
+		}
+		env.keys[key] = true
+		if envValue == "" {
+			continue // Clears the key
+		}
+		if duration, ok := value.Field(i).Addr().Interface().(*Duration); ok {
+			if err := duration.UnmarshalText([]byte(envValue)); err != nil {
+				return layer{}, fmt.Errorf("%s%s: %w", EnvPrefix, key, err)
+			}
+			continue
+		}
+		if value.Field(i).Kind() == reflect.Int {
+			n, err := strconv.Atoi(envValue)
+			if err != nil {
+				return layer{}, fmt.Errorf("%s%s: %w", EnvPrefix, key, err)
+			}
+			value.Field(i).SetInt(int64(n))
+			continue
+		}
+		value.Field(i).SetString(envValue)
+	}
+	return env, nil
+}
+
+// loadFile returns the config file's layers: the file, then its PROFILES section for the profile, if it has one
+func loadFile(path, profile string) ([]layer, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	file, err := parseLayer(contents)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal config file: %w", err)
+	}
+	var sections struct {
+		Profiles map[string]json.RawMessage `json:"PROFILES"`
+	}
+	if err := json.Unmarshal(contents, &sections); err != nil {
+		return nil, fmt.Errorf("unmarshal config file: %w", err)
+	}
+	layers := []layer{file}
+	if section, ok := sections.Profiles[profile]; ok {
+		profileLayer, err := parseLayer(section)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal config file profile %q: %w", profile, err)
+		}
+		layers = append(layers, profileLayer)
+	}
+	return layers, nil
+}
+
+// parseLayer decodes a JSON layer, noting the keys it has
+func parseLayer(data []byte) (layer, error) {
+	var l layer
+	if err := json.Unmarshal(data, &l.Config); err != nil {
+		return layer{}, err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return layer{}, err
+	}
+	l.keys = map[string]bool{}
+	for key := range keys {
+		l.keys[key] = true
+	}
+	return l, nil
+}
+
+func loadSecret(ctx context.Context, cfg Config, secretsManager SecretsManager) (json.RawMessage, error) {
+	if secretsManager == nil {
+		awsCfg, err := cfg.AWSConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		secretsManager = secretsmanager.NewFromConfig(awsCfg)
+	}
+
+	result, err := secretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(cfg.SecretName),
+		VersionStage: aws.String("AWSCURRENT"), // VersionStage defaults to AWSCURRENT if unspecified
+	})
+	if err != nil {
+		// For a list of exceptions thrown, see
+		// https://docs.aws.amazon.com/secretsmanager/latest/apireference/API_GetSecretValue.html
+		return nil, fmt.Errorf("get secret %q: %w", cfg.SecretName, err)
+	}
+	return json.RawMessage(aws.ToString(result.SecretString)), nil
+}
+
+// fieldKey returns the key of a config field, or "" for fields that are not keys
+func fieldKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSecretsManager struct {
+	secrets map[string]string
+}
+
+func (f *fakeSecretsManager) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(f.secrets[*params.SecretId])}, nil
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"CHECK_INTERVAL": "10m",
+		"PROGRESS_URL": "https://file.example.com",
+		"STATUS_PAGE_URL": "https://file.example.com/status",
+		"PROFILES": {"staging": {"CHECK_INTERVAL": "15m"}}
+	}`), 0o644))
+	clearingPath := filepath.Join(t.TempDir(), "clearing.json")
+	require.NoError(t, os.WriteFile(clearingPath, []byte(`{
+		"COALESCE_WINDOW": "30m",
+		"PROFILES": {"dev": {"NAME_PREFIX": ""}}
+	}`), 0o644))
+	secretPath := filepath.Join(t.TempDir(), "secret.json")
+	require.NoError(t, os.WriteFile(secretPath, []byte(`{"FCM_TOPIC": "file_topic"}`), 0o644))
+	secretsManager := &fakeSecretsManager{secrets: map[string]string{
+		"StormlightArchive-staging": `{"PROGRESS_URL": "https://secret.example.com", "SECRET_NAME": "ignored", "SLACK_WEBHOOK_URL": "https://hooks.slack.com/x"}`,
+	}}
+
+	testCases := []struct {
+		name        string
+		options     LoadOptions
+		env         map[string]string
+		expected    func(cfg *Config)
+		expectedErr error
+	}{
+		{
+			name:     "prod defaults",
+			expected: func(cfg *Config) {},
+		},
+		{
+			name:    "profile from the environment",
+			env:     map[string]string{ProfileEnvVar: ProfileDev},
+			options: LoadOptions{},
+			expected: func(cfg *Config) {
+				*cfg = Profiles[ProfileDev]
+			},
+		},
+		{
+			name:    "file, file profile, secret and environment in order",
+			options: LoadOptions{Profile: ProfileStaging, File: path, LoadSecret: true, SecretsManager: secretsManager},
+			env:     map[string]string{"STORMWATCH_STATUS_PAGE_URL": "https://env.example.com"},
+			expected: func(cfg *Config) {
+				*cfg = Profiles[ProfileStaging]
+				cfg.CheckInterval = Duration{15 * time.Minute}
+				cfg.ProgressURL = "https://secret.example.com"
+				cfg.StatusPageURL = "https://env.example.com"
+				cfg.Secret = []byte(secretsManager.secrets["StormlightArchive-staging"])
+			},
+		},
+		{
+			name:    "secret file",
+			options: LoadOptions{Profile: ProfileDev, SecretFile: secretPath},
+			env:     map[string]string{"STORMWATCH_LOG_RETENTION_DAYS": "14"},
+			expected: func(cfg *Config) {
+				*cfg = Profiles[ProfileDev]
+				cfg.FCMTopic = "file_topic"
+				cfg.LogRetentionDays = 14
+				cfg.Secret = []byte(`{"FCM_TOPIC": "file_topic"}`)
+			},
+		},
+		{
+			name:    "layers clear profile defaults",
+			options: LoadOptions{Profile: ProfileDev, File: clearingPath},
+			env:     map[string]string{"STORMWATCH_COALESCE_WINDOW": "0s", "STORMWATCH_STATUS_PAGE_URL": ""},
+			expected: func(cfg *Config) {
+				*cfg = Profiles[ProfileDev]
+				cfg.NamePrefix = ""
+			},
+		},
+		{
+			name:    "empty environment variable clears",
+			options: LoadOptions{Profile: ProfileDev},
+			env:     map[string]string{"STORMWATCH_NAME_PREFIX": ""},
+			expected: func(cfg *Config) {
+				*cfg = Profiles[ProfileDev]
+				cfg.NamePrefix = ""
+			},
+		},
+		{
+			name:        "unknown profile",
+			options:     LoadOptions{Profile: "qa"},
+			expectedErr: ErrUnknownProfile,
+		},
+		{
+			name:        "invalid interval",
+			env:         map[string]string{"STORMWATCH_CHECK_INTERVAL": "90s"},
+			expectedErr: ErrInvalidConfig,
+		},
+		{
+			name:        "invalid log retention",
+			env:         map[string]string{"STORMWATCH_LOG_RETENTION_DAYS": "2"},
+			expectedErr: ErrInvalidConfig,
+		},
+		{
+			name:        "duplicate tables",
+			env:         map[string]string{"STORMWATCH_FCM_DEVICES_TABLE": "storm-charts"},
+			expectedErr: ErrInvalidConfig,
+		},
+		{
+			name:        "invalid log level",
+			env:         map[string]string{"STORMWATCH_LOG_LEVEL": "loud"},
+			expectedErr: ErrInvalidConfig,
+		},
+		{
+			name:        "invalid trace exporter",
+			env:         map[string]string{"STORMWATCH_TRACE_EXPORTER": "jaeger"},
+			expectedErr: ErrInvalidConfig,
+		},
+		{
+			name:        "invalid alarm email",
+			env:         map[string]string{"STORMWATCH_ALARM_EMAIL": "ops"},
+			expectedErr: ErrInvalidConfig,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.options.LookupEnv = lookup(tc.env)
+			cfg, err := Load(context.Background(), tc.options)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			expected := Profiles[ProfileProd]
+			tc.expected(&expected)
+			require.Equal(t, expected, cfg)
+		})
+	}
+}
+
+func TestEnvironmentRoundTrip(t *testing.T) {
+	cfg := Profiles[ProfileStaging]
+	cfg.StatusPageURL = "https://example.com"
+	env := cfg.Environment()
+	require.Equal(t, "staging-storm-charts", env["STORMWATCH_HISTORY_TABLE"])
+
+	loaded, err := Load(context.Background(), LoadOptions{LookupEnv: lookup(env)})
+	require.NoError(t, err)
+	require.Equal(t, cfg, loaded)
+
+	// A cleared profile default stays cleared
+	cfg = Profiles[ProfileDev]
+	cfg.NamePrefix = ""
+	env = cfg.Environment()
+	require.Contains(t, env, "STORMWATCH_NAME_PREFIX")
+
+	loaded, err = Load(context.Background(), LoadOptions{LookupEnv: lookup(env)})
+	require.NoError(t, err)
+	require.Equal(t, cfg, loaded)
+}
+
+func lookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
@@ -0,0 +1,71 @@
+// Package dryrun renders the requests that push targets would send, so that notifications can be checked without
+// reaching anyone.
+package dryrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Stdout is the output that writes to stdout rather than to a file
+const Stdout = "-"
+
+type (
+	// Writer writes each request as a line of JSON. A nil Writer means requests are really sent.
+	Writer struct {
+		mu sync.Mutex
+		w  io.Writer
+	}
+
+	// Request is a request a push target would have sent
+	Request struct {
+		// Target is the push target's name
+		Target string `json:"target"`
+		// Destination is who the request is for, e.g. a channel, topic or endpoint
+		Destination string `json:"destination"`
+		// Payload is the request body, exactly as it would have been sent
+		Payload json.RawMessage `json:"payload"`
+	}
+)
+
+// New returns a writer to w
+func New(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Open returns a writer to the output, which is Stdout or the path of a file to append to
+func Open(output string) (*Writer, error) {
+	if output == Stdout {
+		return New(os.Stdout), nil
+	}
+	file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open dry run output: %w", err)
+	}
+	return New(file), nil
+}
+
+// Write writes the request. Payloads that are not JSON, such as encrypted bodies, are written as JSON strings.
+func (w *Writer) Write(request Request) error {
+	if !json.Valid(request.Payload) {
+		payload, err := json.Marshal(string(request.Payload))
+		if err != nil {
+			return err
+		}
+		request.Payload = payload
+	}
+	line, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("marshal dry run request: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := fmt.Fprintln(w.w, string(line)); err != nil {
+		return fmt.Errorf("write dry run request: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package dryrun
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_Write(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		expected string
+	}{
+		{
+			name:     "JSON payload",
+			payload:  `{"text": "Book 1 (25%)"}`,
+			expected: `{"target":"slack","destination":"#books","payload":{"text":"Book 1 (25%)"}}` + "\n",
+		},
+		{
+			name:     "other payload",
+			payload:  "not JSON",
+			expected: `{"target":"slack","destination":"#books","payload":"not JSON"}` + "\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := New(out).Write(Request{Target: "slack", Destination: "#books", Payload: []byte(tc.payload)})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func TestOpen_AppendsToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	for _, destination := range []string{"first", "second"} {
+		w, err := Open(path)
+		require.NoError(t, err)
+		require.NoError(t, w.Write(Request{Target: "fcm", Destination: destination, Payload: []byte("{}")}))
+	}
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `{"target":"fcm","destination":"first","payload":{}}
+{"target":"fcm","destination":"second","payload":{}}
+`, string(contents))
+}
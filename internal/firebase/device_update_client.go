@@ -0,0 +1,187 @@
+package firebase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/message"
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+// maxMulticastTokens is the most tokens FCM accepts in one multicast message
+const maxMulticastTokens = 500
+
+type (
+	// DeviceUpdateClient pushes updates via FCM to each registered device, and unregisters devices whose tokens FCM
+	// reports as no longer valid
+	DeviceUpdateClient struct {
+		Sender      multicastSender
+		Devices     deviceStore
+		Messages    *message.Templates
+		Locale      string
+		ClickAction string
+		Link        string
+		// DryRun, if set, gets a message for each device instead of Sender
+		DryRun *dryrun.Writer
+	}
+
+	multicastSender interface {
+		SendEachForMulticast(ctx context.Context, message *messaging.MulticastMessage) (*messaging.BatchResponse, error)
+	}
+
+	deviceStore interface {
+		GetDevices(ctx context.Context) ([]Device, error)
+		UnregisterDevices(ctx context.Context, tokens ...string) error
+	}
+)
+
+// isStaleTokenError reports whether FCM rejected a token because it will never be valid again
+var isStaleTokenError = func(err error) bool {
+	return messaging.IsUnregistered(err) || messaging.IsSenderIDMismatch(err)
+}
+
+// isInvalidTokenError reports whether FCM rejected a token as malformed. The same error is returned for malformed
+// messages, so it only condemns the token when other tokens accepted the same message.
+var isInvalidTokenError = messaging.IsInvalidArgument
+
+func NewDeviceUpdateClient(sender multicastSender, devices deviceStore) *DeviceUpdateClient {
+	return &DeviceUpdateClient{
+		Sender:      sender,
+		Devices:     devices,
+		Messages:    message.MustNew("fcm", DefaultMessages),
+		ClickAction: DefaultClickAction,
+	}
+}
+
+func (client *DeviceUpdateClient) GetName() string {
+	return "fcm-devices"
+}
+
+// SendUpdate sends each device the updates for the works it follows. Devices whose works did not change are skipped.
+func (client *DeviceUpdateClient) SendUpdate(ctx context.Context, wips []progress.ProgressUpdate) error {
+	if len(wips) == 0 {
+		return ErrNoProgressUpdates
+	}
+
+	devices, err := client.Devices.GetDevices(ctx)
+	if err != nil {
+		return fmt.Errorf("get devices: %w", err)
+	}
+
+	// Devices following the same changed works get the same message, so group their tokens
+	groups := map[string][]string{}
+	groupUpdates := map[string][]progress.ProgressUpdate{}
+	for _, device := range devices {
+		updates := followedUpdates(device, wips)
+		if len(updates) == 0 {
+			continue
+		}
+		key := groupKey(updates)
+		groups[key] = append(groups[key], device.Token)
+		groupUpdates[key] = updates
+	}
+
+	var errs []error
+	staleTokens := []string{}
+	for key, tokens := range groups {
+		msg, err := newMessage(client.Messages, client.Locale, client.ClickAction, client.Link, groupUpdates[key])
+		if err != nil {
+			return err
+		}
+		for chunk := range slices.Chunk(tokens, maxMulticastTokens) {
+			stale, err := client.sendMulticast(ctx, msg, chunk)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			staleTokens = append(staleTokens, stale...)
+		}
+	}
+
+	if len(staleTokens) > 0 {
+		logging.FromContext(ctx).Info("Unregistering stale FCM tokens", "count", len(staleTokens))
+		if err := client.Devices.UnregisterDevices(ctx, staleTokens...); err != nil {
+			errs = append(errs, fmt.Errorf("unregister stale devices: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sendMulticast sends the message to the tokens and returns the tokens that should be unregistered
+func (client *DeviceUpdateClient) sendMulticast(ctx context.Context, msg *messaging.Message, tokens []string) ([]string, error) {
+	if client.DryRun != nil {
+		// A multicast is sent as one message per token
+		for _, token := range tokens {
+			tokenMsg := *msg
+			tokenMsg.Token = token
+			if err := writeDryRun(client.DryRun, client.GetName(), "token:"+token, &tokenMsg); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	response, err := client.Sender.SendEachForMulticast(ctx, &messaging.MulticastMessage{
+		Tokens:       tokens,
+		Data:         msg.Data,
+		Notification: msg.Notification,
+		Android:      msg.Android,
+		Webpush:      msg.Webpush,
+		APNS:         msg.APNS,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("send multicast: %w", err)
+	}
+	logging.FromContext(ctx).Info("Sent FCM multicast message", "succeeded", response.SuccessCount, "failed", response.FailureCount)
+
+	stale := []string{}
+	var errs []error
+	for i, result := range response.Responses {
+		if result.Success {
+			continue
+		}
+		switch {
+		case isStaleTokenError(result.Error):
+			stale = append(stale, tokens[i])
+		case isInvalidTokenError(result.Error) && response.SuccessCount > 0:
+			stale = append(stale, tokens[i])
+		default:
+			errs = append(errs, result.Error)
+		}
+	}
+	if len(errs) > 0 {
+		// Every failure is usually the same error, so only report the first
+		return stale, fmt.Errorf("send to %d of %d devices failed: %w", len(errs), len(tokens), errs[0])
+	}
+	return stale, nil
+}
+
+// followedUpdates returns the updates for the works the device follows, or none if none of those works changed
+func followedUpdates(device Device, wips []progress.ProgressUpdate) []progress.ProgressUpdate {
+	updates := []progress.ProgressUpdate{}
+	anyChanged := false
+	for _, wip := range wips {
+		if len(device.WorkIDs) > 0 && !slices.Contains(device.WorkIDs, wip.WorkID()) {
+			continue
+		}
+		updates = append(updates, wip)
+		anyChanged = anyChanged || wip.ChangeKind() != progress.ChangeKindUnchanged
+	}
+	if !anyChanged {
+		return nil
+	}
+	return updates
+}
+
+func groupKey(updates []progress.ProgressUpdate) string {
+	workIDs := make([]string, len(updates))
+	for i, update := range updates {
+		workIDs[i] = update.WorkID()
+	}
+	return strings.Join(workIDs, ",")
+}
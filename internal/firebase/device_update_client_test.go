@@ -0,0 +1,119 @@
+package firebase
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errFakeUnregistered = errors.New("unregistered")
+	errFakeInvalid      = errors.New("invalid argument")
+	errFakeUnavailable  = errors.New("unavailable")
+)
+
+type (
+	fakeMulticastSender struct {
+		// results are the errors to report per token. Tokens without one succeed.
+		results map[string]error
+		sent    []*messaging.MulticastMessage
+	}
+
+	fakeDeviceStore struct {
+		devices []Device
+	}
+)
+
+func (f *fakeMulticastSender) SendEachForMulticast(ctx context.Context, message *messaging.MulticastMessage) (*messaging.BatchResponse, error) {
+	f.sent = append(f.sent, message)
+	response := &messaging.BatchResponse{}
+	for _, token := range message.Tokens {
+		if err := f.results[token]; err != nil {
+			response.FailureCount++
+			response.Responses = append(response.Responses, &messaging.SendResponse{Error: err})
+			continue
+		}
+		response.SuccessCount++
+		response.Responses = append(response.Responses, &messaging.SendResponse{Success: true, MessageID: token})
+	}
+	return response, nil
+}
+
+func (f *fakeDeviceStore) GetDevices(ctx context.Context) ([]Device, error) {
+	return f.devices, nil
+}
+
+func (f *fakeDeviceStore) UnregisterDevices(ctx context.Context, tokens ...string) error {
+	f.devices = slices.DeleteFunc(f.devices, func(device Device) bool { return slices.Contains(tokens, device.Token) })
+	return nil
+}
+
+func fakeFCMErrors(t *testing.T) {
+	t.Helper()
+	originalStale, originalInvalid := isStaleTokenError, isInvalidTokenError
+	isStaleTokenError = func(err error) bool { return errors.Is(err, errFakeUnregistered) }
+	isInvalidTokenError = func(err error) bool { return errors.Is(err, errFakeInvalid) }
+	t.Cleanup(func() {
+		isStaleTokenError, isInvalidTokenError = originalStale, originalInvalid
+	})
+}
+
+func TestDeviceUpdateClient_SendUpdate(t *testing.T) {
+	fakeFCMErrors(t)
+	sender := &fakeMulticastSender{results: map[string]error{
+		"gone":    errFakeUnregistered,
+		"garbage": errFakeInvalid,
+	}}
+	store := &fakeDeviceStore{devices: []Device{
+		{Token: "everything"},
+		{Token: "gone"},
+		{Token: "garbage"},
+		{Token: "moment-zero", WorkIDs: []string{"moment-zero-2-0"}},
+		{Token: "white-sand", WorkIDs: []string{"white-sand-prose-version"}},
+	}}
+	client := NewDeviceUpdateClient(sender, store)
+
+	err := client.SendUpdate(context.Background(), []progress.ProgressUpdate{
+		{Title: "Moment Zero 2.0", Progress: 90, PrevProgress: 80},
+		{Title: "White Sand (Prose Version)", Progress: 20, PrevProgress: 20},
+	})
+	require.NoError(t, err)
+
+	// The unchanged White Sand follower gets nothing, and everyone else falls into two groups
+	require.Len(t, sender.sent, 2)
+	for _, sent := range sender.sent {
+		if slices.Contains(sent.Tokens, "moment-zero") {
+			require.Equal(t, []string{"moment-zero"}, sent.Tokens)
+			require.Equal(t, "Moment Zero 2.0 (80% => 90%)", sent.Notification.Body)
+		} else {
+			require.Equal(t, []string{"everything", "gone", "garbage"}, sent.Tokens)
+		}
+	}
+
+	remaining := []string{}
+	for _, device := range store.devices {
+		remaining = append(remaining, device.Token)
+	}
+	require.Equal(t, []string{"everything", "moment-zero", "white-sand"}, remaining)
+}
+
+func TestDeviceUpdateClient_KeepsTokensWhenMessageIsRejected(t *testing.T) {
+	fakeFCMErrors(t)
+	sender := &fakeMulticastSender{results: map[string]error{
+		"a": errFakeInvalid,
+		"b": errFakeInvalid,
+		"c": errFakeUnavailable,
+	}}
+	store := &fakeDeviceStore{devices: []Device{{Token: "a"}, {Token: "b"}, {Token: "c"}}}
+
+	err := NewDeviceUpdateClient(sender, store).SendUpdate(context.Background(), []progress.ProgressUpdate{
+		{Title: "Moment Zero 2.0", Progress: 90, PrevProgress: 80},
+	})
+	require.ErrorIs(t, err, errFakeInvalid)
+	require.Len(t, store.devices, 3)
+}
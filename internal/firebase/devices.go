@@ -0,0 +1,85 @@
+package firebase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type (
+	// Device is an app install registered for notifications
+	Device struct {
+		Token    string
+		Platform string `dynamodbav:",omitempty"`
+		// WorkIDs are the works the device follows. Devices that follow no works in particular get every update.
+		WorkIDs   []string `dynamodbav:",stringset,omitempty"`
+		UpdatedAt time.Time
+	}
+
+	// DynamoDeviceStore stores registered devices in DynamoDB, one item per token
+	DynamoDeviceStore struct {
+		client    *dynamodb.Client
+		tableName string
+	}
+)
+
+func NewDynamoDeviceStore(dbClient *dynamodb.Client, tableName string) *DynamoDeviceStore {
+	return &DynamoDeviceStore{client: dbClient, tableName: tableName}
+}
+
+// GetDevices returns every registered device
+func (s *DynamoDeviceStore) GetDevices(ctx context.Context) ([]Device, error) {
+	devices := []Device{}
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName: aws.String(s.tableName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("scan fcm devices: %w", err)
+		}
+		var pageDevices []Device
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageDevices); err != nil {
+			return nil, fmt.Errorf("unmarshal fcm devices: %w", err)
+		}
+		devices = append(devices, pageDevices...)
+	}
+	return devices, nil
+}
+
+// RegisterDevice adds the device, replacing any earlier registration of its token
+func (s *DynamoDeviceStore) RegisterDevice(ctx context.Context, device Device) error {
+	item, err := attributevalue.MarshalMap(device)
+	if err != nil {
+		return fmt.Errorf("marshal fcm device: %w", err)
+	}
+	if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	}); err != nil {
+		return fmt.Errorf("put fcm device: %w", err)
+	}
+	return nil
+}
+
+// UnregisterDevices removes the devices with the given tokens. Unknown tokens are ignored.
+func (s *DynamoDeviceStore) UnregisterDevices(ctx context.Context, tokens ...string) error {
+	var errs []error
+	for _, token := range tokens {
+		if _, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.tableName),
+			Key: map[string]types.AttributeValue{
+				"Token": &types.AttributeValueMemberS{Value: token},
+			},
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("delete fcm device: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
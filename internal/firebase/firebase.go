@@ -3,18 +3,72 @@ package firebase
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
-	firebase "firebase.google.com/go"
-	"firebase.google.com/go/messaging"
+	firebase "firebase.google.com/go/v4"
+	"firebase.google.com/go/v4/messaging"
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/message"
 	"github.com/Rhionin/SanderServer/internal/progress"
 	"google.golang.org/api/option"
 )
 
+const (
+	// DefaultClickAction is the Android intent action that opens the Flutter app
+	DefaultClickAction = "FLUTTER_NOTIFICATION_CLICK"
+
+	collapseKey     = "progress_update"
+	notificationTTL = time.Hour
+)
+
+var (
+	ErrNoTopic           = errors.New("no topic")
+	ErrNoProgressUpdates = errors.New("no progress updates")
+
+	// DefaultMessages are the notification templates used when none are configured
+	DefaultMessages = message.Config{
+		Title: "Stormwatch",
+		Body:  "{{.Summary}}",
+		Item:  "{{.Text}}",
+	}
+)
+
+type (
+	// UpdateClient pushes updates via FCM to a global topic, and to a topic per work for apps that only follow some works
+	UpdateClient struct {
+		Sender sender
+		// Topic is the global topic. Per-work topics are derived from it with WorkTopic.
+		Topic    string
+		Messages *message.Templates
+		Locale   string
+		// ClickAction is the Android intent action to fire when the notification is tapped
+		ClickAction string
+		// Link is opened when a web push notification is clicked, e.g. the status page
+		Link string
+		// DryRun, if set, gets the messages instead of Sender
+		DryRun *dryrun.Writer
+	}
+
+	sender interface {
+		Send(ctx context.Context, message *messaging.Message) (string, error)
+	}
+)
+
 // NewMessagingClient returns a new Firebase messaging client
 func NewMessagingClient(ctx context.Context, firebaseCredentialsConfigPath string) (*messaging.Client, error) {
-	opt := option.WithCredentialsFile(firebaseCredentialsConfigPath)
+	return newMessagingClient(ctx, option.WithCredentialsFile(firebaseCredentialsConfigPath))
+}
+
+// NewMessagingClientFromJSON returns a new Firebase messaging client for the service account credentials
+func NewMessagingClientFromJSON(ctx context.Context, firebaseCredentials []byte) (*messaging.Client, error) {
+	return newMessagingClient(ctx, option.WithCredentialsJSON(firebaseCredentials))
+}
+
+func newMessagingClient(ctx context.Context, opt option.ClientOption) (*messaging.Client, error) {
 	app, err := firebase.NewApp(context.Background(), nil, opt)
 	if err != nil {
 		return nil, err
@@ -23,33 +77,165 @@ func NewMessagingClient(ctx context.Context, firebaseCredentialsConfigPath strin
 	return app.Messaging(ctx)
 }
 
-// SendFCMUpdate pushes an update via FCM
-func SendFCMUpdate(ctx context.Context, firebaseClient *messaging.Client, wips []progress.ProgressUpdate, topic string) (string, error) {
+func NewUpdateClient(sender sender, topic string) *UpdateClient {
+	return &UpdateClient{
+		Sender:      sender,
+		Topic:       topic,
+		Messages:    message.MustNew("fcm", DefaultMessages),
+		ClickAction: DefaultClickAction,
+	}
+}
+
+func (client *UpdateClient) GetName() string {
+	return "fcm"
+}
+
+// WorkTopic returns the topic for a single work, e.g. "progress_moment-zero-2-0" for the "progress" topic
+func WorkTopic(topic, workID string) string {
+	return topic + "_" + workID
+}
+
+// SendUpdate pushes an update via FCM
+func (client *UpdateClient) SendUpdate(ctx context.Context, wips []progress.ProgressUpdate) error {
+	messages, err := client.BuildMessages(wips)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, msg := range messages {
+		if client.DryRun != nil {
+			if err := writeDryRun(client.DryRun, client.GetName(), "topic:"+msg.Topic, msg); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		logging.FromContext(ctx).Debug("Sending FCM message", "topic", msg.Topic)
+		response, err := client.Sender.Send(ctx, msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("send to topic %s: %w", msg.Topic, err))
+			continue
+		}
+		logging.FromContext(ctx).Info("Sent FCM message", "topic", msg.Topic, "message_id", response)
+	}
+	return errors.Join(errs...)
+}
+
+// BuildMessages builds the message for the global topic, containing every update, followed by a message for the topic
+// of each work whose progress changed
+func (client *UpdateClient) BuildMessages(wips []progress.ProgressUpdate) ([]*messaging.Message, error) {
+	if client.Topic == "" {
+		return nil, ErrNoTopic
+	}
+	if len(wips) == 0 {
+		return nil, ErrNoProgressUpdates
+	}
+
+	global, err := client.buildMessage(client.Topic, wips)
+	if err != nil {
+		return nil, err
+	}
+	messages := []*messaging.Message{global}
+
+	for _, wip := range wips {
+		if wip.ChangeKind() == progress.ChangeKindUnchanged {
+			continue
+		}
+		workMessage, err := client.buildMessage(WorkTopic(client.Topic, wip.WorkID()), []progress.ProgressUpdate{wip})
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, workMessage)
+	}
+
+	return messages, nil
+}
+
+func (client *UpdateClient) buildMessage(topic string, wips []progress.ProgressUpdate) (*messaging.Message, error) {
+	msg, err := newMessage(client.Messages, client.Locale, client.ClickAction, client.Link, wips)
+	if err != nil {
+		return nil, err
+	}
+	msg.Topic = topic
+	return msg, nil
+}
 
-	log.Println("Sending FCM message to topic "+topic, wips)
+// newMessage builds a notification for the updates on every platform. The caller addresses it.
+func newMessage(templates *message.Templates, locale, clickAction, link string, wips []progress.ProgressUpdate) (*messaging.Message, error) {
+	msg, err := templates.Render(locale, wips)
+	if err != nil {
+		return nil, err
+	}
 
 	wipsStr, err := json.Marshal(wips)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	oneHour := time.Duration(1) * time.Hour
-	message := &messaging.Message{
-		Topic: topic,
+	ttl := notificationTTL
+	return &messaging.Message{
 		Data: map[string]string{
 			"worksInProgress": string(wipsStr),
 		},
+		Notification: &messaging.Notification{
+			Title: msg.Title,
+			Body:  msg.Body,
+		},
 		Android: &messaging.AndroidConfig{
-			TTL:      &oneHour,
+			TTL:      &ttl,
 			Priority: "normal",
 			Notification: &messaging.AndroidNotification{
-				Title:       "Stormwatch",
-				Body:        "Brandon Sanderson posted a progress update",
-				ClickAction: "FLUTTER_NOTIFICATION_CLICK",
+				Title:       msg.Title,
+				Body:        msg.Body,
+				ClickAction: clickAction,
 			},
-			CollapseKey: "progress_update",
+			CollapseKey: collapseKey,
 		},
+		APNS: &messaging.APNSConfig{
+			Headers: map[string]string{
+				"apns-priority":    "5", // Normal priority, delivered with regard to the device's power
+				"apns-collapse-id": collapseKey,
+				"apns-expiration":  strconv.FormatInt(time.Now().Add(ttl).Unix(), 10),
+			},
+			Payload: &messaging.APNSPayload{
+				Aps: &messaging.Aps{
+					Alert: &messaging.ApsAlert{
+						Title: msg.Title,
+						Body:  msg.Body,
+					},
+					Sound:    "default",
+					ThreadID: collapseKey,
+				},
+			},
+		},
+		Webpush: &messaging.WebpushConfig{
+			Headers: map[string]string{
+				"TTL":     strconv.Itoa(int(ttl.Seconds())),
+				"Urgency": "normal",
+				"Topic":   collapseKey,
+			},
+			Notification: &messaging.WebpushNotification{
+				Title: msg.Title,
+				Body:  msg.Body,
+				Tag:   collapseKey,
+			},
+			FCMOptions: webpushFCMOptions(link),
+		},
+	}, nil
+}
+
+// writeDryRun writes the message as the FCM HTTP v1 API would receive it
+func writeDryRun(w *dryrun.Writer, target, destination string, msg *messaging.Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
 	}
+	return w.Write(dryrun.Request{Target: target, Destination: destination, Payload: body})
+}
 
-	return firebaseClient.Send(ctx, message)
+func webpushFCMOptions(link string) *messaging.WebpushFCMOptions {
+	if link == "" {
+		return nil
+	}
+	return &messaging.WebpushFCMOptions{Link: link}
 }
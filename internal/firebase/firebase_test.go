@@ -0,0 +1,83 @@
+package firebase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSender struct {
+	sent []*messaging.Message
+}
+
+func (f *fakeSender) Send(ctx context.Context, message *messaging.Message) (string, error) {
+	f.sent = append(f.sent, message)
+	return "projects/test/messages/" + message.Topic, nil
+}
+
+func TestSendUpdate(t *testing.T) {
+	sender := &fakeSender{}
+	client := NewUpdateClient(sender, "progress")
+	client.Link = "https://example.com/status"
+
+	err := client.SendUpdate(context.Background(), []progress.ProgressUpdate{
+		{Title: "Moment Zero 2.0", Progress: 90, PrevProgress: 80},
+		{Title: "White Sand (Prose Version)", Progress: 20, PrevProgress: 20},
+		{Title: "Isles of the Emberdark", Progress: 10},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, sender.sent, 3)
+	global := sender.sent[0]
+	require.Equal(t, "progress", global.Topic)
+	require.Equal(t, "Stormwatch", global.Notification.Title)
+	require.Equal(t, "Moment Zero 2.0 (80% => 90%), Isles of the Emberdark (10%)", global.Notification.Body)
+	require.Equal(t, global.Notification.Body, global.Android.Notification.Body)
+	require.Equal(t, DefaultClickAction, global.Android.Notification.ClickAction)
+	require.Equal(t, global.Notification.Body, global.APNS.Payload.Aps.Alert.Body)
+	require.Equal(t, global.Notification.Body, global.Webpush.Notification.Body)
+	require.Equal(t, "https://example.com/status", global.Webpush.FCMOptions.Link)
+
+	require.Equal(t, "progress_moment-zero-2-0", sender.sent[1].Topic)
+	require.Equal(t, "Moment Zero 2.0 (80% => 90%)", sender.sent[1].Notification.Body)
+	require.Equal(t, "progress_isles-of-the-emberdark", sender.sent[2].Topic)
+}
+
+func TestSendUpdate_DryRun(t *testing.T) {
+	out := &bytes.Buffer{}
+	client := NewUpdateClient(nil, "progress")
+	client.DryRun = dryrun.New(out)
+
+	err := client.SendUpdate(context.Background(), []progress.ProgressUpdate{
+		{Title: "Moment Zero 2.0", Progress: 90, PrevProgress: 80},
+	})
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	var request dryrun.Request
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &request))
+	require.Equal(t, "fcm", request.Target)
+	require.Equal(t, "topic:progress", request.Destination)
+	// The payload is the FCM HTTP v1 message
+	var msg map[string]any
+	require.NoError(t, json.Unmarshal(request.Payload, &msg))
+	require.Equal(t, "progress", msg["topic"])
+	require.Equal(t, map[string]any{"title": "Stormwatch", "body": "Moment Zero 2.0 (80% => 90%)"}, msg["notification"])
+	require.Equal(t, "3600s", msg["android"].(map[string]any)["ttl"])
+}
+
+func TestBuildMessages_Errors(t *testing.T) {
+	_, err := NewUpdateClient(&fakeSender{}, "").BuildMessages([]progress.ProgressUpdate{{Title: "Book", Progress: 1}})
+	require.ErrorIs(t, err, ErrNoTopic)
+
+	_, err = NewUpdateClient(&fakeSender{}, "progress").BuildMessages(nil)
+	require.ErrorIs(t, err, ErrNoProgressUpdates)
+}
@@ -0,0 +1,42 @@
+package firebase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"firebase.google.com/go/v4/messaging"
+)
+
+// ErrInvalidToken is returned for registration tokens that FCM will not deliver to
+var ErrInvalidToken = errors.New("invalid registration token")
+
+type (
+	// TokenValidator checks registration tokens with FCM before they are registered, so that only tokens issued to the
+	// app are stored
+	TokenValidator struct {
+		Sender dryRunSender
+	}
+
+	dryRunSender interface {
+		SendDryRun(ctx context.Context, message *messaging.Message) (string, error)
+	}
+)
+
+// NewTokenValidator returns a validator that checks tokens with the messaging client
+func NewTokenValidator(sender dryRunSender) *TokenValidator {
+	return &TokenValidator{Sender: sender}
+}
+
+// ValidateToken sends the token a message in dry run mode, which FCM validates without delivering. It returns
+// ErrInvalidToken if FCM rejects the token.
+func (v *TokenValidator) ValidateToken(ctx context.Context, token string) error {
+	_, err := v.Sender.SendDryRun(ctx, &messaging.Message{Token: token, Data: map[string]string{"type": "validate"}})
+	if err == nil {
+		return nil
+	}
+	if isStaleTokenError(err) || isInvalidTokenError(err) {
+		return fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return fmt.Errorf("send dry run message: %w", err)
+}
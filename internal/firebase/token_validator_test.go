@@ -0,0 +1,51 @@
+package firebase
+
+import (
+	"context"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDryRunSender struct {
+	// results are the errors to report per token. Tokens without one are valid.
+	results map[string]error
+}
+
+func (f *fakeDryRunSender) SendDryRun(ctx context.Context, message *messaging.Message) (string, error) {
+	return "", f.results[message.Token]
+}
+
+func TestTokenValidator_ValidateToken(t *testing.T) {
+	fakeFCMErrors(t)
+	validator := NewTokenValidator(&fakeDryRunSender{results: map[string]error{
+		"stale":     errFakeUnregistered,
+		"malformed": errFakeInvalid,
+		"down":      errFakeUnavailable,
+	}})
+
+	tests := []struct {
+		token       string
+		expectedErr error
+	}{
+		{token: "valid"},
+		{token: "stale", expectedErr: ErrInvalidToken},
+		{token: "malformed", expectedErr: ErrInvalidToken},
+		{token: "down", expectedErr: errFakeUnavailable},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.token, func(t *testing.T) {
+			err := validator.ValidateToken(context.Background(), tc.token)
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.expectedErr)
+			if tc.expectedErr != ErrInvalidToken {
+				require.NotErrorIs(t, err, ErrInvalidToken)
+			}
+		})
+	}
+}
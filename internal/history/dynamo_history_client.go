@@ -8,57 +8,79 @@ import (
 
 	appconfig "github.com/Rhionin/SanderServer/internal/config"
 	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/tracing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	latestEntryID = "latest_entry"
+	latestEntryID   = "latest_entry"
+	notifiedEntryID = "notified_entry"
 )
 
 var (
 	ErrEmptyHistory        = errors.New("history database is empty")
 	ErrNoEntryBeforeTarget = errors.New("no history entry exists before the given target")
+	ErrNoEntryAfterTarget  = errors.New("no history entry exists after the given target")
+	ErrNoNotifiedEntry     = errors.New("no entry has been notified yet")
+	ErrEntryNotFound       = errors.New("no history entry exists at the given timestamp")
+	ErrAlreadyNotified     = errors.New("the entry has already been notified to the target")
 )
 
 type (
 	DynamoClient struct {
-		client *dynamodb.Client
+		client    *dynamodb.Client
+		tableName string
 	}
 
 	ProgressEntry struct {
 		Timestamp       time.Time
 		WorksInProgress []progress.WorkInProgress
+		// CorrelationID ties together the logs of the check that wrote the entry and of the pushes it caused. Entries
+		// written before it was introduced have none. It is stored but never served.
+		CorrelationID string `json:"-" dynamodbav:",omitempty"`
+		// Historical marks an entry added after the fact, by a backfill or an import, so that it is never pushed
+		Historical bool `json:"-" dynamodbav:",omitempty"`
 	}
 
 	ProgressDynamoEntry struct {
 		ID                string
 		TimestampUnixNano int64
 		WorksInProgress   []progress.WorkInProgress
+		CorrelationID     string `dynamodbav:",omitempty"`
+		Historical        bool   `dynamodbav:",omitempty"`
 	}
 )
 
 func NewDynamoClientFromContext(ctx context.Context) (*DynamoClient, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(appconfig.AWSRegion))
+	cfg, err := appconfig.Load(ctx, appconfig.LoadOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("load default config: %w", err)
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	awsCfg, err := cfg.AWSConfig(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	dynamoClient := dynamodb.NewFromConfig(cfg)
-	return NewDynamoClient(dynamoClient)
+	dynamoClient := dynamodb.NewFromConfig(awsCfg)
+	return NewDynamoClient(dynamoClient, cfg.HistoryTable)
 }
 
-func NewDynamoClient(dbClient *dynamodb.Client) (*DynamoClient, error) {
-	return &DynamoClient{client: dbClient}, nil
+func NewDynamoClient(dbClient *dynamodb.Client, tableName string) (*DynamoClient, error) {
+	return &DynamoClient{client: dbClient, tableName: tableName}, nil
 }
 
-func (c *DynamoClient) GetLatestProgressEntry(ctx context.Context) (ProgressEntry, error) {
+func (c *DynamoClient) GetLatestProgressEntry(ctx context.Context) (_ ProgressEntry, err error) {
+	ctx, span := c.startSpan(ctx, "GetLatestProgressEntry")
+	defer endSpan(span, &err)
+
 	latestProgressResult, err := c.client.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(appconfig.HistoryDynamoTableName),
+		TableName:              aws.String(c.tableName),
 		KeyConditionExpression: aws.String("ID = :id"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":id": &types.AttributeValueMemberS{Value: latestEntryID},
@@ -87,19 +109,22 @@ func (c *DynamoClient) GetLatestProgressEntry(ctx context.Context) (ProgressEntr
 		return ProgressEntry{}, fmt.Errorf("unmarshal DynamoDB item: %w", err)
 	}
 
-	return latestProgressFromHistory.toProgressEntry(), nil
+	return latestProgressFromHistory.ToProgressEntry(), nil
 }
 
-func (c *DynamoClient) AddNewProgressEntry(ctx context.Context, entry ProgressEntry) error {
+func (c *DynamoClient) AddNewProgressEntry(ctx context.Context, entry ProgressEntry) (err error) {
+	ctx, span := c.startSpan(ctx, "AddNewProgressEntry")
+	defer endSpan(span, &err)
+
 	if len(entry.WorksInProgress) == 0 {
 		return fmt.Errorf("cannot add progress entry with no works in progress")
 	}
-	dynamoItem, err := attributevalue.MarshalMap(entry.toDynamoProgressEntry())
+	dynamoItem, err := attributevalue.MarshalMap(entry.ToDynamoProgressEntry())
 	if err != nil {
 		return fmt.Errorf("marshal progress dymamo entry: %w", err)
 	}
 	if _, err = c.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(appconfig.HistoryDynamoTableName),
+		TableName: aws.String(c.tableName),
 		Item:      dynamoItem,
 	}); err != nil {
 		return fmt.Errorf("put history entry into dynamoDB: %w", err)
@@ -108,10 +133,13 @@ func (c *DynamoClient) AddNewProgressEntry(ctx context.Context, entry ProgressEn
 	return nil
 }
 
-func (c *DynamoClient) GetLatestProgressEntryBeforeID(ctx context.Context, targetEntry ProgressDynamoEntry) (ProgressEntry, error) {
+func (c *DynamoClient) GetLatestProgressEntryBeforeID(ctx context.Context, targetEntry ProgressDynamoEntry) (_ ProgressEntry, err error) {
+	ctx, span := c.startSpan(ctx, "GetLatestProgressEntryBeforeID")
+	defer endSpan(span, &err)
+
 	// Now, query for entries with timestamps less than the target timestamp
 	latestBeforeTargetResult, err := c.client.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(appconfig.HistoryDynamoTableName),
+		TableName:              aws.String(c.tableName),
 		KeyConditionExpression: aws.String("ID = :id AND TimestampUnixNano < :timestamp"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":id":        &types.AttributeValueMemberS{Value: latestEntryID},
@@ -135,12 +163,223 @@ func (c *DynamoClient) GetLatestProgressEntryBeforeID(ctx context.Context, targe
 		return ProgressEntry{}, fmt.Errorf("unmarshal DynamoDB item: %w", err)
 	}
 
-	return latestBeforeTarget.toProgressEntry(), nil
+	return latestBeforeTarget.ToProgressEntry(), nil
+}
+
+// GetProgressEntries returns up to limit of the most recent history entries, newest first
+func (c *DynamoClient) GetProgressEntries(ctx context.Context, limit int32) (_ []ProgressEntry, err error) {
+	ctx, span := c.startSpan(ctx, "GetProgressEntries")
+	defer endSpan(span, &err)
+
+	result, err := c.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(c.tableName),
+		KeyConditionExpression: aws.String("ID = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: latestEntryID},
+		},
+		ScanIndexForward: aws.Bool(false), // Get the latest (descending order)
+		Limit:            aws.Int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query for progress entries: %w", err)
+	}
+
+	var dynamoEntries []ProgressDynamoEntry
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &dynamoEntries); err != nil {
+		return nil, fmt.Errorf("unmarshal DynamoDB items: %w", err)
+	}
+
+	entries := make([]ProgressEntry, len(dynamoEntries))
+	for i, dynamoEntry := range dynamoEntries {
+		entries[i] = dynamoEntry.ToProgressEntry()
+	}
+	return entries, nil
+}
+
+// GetProgressEntry returns the history entry written at exactly timestamp
+func (c *DynamoClient) GetProgressEntry(ctx context.Context, timestamp time.Time) (_ ProgressEntry, err error) {
+	ctx, span := c.startSpan(ctx, "GetProgressEntry")
+	defer endSpan(span, &err)
+
+	result, err := c.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(c.tableName),
+		Key:       progressEntryKey(timestamp),
+	})
+	if err != nil {
+		return ProgressEntry{}, fmt.Errorf("get history entry from DynamoDB: %w", err)
+	}
+	if result.Item == nil {
+		return ProgressEntry{}, ErrEntryNotFound
+	}
+
+	var entry ProgressDynamoEntry
+	if err := attributevalue.UnmarshalMap(result.Item, &entry); err != nil {
+		return ProgressEntry{}, fmt.Errorf("unmarshal DynamoDB item: %w", err)
+	}
+	return entry.ToProgressEntry(), nil
+}
+
+// DeleteProgressEntry deletes the history entry written at exactly timestamp
+func (c *DynamoClient) DeleteProgressEntry(ctx context.Context, timestamp time.Time) (err error) {
+	ctx, span := c.startSpan(ctx, "DeleteProgressEntry")
+	defer endSpan(span, &err)
+
+	result, err := c.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(c.tableName),
+		Key:          progressEntryKey(timestamp),
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return fmt.Errorf("delete history entry from DynamoDB: %w", err)
+	}
+	if len(result.Attributes) == 0 {
+		return ErrEntryNotFound
+	}
+	return nil
+}
+
+// GetEarliestProgressEntryAfter returns the first history entry written after timestamp
+func (c *DynamoClient) GetEarliestProgressEntryAfter(ctx context.Context, timestamp time.Time) (_ ProgressEntry, err error) {
+	ctx, span := c.startSpan(ctx, "GetEarliestProgressEntryAfter")
+	defer endSpan(span, &err)
+
+	earliestAfterTargetResult, err := c.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(c.tableName),
+		KeyConditionExpression: aws.String("ID = :id AND TimestampUnixNano > :timestamp"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id":        &types.AttributeValueMemberS{Value: latestEntryID},
+			":timestamp": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", timestamp.UnixNano())},
+		},
+		ScanIndexForward: aws.Bool(true), // Get the earliest (ascending order)
+		Limit:            aws.Int32(1),
+	})
+	if err != nil {
+		return ProgressEntry{}, fmt.Errorf("query for earliest entry after target: %w", err)
+	}
+
+	if len(earliestAfterTargetResult.Items) == 0 {
+		return ProgressEntry{}, ErrNoEntryAfterTarget
+	}
+
+	var earliestAfterTarget ProgressDynamoEntry
+	err = attributevalue.UnmarshalMap(earliestAfterTargetResult.Items[0], &earliestAfterTarget)
+	if err != nil {
+		return ProgressEntry{}, fmt.Errorf("unmarshal DynamoDB item: %w", err)
+	}
+
+	return earliestAfterTarget.ToProgressEntry(), nil
+}
+
+// WalkProgressEntries calls walk with every history entry, oldest first, until walk returns an error. The entries are
+// queried a page at a time, so the history is never held in memory as a whole.
+func (c *DynamoClient) WalkProgressEntries(ctx context.Context, walk func(ProgressEntry) error) (err error) {
+	ctx, span := c.startSpan(ctx, "WalkProgressEntries")
+	defer endSpan(span, &err)
+
+	paginator := dynamodb.NewQueryPaginator(c.client, &dynamodb.QueryInput{
+		TableName:              aws.String(c.tableName),
+		KeyConditionExpression: aws.String("ID = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: latestEntryID},
+		},
+		ScanIndexForward: aws.Bool(true), // Oldest first
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("query for progress entries: %w", err)
+		}
+		var dynamoEntries []ProgressDynamoEntry
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &dynamoEntries); err != nil {
+			return fmt.Errorf("unmarshal DynamoDB items: %w", err)
+		}
+		for _, dynamoEntry := range dynamoEntries {
+			if err := walk(dynamoEntry.ToProgressEntry()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetLatestNotifiedEntry returns the progress that was most recently sent to the push target
+func (c *DynamoClient) GetLatestNotifiedEntry(ctx context.Context, target string) (_ ProgressEntry, err error) {
+	ctx, span := c.startSpan(ctx, "GetLatestNotifiedEntry")
+	defer endSpan(span, &err)
+
+	latestNotifiedResult, err := c.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(c.tableName),
+		KeyConditionExpression: aws.String("ID = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: notifiedEntryKey(target)},
+		},
+		ScanIndexForward: aws.Bool(false), // Get the latest (descending order)
+		Limit:            aws.Int32(1),
+	})
+	if err != nil {
+		return ProgressEntry{}, fmt.Errorf("get latest notified entry from DynamoDB: %w", err)
+	}
+	if len(latestNotifiedResult.Items) == 0 {
+		return ProgressEntry{}, ErrNoNotifiedEntry
+	}
+
+	var latestNotified ProgressDynamoEntry
+	if err := attributevalue.UnmarshalMap(latestNotifiedResult.Items[0], &latestNotified); err != nil {
+		return ProgressEntry{}, fmt.Errorf("unmarshal DynamoDB item: %w", err)
+	}
+	return latestNotified.ToProgressEntry(), nil
+}
+
+// AddNotifiedEntry records that the progress in entry has been sent to the push target. The write is conditional, so
+// that of two invocations notifying the target of the same entry only one succeeds; the other gets ErrAlreadyNotified.
+func (c *DynamoClient) AddNotifiedEntry(ctx context.Context, entry ProgressEntry, target string) (err error) {
+	ctx, span := c.startSpan(ctx, "AddNotifiedEntry")
+	defer endSpan(span, &err)
+
+	notifiedEntry := entry.ToDynamoProgressEntry()
+	notifiedEntry.ID = notifiedEntryKey(target)
+	dynamoItem, err := attributevalue.MarshalMap(notifiedEntry)
+	if err != nil {
+		return fmt.Errorf("marshal notified dynamo entry: %w", err)
+	}
+	_, err = c.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(c.tableName),
+		Item:                dynamoItem,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrAlreadyNotified
+	} else if err != nil {
+		return fmt.Errorf("put notified entry into dynamoDB: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteNotifiedEntry removes the record that entry has been sent to the push target, so that it is sent again
+func (c *DynamoClient) DeleteNotifiedEntry(ctx context.Context, entry ProgressEntry, target string) (err error) {
+	ctx, span := c.startSpan(ctx, "DeleteNotifiedEntry")
+	defer endSpan(span, &err)
+
+	if _, err := c.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(c.tableName),
+		Key: map[string]types.AttributeValue{
+			"ID":                &types.AttributeValueMemberS{Value: notifiedEntryKey(target)},
+			"TimestampUnixNano": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", entry.Timestamp.UnixNano())},
+		},
+	}); err != nil {
+		return fmt.Errorf("delete notified entry from DynamoDB: %w", err)
+	}
+	return nil
 }
 
-func (c *DynamoClient) GetEntryCount(ctx context.Context) (int32, error) {
+func (c *DynamoClient) GetEntryCount(ctx context.Context) (_ int32, err error) {
+	ctx, span := c.startSpan(ctx, "GetEntryCount")
+	defer endSpan(span, &err)
+
 	result, err := c.client.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(appconfig.HistoryDynamoTableName),
+		TableName:              aws.String(c.tableName),
 		KeyConditionExpression: aws.String("ID = :id"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":id": &types.AttributeValueMemberS{Value: latestEntryID},
@@ -154,17 +393,58 @@ func (c *DynamoClient) GetEntryCount(ctx context.Context) (int32, error) {
 	return result.Count, nil
 }
 
-func (e ProgressDynamoEntry) toProgressEntry() ProgressEntry {
+func progressEntryKey(timestamp time.Time) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"ID":                &types.AttributeValueMemberS{Value: latestEntryID},
+		"TimestampUnixNano": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", timestamp.UnixNano())},
+	}
+}
+
+// notifiedEntryKey is the ID of the target's notified entries
+func notifiedEntryKey(target string) string {
+	return notifiedEntryID + "#" + target
+}
+
+// startSpan starts a span for a call to the table
+func (c *DynamoClient) startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "DynamoClient."+operation,
+		attribute.String("db.system.name", "aws.dynamodb"),
+		attribute.StringSlice("aws.dynamodb.table_names", []string{c.tableName}),
+	)
+}
+
+// endSpan ends the span. Running out of entries is an expected outcome, not an error.
+func endSpan(span trace.Span, err *error) {
+	if errors.Is(*err, ErrEmptyHistory) || errors.Is(*err, ErrNoEntryBeforeTarget) ||
+		errors.Is(*err, ErrNoEntryAfterTarget) || errors.Is(*err, ErrNoNotifiedEntry) || errors.Is(*err, ErrEntryNotFound) ||
+		errors.Is(*err, ErrAlreadyNotified) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+// IsProgressEntry reports whether the item is a scraped history entry, as opposed to bookkeeping such as notified entries
+func (e ProgressDynamoEntry) IsProgressEntry() bool {
+	return e.ID == latestEntryID
+}
+
+// ToProgressEntry converts the DynamoDB item to a ProgressEntry
+func (e ProgressDynamoEntry) ToProgressEntry() ProgressEntry {
 	return ProgressEntry{
 		Timestamp:       time.Unix(0, e.TimestampUnixNano),
 		WorksInProgress: e.WorksInProgress,
+		CorrelationID:   e.CorrelationID,
+		Historical:      e.Historical,
 	}
 }
 
-func (e ProgressEntry) toDynamoProgressEntry() ProgressDynamoEntry {
+// ToDynamoProgressEntry converts the entry to the DynamoDB item the history writes for it
+func (e ProgressEntry) ToDynamoProgressEntry() ProgressDynamoEntry {
 	return ProgressDynamoEntry{
 		ID:                latestEntryID,
 		TimestampUnixNano: e.Timestamp.UnixNano(),
 		WorksInProgress:   e.WorksInProgress,
+		CorrelationID:     e.CorrelationID,
+		Historical:        e.Historical,
 	}
 }
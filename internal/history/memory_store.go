@@ -0,0 +1,265 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// Store is the progress history, so that it can be kept somewhere other than DynamoDB
+	Store interface {
+		GetLatestProgressEntry(ctx context.Context) (ProgressEntry, error)
+		AddNewProgressEntry(ctx context.Context, entry ProgressEntry) error
+		GetLatestProgressEntryBeforeID(ctx context.Context, targetEntry ProgressDynamoEntry) (ProgressEntry, error)
+		GetProgressEntries(ctx context.Context, limit int32) ([]ProgressEntry, error)
+		GetProgressEntry(ctx context.Context, timestamp time.Time) (ProgressEntry, error)
+		DeleteProgressEntry(ctx context.Context, timestamp time.Time) error
+		GetEarliestProgressEntryAfter(ctx context.Context, timestamp time.Time) (ProgressEntry, error)
+		WalkProgressEntries(ctx context.Context, walk func(ProgressEntry) error) error
+		GetLatestNotifiedEntry(ctx context.Context, target string) (ProgressEntry, error)
+		AddNotifiedEntry(ctx context.Context, entry ProgressEntry, target string) error
+		DeleteNotifiedEntry(ctx context.Context, entry ProgressEntry, target string) error
+		GetEntryCount(ctx context.Context) (int32, error)
+	}
+
+	// MemoryStore keeps the history in memory, and optionally in a JSON file so that it survives restarts
+	MemoryStore struct {
+		mu       sync.RWMutex
+		path     string
+		contents memoryStoreContents
+	}
+
+	memoryStoreContents struct {
+		Entries []ProgressEntry // oldest first
+		// NotifiedByTarget is the progress sent to each target
+		NotifiedByTarget map[string][]ProgressEntry `json:",omitempty"` // oldest first
+	}
+)
+
+var (
+	_ Store = (*DynamoClient)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// NewMemoryStore returns an empty history that is lost when the process exits
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// NewFileStore returns the history saved in the JSON file at path, which is created on the first write if it does not
+// exist
+func NewFileStore(path string) (*MemoryStore, error) {
+	store := &MemoryStore{path: path}
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read history file: %w", err)
+	}
+	if err := json.Unmarshal(contents, &store.contents); err != nil {
+		return nil, fmt.Errorf("unmarshal history file: %w", err)
+	}
+	return store, nil
+}
+
+func (s *MemoryStore) GetLatestProgressEntry(ctx context.Context) (ProgressEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.contents.Entries) == 0 {
+		return ProgressEntry{}, ErrEmptyHistory
+	}
+	return s.contents.Entries[len(s.contents.Entries)-1], nil
+}
+
+func (s *MemoryStore) AddNewProgressEntry(ctx context.Context, entry ProgressEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.contents.Entries = insertSorted(s.contents.Entries, entry)
+	return s.save()
+}
+
+func (s *MemoryStore) GetLatestProgressEntryBeforeID(ctx context.Context, targetEntry ProgressDynamoEntry) (ProgressEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.contents.Entries) - 1; i >= 0; i-- {
+		if s.contents.Entries[i].Timestamp.UnixNano() < targetEntry.TimestampUnixNano {
+			return s.contents.Entries[i], nil
+		}
+	}
+	return ProgressEntry{}, ErrNoEntryBeforeTarget
+}
+
+// GetProgressEntries returns up to limit entries, newest first
+func (s *MemoryStore) GetProgressEntries(ctx context.Context, limit int32) ([]ProgressEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := []ProgressEntry{}
+	for i := len(s.contents.Entries) - 1; i >= 0 && len(entries) < int(limit); i-- {
+		entries = append(entries, s.contents.Entries[i])
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) GetProgressEntry(ctx context.Context, timestamp time.Time) (ProgressEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, found := findEntry(s.contents.Entries, timestamp)
+	if !found {
+		return ProgressEntry{}, ErrEntryNotFound
+	}
+	return s.contents.Entries[i], nil
+}
+
+func (s *MemoryStore) DeleteProgressEntry(ctx context.Context, timestamp time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, found := findEntry(s.contents.Entries, timestamp)
+	if !found {
+		return ErrEntryNotFound
+	}
+	s.contents.Entries = slices.Delete(s.contents.Entries, i, i+1)
+	return s.save()
+}
+
+func (s *MemoryStore) GetEarliestProgressEntryAfter(ctx context.Context, timestamp time.Time) (ProgressEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// Export walks the whole history through here, so find the entry without a linear scan
+	i, found := findEntry(s.contents.Entries, timestamp)
+	if found {
+		i++
+	}
+	if i == len(s.contents.Entries) {
+		return ProgressEntry{}, ErrNoEntryAfterTarget
+	}
+	return s.contents.Entries[i], nil
+}
+
+// WalkProgressEntries calls walk with every history entry, oldest first, until walk returns an error
+func (s *MemoryStore) WalkProgressEntries(ctx context.Context, walk func(ProgressEntry) error) error {
+	s.mu.RLock()
+	entries := slices.Clone(s.contents.Entries)
+	s.mu.RUnlock()
+	for _, entry := range entries {
+		if err := walk(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetLatestNotifiedEntry(ctx context.Context, target string) (ProgressEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	notified := s.contents.NotifiedByTarget[target]
+	if len(notified) == 0 {
+		return ProgressEntry{}, ErrNoNotifiedEntry
+	}
+	return notified[len(notified)-1], nil
+}
+
+func (s *MemoryStore) AddNotifiedEntry(ctx context.Context, entry ProgressEntry, target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notified := s.contents.NotifiedByTarget[target]
+	if _, found := findEntry(notified, entry.Timestamp); found {
+		return ErrAlreadyNotified
+	}
+	if s.contents.NotifiedByTarget == nil {
+		s.contents.NotifiedByTarget = map[string][]ProgressEntry{}
+	}
+	s.contents.NotifiedByTarget[target] = insertSorted(notified, entry)
+	return s.save()
+}
+
+func (s *MemoryStore) DeleteNotifiedEntry(ctx context.Context, entry ProgressEntry, target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notified := s.contents.NotifiedByTarget[target]
+	i, found := findEntry(notified, entry.Timestamp)
+	if !found {
+		return nil
+	}
+	s.contents.NotifiedByTarget[target] = slices.Delete(notified, i, i+1)
+	return s.save()
+}
+
+func (s *MemoryStore) GetEntryCount(ctx context.Context) (int32, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int32(len(s.contents.Entries)), nil
+}
+
+// MarshalJSON keeps the entries' correlation IDs and historical marks in the file
+func (c memoryStoreContents) MarshalJSON() ([]byte, error) {
+	type contents memoryStoreContents
+	entries := make([]storedEntry, len(c.Entries))
+	for i, entry := range c.Entries {
+		entries[i] = storedEntry(entry)
+	}
+	return json.Marshal(struct {
+		contents
+		Entries []storedEntry
+	}{contents(c), entries})
+}
+
+// UnmarshalJSON reads the entries' correlation IDs and historical marks from the file
+func (c *memoryStoreContents) UnmarshalJSON(data []byte) error {
+	type contents memoryStoreContents
+	file := struct {
+		*contents
+		Entries []storedEntry
+	}{contents: (*contents)(c)}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	c.Entries = make([]ProgressEntry, len(file.Entries))
+	for i, entry := range file.Entries {
+		c.Entries[i] = ProgressEntry(entry)
+	}
+	return nil
+}
+
+// save writes the history to the file, if there is one. The caller must hold the write lock.
+func (s *MemoryStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	contents, err := json.Marshal(s.contents)
+	if err != nil {
+		return fmt.Errorf("marshal history: %w", err)
+	}
+	// Write to a temporary file and rename it so that a crash never leaves a partial history behind
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, contents, 0o644); err != nil {
+		return fmt.Errorf("write history file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("replace history file: %w", err)
+	}
+	return nil
+}
+
+// findEntry returns where the entry with the timestamp is, or would be inserted, in timestamp order
+func findEntry(entries []ProgressEntry, timestamp time.Time) (int, bool) {
+	return slices.BinarySearchFunc(entries, timestamp, func(entry ProgressEntry, target time.Time) int {
+		return entry.Timestamp.Compare(target)
+	})
+}
+
+// insertSorted inserts the entry in timestamp order, replacing an entry with the same timestamp as DynamoDB would
+func insertSorted(entries []ProgressEntry, entry ProgressEntry) []ProgressEntry {
+	i, found := findEntry(entries, entry.Timestamp)
+	if found {
+		entries[i] = entry
+		return entries
+	}
+	return slices.Insert(entries, i, entry)
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.json")
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	_, err = store.GetLatestProgressEntry(ctx)
+	require.ErrorIs(t, err, ErrEmptyHistory)
+
+	first := ProgressEntry{Timestamp: time.Unix(100, 0).UTC(), WorksInProgress: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 80}}}
+	second := ProgressEntry{Timestamp: time.Unix(200, 0).UTC(), WorksInProgress: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 90}}}
+	third := ProgressEntry{Timestamp: time.Unix(300, 0).UTC(), WorksInProgress: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 100}}, CorrelationID: "third"}
+	// Out of order, to check that entries are kept sorted
+	require.NoError(t, store.AddNewProgressEntry(ctx, third))
+	require.NoError(t, store.AddNewProgressEntry(ctx, first))
+	require.NoError(t, store.AddNewProgressEntry(ctx, second))
+	require.NoError(t, store.AddNotifiedEntry(ctx, second, "slack"))
+	require.ErrorIs(t, store.AddNotifiedEntry(ctx, second, "slack"), ErrAlreadyNotified)
+	require.NoError(t, store.AddNotifiedEntry(ctx, third, "fcm"))
+	require.NoError(t, store.DeleteNotifiedEntry(ctx, third, "fcm"))
+
+	// Everything survives reopening the file
+	store, err = NewFileStore(path)
+	require.NoError(t, err)
+
+	latest, err := store.GetLatestProgressEntry(ctx)
+	require.NoError(t, err)
+	require.Equal(t, third, latest)
+
+	before, err := store.GetLatestProgressEntryBeforeID(ctx, ProgressDynamoEntry{TimestampUnixNano: third.Timestamp.UnixNano()})
+	require.NoError(t, err)
+	require.Equal(t, second, before)
+	_, err = store.GetLatestProgressEntryBeforeID(ctx, ProgressDynamoEntry{TimestampUnixNano: first.Timestamp.UnixNano()})
+	require.ErrorIs(t, err, ErrNoEntryBeforeTarget)
+
+	after, err := store.GetEarliestProgressEntryAfter(ctx, first.Timestamp)
+	require.NoError(t, err)
+	require.Equal(t, second, after)
+	_, err = store.GetEarliestProgressEntryAfter(ctx, third.Timestamp)
+	require.ErrorIs(t, err, ErrNoEntryAfterTarget)
+
+	entries, err := store.GetProgressEntries(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, []ProgressEntry{third, second}, entries)
+
+	notified, err := store.GetLatestNotifiedEntry(ctx, "slack")
+	require.NoError(t, err)
+	require.Equal(t, second, notified)
+	_, err = store.GetLatestNotifiedEntry(ctx, "fcm")
+	require.ErrorIs(t, err, ErrNoNotifiedEntry)
+
+	count, err := store.GetEntryCount(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, count)
+
+	entry, err := store.GetProgressEntry(ctx, second.Timestamp)
+	require.NoError(t, err)
+	require.Equal(t, second, entry)
+	require.NoError(t, store.DeleteProgressEntry(ctx, second.Timestamp))
+	_, err = store.GetProgressEntry(ctx, second.Timestamp)
+	require.ErrorIs(t, err, ErrEntryNotFound)
+	require.ErrorIs(t, store.DeleteProgressEntry(ctx, second.Timestamp), ErrEntryNotFound)
+
+	store, err = NewFileStore(path)
+	require.NoError(t, err)
+	entries, err = store.GetProgressEntries(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, []ProgressEntry{third, first}, entries)
+}
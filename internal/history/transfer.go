@@ -0,0 +1,251 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+// Export and import formats
+const (
+	// FormatJSONL writes one JSON ProgressEntry per line, with its correlation ID
+	FormatJSONL = "jsonl"
+	// FormatCSV writes one timestamp,work,percent row per work. Correlation IDs are not kept.
+	FormatCSV = "csv"
+)
+
+// Formats lists the export and import formats
+var Formats = []string{FormatJSONL, FormatCSV}
+
+var csvHeader = []string{"timestamp", "work", "percent"}
+
+// storedEntry is a ProgressEntry as exports and history files keep it, with its correlation ID and historical mark
+type storedEntry struct {
+	Timestamp       time.Time
+	WorksInProgress []progress.WorkInProgress
+	CorrelationID   string `json:",omitempty"`
+	Historical      bool   `json:",omitempty"`
+}
+
+// ImportResult counts what an import did
+type ImportResult struct {
+	// Imported is the number of entries added to the history
+	Imported int
+	// Duplicates is the number of entries skipped because an entry with the same timestamp was already in the history
+	// or earlier in the input
+	Duplicates int
+}
+
+// Validate reports why an entry cannot be stored, if it cannot
+func (e ProgressEntry) Validate() error {
+	if e.Timestamp.IsZero() {
+		return errors.New("missing timestamp")
+	}
+	if len(e.WorksInProgress) == 0 {
+		return errors.New("no works in progress")
+	}
+	titles := map[string]bool{}
+	for _, wip := range e.WorksInProgress {
+		if wip.Title == "" {
+			return errors.New("work has no title")
+		}
+		if titles[wip.Title] {
+			return fmt.Errorf("work %q appears twice", wip.Title)
+		}
+		titles[wip.Title] = true
+		if wip.Progress < 0 || wip.Progress > 100 {
+			return fmt.Errorf("work %q has progress %d, outside 0-100", wip.Title, wip.Progress)
+		}
+	}
+	return nil
+}
+
+// Export writes every entry in the history to w, oldest first. The history is walked a page at a time, so it is never
+// held in memory as a whole. It returns the number of entries written.
+func Export(ctx context.Context, store Store, w io.Writer, format string) (int, error) {
+	var write func(ProgressEntry) error
+	var flush func() error
+	switch format {
+	case FormatJSONL:
+		buffered := bufio.NewWriter(w)
+		encoder := json.NewEncoder(buffered)
+		write = func(entry ProgressEntry) error { return encoder.Encode(storedEntry(entry)) }
+		flush = buffered.Flush
+	case FormatCSV:
+		csvWriter := csv.NewWriter(w)
+		if err := csvWriter.Write(csvHeader); err != nil {
+			return 0, fmt.Errorf("write CSV header: %w", err)
+		}
+		write = func(entry ProgressEntry) error {
+			timestamp := entry.Timestamp.UTC().Format(time.RFC3339Nano)
+			for _, wip := range entry.WorksInProgress {
+				if err := csvWriter.Write([]string{timestamp, wip.Title, strconv.Itoa(wip.Progress)}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	default:
+		return 0, fmt.Errorf("unknown format %q", format)
+	}
+
+	count := 0
+	if err := store.WalkProgressEntries(ctx, func(entry ProgressEntry) error {
+		if err := write(entry); err != nil {
+			return fmt.Errorf("write entry %d: %w", entry.Timestamp.UnixNano(), err)
+		}
+		count++
+		return nil
+	}); err != nil {
+		return count, fmt.Errorf("walk history: %w", err)
+	}
+
+	if err := flush(); err != nil {
+		return count, fmt.Errorf("flush export: %w", err)
+	}
+	return count, nil
+}
+
+// Import adds the entries read from r to the history. Every entry is read and validated before any is added, so that
+// a bad file imports nothing. Entries whose timestamp is already in the history, or earlier in the input, are skipped.
+// Imported entries are marked historical, so that they are not pushed.
+func Import(ctx context.Context, store Store, r io.Reader, format string) (ImportResult, error) {
+	var entries []ProgressEntry
+	var err error
+	switch format {
+	case FormatJSONL:
+		entries, err = readJSONL(r)
+	case FormatCSV:
+		entries, err = readCSV(r)
+	default:
+		return ImportResult{}, fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result := ImportResult{}
+	seen := map[int64]bool{}
+	for _, entry := range entries {
+		if seen[entry.Timestamp.UnixNano()] {
+			result.Duplicates++
+			continue
+		}
+		seen[entry.Timestamp.UnixNano()] = true
+
+		_, err := store.GetProgressEntry(ctx, entry.Timestamp)
+		if err == nil {
+			result.Duplicates++
+			continue
+		} else if !errors.Is(err, ErrEntryNotFound) {
+			return result, fmt.Errorf("get entry %d: %w", entry.Timestamp.UnixNano(), err)
+		}
+
+		entry.Historical = true
+		if err := store.AddNewProgressEntry(ctx, entry); err != nil {
+			return result, fmt.Errorf("add entry %d: %w", entry.Timestamp.UnixNano(), err)
+		}
+		result.Imported++
+	}
+	return result, nil
+}
+
+// readJSONL reads and validates one entry per line. Blank lines are ignored.
+func readJSONL(r io.Reader) ([]ProgressEntry, error) {
+	var entries []ProgressEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var stored storedEntry
+		if err := json.Unmarshal(scanner.Bytes(), &stored); err != nil {
+			return nil, fmt.Errorf("line %d: unmarshal entry: %w", line, err)
+		}
+		entry := ProgressEntry(stored)
+		if err := entry.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read entries: %w", err)
+	}
+	return entries, nil
+}
+
+// readCSV reads and validates the rows of a CSV export. Consecutive rows with the same timestamp make up one entry.
+func readCSV(r io.Reader) ([]ProgressEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(csvHeader)
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+	for i, column := range csvHeader {
+		if header[i] != column {
+			return nil, fmt.Errorf("CSV header %v, expected %v", header, csvHeader)
+		}
+	}
+
+	var entries []ProgressEntry
+	var current ProgressEntry
+	currentLine := 0
+	finish := func() error {
+		if current.Timestamp.IsZero() {
+			return nil
+		}
+		if err := current.Validate(); err != nil {
+			return fmt.Errorf("line %d: %w", currentLine, err)
+		}
+		entries = append(entries, current)
+		return nil
+	}
+
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("read CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		timestamp, err := time.Parse(time.RFC3339Nano, row[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid timestamp %q", line, row[0])
+		}
+		percent, err := strconv.Atoi(row[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid percent %q", line, row[2])
+		}
+
+		if !timestamp.Equal(current.Timestamp) {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			current = ProgressEntry{Timestamp: timestamp}
+			currentLine = line
+		}
+		current.WorksInProgress = append(current.WorksInProgress, progress.WorkInProgress{Title: row[1], Progress: percent})
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,180 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+func newTransferStore(t *testing.T) (*MemoryStore, []ProgressEntry) {
+	entries := []ProgressEntry{
+		{Timestamp: time.Unix(100, 5).UTC(), WorksInProgress: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 80}}, CorrelationID: "first"},
+		{Timestamp: time.Unix(200, 0).UTC(), WorksInProgress: []progress.WorkInProgress{
+			{Title: "Moment Zero 2.0", Progress: 90},
+			{Title: "Isles of the Emberdark, \"Draft\"", Progress: 5},
+		}},
+	}
+	store := NewMemoryStore()
+	for _, entry := range entries {
+		require.NoError(t, store.AddNewProgressEntry(context.Background(), entry))
+	}
+	return store, entries
+}
+
+func TestExport(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: FormatJSONL,
+			expected: `{"Timestamp":"1970-01-01T00:01:40.000000005Z","WorksInProgress":[{"title":"Moment Zero 2.0","progress":80}],"CorrelationID":"first"}
+{"Timestamp":"1970-01-01T00:03:20Z","WorksInProgress":[{"title":"Moment Zero 2.0","progress":90},{"title":"Isles of the Emberdark, \"Draft\"","progress":5}]}
+`,
+		},
+		{
+			format: FormatCSV,
+			expected: `timestamp,work,percent
+1970-01-01T00:01:40.000000005Z,Moment Zero 2.0,80
+1970-01-01T00:03:20Z,Moment Zero 2.0,90
+1970-01-01T00:03:20Z,"Isles of the Emberdark, ""Draft""",5
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.format, func(t *testing.T) {
+			store, _ := newTransferStore(t)
+			out := &bytes.Buffer{}
+			count, err := Export(context.Background(), store, out, tc.format)
+			require.NoError(t, err)
+			require.Equal(t, 2, count)
+			require.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			ctx := context.Background()
+			source, entries := newTransferStore(t)
+			exported := &bytes.Buffer{}
+			_, err := Export(ctx, source, exported, format)
+			require.NoError(t, err)
+
+			// The first entry is already there, so only the second is imported
+			destination := NewMemoryStore()
+			require.NoError(t, destination.AddNewProgressEntry(ctx, entries[0]))
+			result, err := Import(ctx, destination, bytes.NewReader(exported.Bytes()), format)
+			require.NoError(t, err)
+			require.Equal(t, ImportResult{Imported: 1, Duplicates: 1}, result)
+
+			imported, err := destination.GetProgressEntry(ctx, entries[1].Timestamp)
+			require.NoError(t, err)
+			expected := entries[1]
+			expected.Historical = true
+			require.Equal(t, expected, imported)
+
+			// Importing again changes nothing
+			result, err = Import(ctx, destination, bytes.NewReader(exported.Bytes()), format)
+			require.NoError(t, err)
+			require.Equal(t, ImportResult{Duplicates: 2}, result)
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		input    string
+		expected ImportResult
+		err      string
+	}{
+		{
+			name:   "JSON Lines duplicate in input",
+			format: FormatJSONL,
+			input: `{"Timestamp":"1970-01-01T00:01:40Z","WorksInProgress":[{"title":"Dragonsteel","progress":10}]}
+
+{"Timestamp":"1970-01-01T00:01:40Z","WorksInProgress":[{"title":"Dragonsteel","progress":20}]}
+`,
+			expected: ImportResult{Imported: 1, Duplicates: 1},
+		},
+		{
+			name:   "JSON Lines invalid JSON",
+			format: FormatJSONL,
+			input:  "{\"Timestamp\":\"1970-01-01T00:01:40Z\",\"WorksInProgress\":[{\"title\":\"Dragonsteel\",\"progress\":10}]}\n{",
+			err:    "line 2: unmarshal entry",
+		},
+		{
+			name:   "JSON Lines missing timestamp",
+			format: FormatJSONL,
+			input:  `{"WorksInProgress":[{"title":"Dragonsteel","progress":10}]}`,
+			err:    "line 1: missing timestamp",
+		},
+		{
+			name:   "JSON Lines no works",
+			format: FormatJSONL,
+			input:  `{"Timestamp":"1970-01-01T00:01:40Z","WorksInProgress":[]}`,
+			err:    "line 1: no works in progress",
+		},
+		{
+			name:   "CSV progress out of range",
+			format: FormatCSV,
+			input:  "timestamp,work,percent\n1970-01-01T00:01:40Z,Dragonsteel,10\n1970-01-01T00:03:20Z,Dragonsteel,101\n",
+			err:    "line 3: work \"Dragonsteel\" has progress 101",
+		},
+		{
+			name:   "CSV work twice in an entry",
+			format: FormatCSV,
+			input:  "timestamp,work,percent\n1970-01-01T00:01:40Z,Dragonsteel,10\n1970-01-01T00:01:40Z,Dragonsteel,20\n",
+			err:    "line 2: work \"Dragonsteel\" appears twice",
+		},
+		{
+			name:   "CSV invalid timestamp",
+			format: FormatCSV,
+			input:  "timestamp,work,percent\nyesterday,Dragonsteel,10\n",
+			err:    "line 2: invalid timestamp",
+		},
+		{
+			name:   "CSV wrong header",
+			format: FormatCSV,
+			input:  "time,title,progress\n",
+			err:    "CSV header",
+		},
+		{
+			name:     "CSV empty",
+			format:   FormatCSV,
+			input:    "",
+			expected: ImportResult{},
+		},
+		{
+			name:   "unknown format",
+			format: "xml",
+			err:    `unknown format "xml"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			result, err := Import(context.Background(), store, strings.NewReader(tc.input), tc.format)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				// Nothing is imported from an invalid file
+				count, err := store.GetEntryCount(context.Background())
+				require.NoError(t, err)
+				require.Zero(t, count)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, result)
+		})
+	}
+}
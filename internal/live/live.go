@@ -0,0 +1,269 @@
+// Package live streams progress updates to clients as new history entries are written, as Server-Sent Events or by
+// long polling.
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+const (
+	// DefaultPollInterval is how often the history is checked for new entries
+	DefaultPollInterval = 5 * time.Second
+	// DefaultHeartbeatInterval keeps idle connections from being closed by proxies
+	DefaultHeartbeatInterval = 15 * time.Second
+	// reconnectDelay is how long browsers wait before reconnecting a closed event stream
+	reconnectDelay = 5 * time.Second
+)
+
+var ErrInvalidEventID = errors.New("invalid event id")
+
+type (
+	// Event is a new history entry and what changed since the entry before it
+	Event struct {
+		// ID is the entry's timestamp in Unix nanoseconds. Clients resume from it with Last-Event-ID or ?since=.
+		ID        string                    `json:"id"`
+		Timestamp time.Time                 `json:"timestamp"`
+		Updates   []progress.ProgressUpdate `json:"updates"`
+	}
+
+	// Watcher waits for new history entries by polling the history. However many clients are waiting, a single poller
+	// checks the history for them all, so share one Watcher between clients.
+	Watcher struct {
+		History      historySource
+		PollInterval time.Duration
+
+		mu sync.Mutex
+		// polled is closed and replaced whenever the poller finds a newer latest entry or fails
+		polled  chan struct{}
+		latest  history.ProgressEntry
+		pollErr error
+		waiters int
+		polling bool
+	}
+
+	historySource interface {
+		GetLatestProgressEntry(ctx context.Context) (history.ProgressEntry, error)
+		GetEarliestProgressEntryAfter(ctx context.Context, timestamp time.Time) (history.ProgressEntry, error)
+	}
+)
+
+func NewWatcher(historySource historySource) *Watcher {
+	return &Watcher{
+		History:      historySource,
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// EventID returns the ID of the event for the entry
+func EventID(entry history.ProgressEntry) string {
+	return strconv.FormatInt(entry.Timestamp.UnixNano(), 10)
+}
+
+// Start returns the entry to watch from: the entry with the given event ID, or the latest entry if eventID is empty.
+// An empty history starts from an empty entry.
+func (watcher *Watcher) Start(ctx context.Context, eventID string) (history.ProgressEntry, error) {
+	if eventID == "" {
+		latest, err := watcher.History.GetLatestProgressEntry(ctx)
+		if errors.Is(err, history.ErrEmptyHistory) {
+			return history.ProgressEntry{}, nil
+		} else if err != nil {
+			return history.ProgressEntry{}, fmt.Errorf("get latest progress entry: %w", err)
+		}
+		return latest, nil
+	}
+
+	unixNano, err := strconv.ParseInt(eventID, 10, 64)
+	if err != nil {
+		return history.ProgressEntry{}, fmt.Errorf("%w: %q", ErrInvalidEventID, eventID)
+	}
+	entry, err := watcher.History.GetEarliestProgressEntryAfter(ctx, time.Unix(0, unixNano-1))
+	if err != nil && !errors.Is(err, history.ErrNoEntryAfterTarget) {
+		return history.ProgressEntry{}, fmt.Errorf("get progress entry: %w", err)
+	}
+	if err != nil || entry.Timestamp.UnixNano() != unixNano {
+		return history.ProgressEntry{}, fmt.Errorf("%w: no entry %q", ErrInvalidEventID, eventID)
+	}
+	return entry, nil
+}
+
+// Next waits for the first entry written after previous, and returns its event along with the entry. It returns
+// ctx's error if ctx is done first.
+func (watcher *Watcher) Next(ctx context.Context, previous history.ProgressEntry) (Event, history.ProgressEntry, error) {
+	for {
+		entry, err := watcher.History.GetEarliestProgressEntryAfter(ctx, previous.Timestamp)
+		if err == nil {
+			return Event{
+				ID:        EventID(entry),
+				Timestamp: entry.Timestamp,
+				Updates:   progress.GetProgressUpdate(entry.WorksInProgress, previous.WorksInProgress),
+			}, entry, nil
+		} else if !errors.Is(err, history.ErrNoEntryAfterTarget) {
+			return Event{}, history.ProgressEntry{}, fmt.Errorf("get progress entry: %w", err)
+		}
+
+		if err := watcher.waitForEntryAfter(ctx, previous.Timestamp); err != nil {
+			return Event{}, history.ProgressEntry{}, err
+		}
+	}
+}
+
+// waitForEntryAfter waits until the poller finds a latest entry written after the timestamp
+func (watcher *Watcher) waitForEntryAfter(ctx context.Context, timestamp time.Time) error {
+	watcher.mu.Lock()
+	if watcher.latest.Timestamp.After(timestamp) {
+		watcher.mu.Unlock()
+		return nil
+	}
+	if watcher.polled == nil {
+		watcher.polled = make(chan struct{})
+	}
+	polled := watcher.polled
+	watcher.waiters++
+	if !watcher.polling {
+		watcher.polling = true
+		go watcher.poll()
+	}
+	watcher.mu.Unlock()
+
+	defer func() {
+		watcher.mu.Lock()
+		watcher.waiters--
+		watcher.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-polled:
+		}
+
+		watcher.mu.Lock()
+		latest, err := watcher.latest, watcher.pollErr
+		polled = watcher.polled
+		watcher.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if latest.Timestamp.After(timestamp) {
+			return nil
+		}
+	}
+}
+
+// poll checks the latest entry every PollInterval for as long as anyone is waiting, and wakes the waiters when it
+// changes
+func (watcher *Watcher) poll() {
+	ticker := time.NewTicker(watcher.PollInterval)
+	defer ticker.Stop()
+	for {
+		latest, err := watcher.History.GetLatestProgressEntry(context.Background())
+		if errors.Is(err, history.ErrEmptyHistory) {
+			err = nil
+		} else if err != nil {
+			err = fmt.Errorf("get latest progress entry: %w", err)
+		}
+
+		watcher.mu.Lock()
+		changed := latest.Timestamp.After(watcher.latest.Timestamp)
+		if changed {
+			watcher.latest = latest
+		}
+		if changed || err != nil || watcher.pollErr != nil {
+			watcher.pollErr = err
+			close(watcher.polled)
+			watcher.polled = make(chan struct{})
+		}
+		if watcher.waiters == 0 {
+			watcher.polling = false
+			watcher.mu.Unlock()
+			return
+		}
+		watcher.mu.Unlock()
+
+		<-ticker.C
+	}
+}
+
+// StreamEvents writes an event to w for every new entry after previous, which usually comes from Start, until ctx
+// is done. Heartbeat comments are written in between. flush, if not nil, is called after each write.
+func (watcher *Watcher) StreamEvents(ctx context.Context, w io.Writer, previous history.ProgressEntry, heartbeatInterval time.Duration, flush func()) error {
+	write := func(s string) error {
+		if _, err := io.WriteString(w, s); err != nil {
+			return err
+		}
+		if flush != nil {
+			flush()
+		}
+		return nil
+	}
+	if err := write(fmt.Sprintf("retry: %d\n\n", reconnectDelay.Milliseconds())); err != nil {
+		return err
+	}
+
+	type next struct {
+		event Event
+		entry history.ProgressEntry
+		err   error
+	}
+	for {
+		// Wait for the next entry in the background so that heartbeats keep flowing
+		nextCh := make(chan next, 1)
+		go func(previous history.ProgressEntry) {
+			event, entry, err := watcher.Next(ctx, previous)
+			nextCh <- next{event, entry, err}
+		}(previous)
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		var result next
+	wait:
+		for {
+			select {
+			case result = <-nextCh:
+				break wait
+			case <-heartbeat.C:
+				if err := write(": heartbeat\n\n"); err != nil {
+					heartbeat.Stop()
+					return err
+				}
+			}
+		}
+		heartbeat.Stop()
+
+		if errors.Is(result.err, context.Canceled) || errors.Is(result.err, context.DeadlineExceeded) {
+			return nil
+		} else if result.err != nil {
+			return result.err
+		}
+
+		data, err := json.Marshal(result.event)
+		if err != nil {
+			return err
+		}
+		if err := write(fmt.Sprintf("id: %s\nevent: progress\ndata: %s\n\n", result.event.ID, data)); err != nil {
+			return err
+		}
+		previous = result.entry
+	}
+}
+
+// Poll waits for the first entry after previous, which usually comes from Start. ok is false if none was written
+// before ctx was done.
+func (watcher *Watcher) Poll(ctx context.Context, previous history.ProgressEntry) (event Event, ok bool, err error) {
+	event, _, err = watcher.Next(ctx, previous)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return Event{}, false, nil
+	} else if err != nil {
+		return Event{}, false, err
+	}
+	return event, true, nil
+}
@@ -0,0 +1,160 @@
+package live
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHistory struct {
+	mu      sync.Mutex
+	entries []history.ProgressEntry // oldest first
+	// queries counts the history queries made
+	queries int
+}
+
+func (f *fakeHistory) add(entry history.ProgressEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = append(f.entries, entry)
+}
+
+func (f *fakeHistory) GetLatestProgressEntry(ctx context.Context) (history.ProgressEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries++
+	if len(f.entries) == 0 {
+		return history.ProgressEntry{}, history.ErrEmptyHistory
+	}
+	return f.entries[len(f.entries)-1], nil
+}
+
+func (f *fakeHistory) GetEarliestProgressEntryAfter(ctx context.Context, timestamp time.Time) (history.ProgressEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries++
+	for _, entry := range f.entries {
+		if entry.Timestamp.After(timestamp) {
+			return entry, nil
+		}
+	}
+	return history.ProgressEntry{}, history.ErrNoEntryAfterTarget
+}
+
+// syncBuffer lets the test read what the stream has written so far
+type syncBuffer struct {
+	mu sync.Mutex
+	sb strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.String()
+}
+
+var (
+	entry1 = history.ProgressEntry{
+		Timestamp:       time.Unix(0, 1000).UTC(),
+		WorksInProgress: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 80}},
+	}
+	entry2 = history.ProgressEntry{
+		Timestamp:       time.Unix(0, 2000).UTC(),
+		WorksInProgress: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 90}},
+	}
+)
+
+func TestStartAndPoll(t *testing.T) {
+	historySource := &fakeHistory{entries: []history.ProgressEntry{entry1}}
+	watcher := &Watcher{History: historySource, PollInterval: time.Millisecond}
+
+	// Nothing new before the timeout
+	latest, err := watcher.Start(context.Background(), "")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, ok, err := watcher.Poll(ctx, latest)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Resuming from an older event returns the entry after it straight away
+	historySource.add(entry2)
+	previous, err := watcher.Start(context.Background(), "1000")
+	require.NoError(t, err)
+	event, ok, err := watcher.Poll(context.Background(), previous)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, Event{
+		ID:        "2000",
+		Timestamp: entry2.Timestamp,
+		Updates:   []progress.ProgressUpdate{{Title: "Moment Zero 2.0", Progress: 90, PrevProgress: 80}},
+	}, event)
+
+	_, err = watcher.Start(context.Background(), "1500")
+	require.ErrorIs(t, err, ErrInvalidEventID)
+}
+
+func TestStreamEvents(t *testing.T) {
+	historySource := &fakeHistory{entries: []history.ProgressEntry{entry1}}
+	watcher := &Watcher{History: historySource, PollInterval: time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var buf syncBuffer
+	done := make(chan error)
+	go func() {
+		done <- watcher.StreamEvents(ctx, &buf, entry1, time.Millisecond, nil)
+	}()
+
+	require.Eventually(t, func() bool { return strings.Contains(buf.String(), ": heartbeat") }, time.Second, time.Millisecond)
+	historySource.add(entry2)
+	require.Eventually(t, func() bool { return strings.Contains(buf.String(), "event: progress") }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	output := buf.String()
+	require.True(t, strings.HasPrefix(output, "retry: 5000\n\n"))
+	require.Contains(t, output, "id: 2000\nevent: progress\n"+
+		`data: {"id":"2000","timestamp":"1970-01-01T00:00:00.000002Z","updates":[{"title":"Moment Zero 2.0","progress":90,"prevProgress":80}]}`+"\n\n")
+}
+
+func TestNext_SharesPoller(t *testing.T) {
+	historySource := &fakeHistory{entries: []history.ProgressEntry{entry1}}
+	watcher := &Watcher{History: historySource, PollInterval: 10 * time.Millisecond}
+
+	const clients = 20
+	ids := make(chan string, clients)
+	for range clients {
+		go func() {
+			event, _, err := watcher.Next(context.Background(), entry1)
+			if err != nil {
+				event.ID = err.Error()
+			}
+			ids <- event.ID
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	historySource.add(entry2)
+	for range clients {
+		require.Equal(t, "2000", <-ids)
+	}
+
+	// Each client queries when it starts and when it is woken, while a single poller checks for them all. A poller per
+	// client would have queried about 200 times.
+	historySource.mu.Lock()
+	defer historySource.mu.Unlock()
+	require.Less(t, historySource.queries, 4*clients+20)
+}
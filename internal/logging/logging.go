@@ -0,0 +1,94 @@
+// Package logging sets up structured JSON logging and carries the logger through contexts, so that every line logged
+// while handling a history entry can be found by its correlation ID.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Attribute keys shared by every logger
+const (
+	CorrelationIDKey = "correlation_id"
+	RequestIDKey     = "request_id"
+)
+
+type (
+	loggerKey        struct{}
+	correlationIDKey struct{}
+)
+
+// Level is the level of the default logger. It can be changed once the config has been loaded.
+var Level = new(slog.LevelVar)
+
+// Init makes a JSON logger writing to stdout at Level the default logger
+func Init() {
+	slog.SetDefault(New(os.Stdout, Level))
+}
+
+// New returns a JSON logger
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// SetLevel sets Level from its name, e.g. "debug"
+func SetLevel(name string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return err
+	}
+	Level.Set(level)
+	return nil
+}
+
+// FromContext returns the context's logger, or the default logger if it has none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithLogger returns a context carrying the logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// With returns a context whose logger has the attributes added
+func With(ctx context.Context, args ...any) context.Context {
+	return WithLogger(ctx, FromContext(ctx).With(args...))
+}
+
+// WithCorrelationID returns a context whose logger tags every line with the correlation ID
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	ctx = context.WithValue(ctx, correlationIDKey{}, correlationID)
+	return With(ctx, CorrelationIDKey, correlationID)
+}
+
+// CorrelationID returns the context's correlation ID, or "" if it has none
+func CorrelationID(ctx context.Context) string {
+	correlationID, _ := ctx.Value(correlationIDKey{}).(string)
+	return correlationID
+}
+
+// NewCorrelationID returns a random correlation ID
+func NewCorrelationID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// WithLambdaRequest returns a context whose logger tags every line with the Lambda invocation's request ID, if ctx is
+// a Lambda invocation's context
+func WithLambdaRequest(ctx context.Context) context.Context {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		return With(ctx, RequestIDKey, lc.AwsRequestID)
+	}
+	return ctx
+}
@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithCorrelationID(t *testing.T) {
+	var out bytes.Buffer
+	ctx := WithLogger(context.Background(), New(&out, slog.LevelInfo))
+	require.Empty(t, CorrelationID(ctx))
+
+	ctx = WithCorrelationID(ctx, "abc123")
+	ctx = With(ctx, "target", "slack")
+	require.Equal(t, "abc123", CorrelationID(ctx))
+
+	FromContext(ctx).Debug("Not logged")
+	FromContext(ctx).Info("Sent")
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &fields))
+	require.Equal(t, "Sent", fields["msg"])
+	require.Equal(t, "abc123", fields[CorrelationIDKey])
+	require.Equal(t, "slack", fields["target"])
+}
+
+func TestSetLevel(t *testing.T) {
+	defer Level.Set(slog.LevelInfo)
+	require.NoError(t, SetLevel("debug"))
+	require.Equal(t, slog.LevelDebug, Level.Level())
+	require.NoError(t, SetLevel("WARN"))
+	require.Equal(t, slog.LevelWarn, Level.Level())
+	require.Error(t, SetLevel("loud"))
+}
@@ -0,0 +1,257 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+type (
+	// Config holds the text/template sources for a push target's notification. Fields left empty fall back to the
+	// target's defaults, and locale variants fall back to the base templates.
+	Config struct {
+		// Title is the notification's headline
+		Title string `json:"title,omitempty"`
+		// Body is the notification's main text
+		Body string `json:"body,omitempty"`
+		// Item is rendered once per progress update, with the Update as data
+		Item string `json:"item,omitempty"`
+		// Locales holds per-locale variants, keyed by locale (e.g. "es" or "pt-BR")
+		Locales map[string]Config `json:"locales,omitempty"`
+	}
+
+	// Templates are the parsed and validated templates for a push target
+	Templates struct {
+		name    string
+		base    parsedTemplates
+		locales map[string]parsedTemplates
+	}
+
+	// Data is what the Title and Body templates are executed against
+	Data struct {
+		Updates []Update
+		// Changed holds only the updates whose progress changed
+		Changed []Update
+		Summary string
+		Locale  string
+	}
+
+	// Update is what the Item template is executed against
+	Update struct {
+		Title        string
+		WorkID       string
+		Progress     int
+		PrevProgress int
+		Delta        int
+		Kind         progress.ChangeKind
+		Text         string // e.g. "Book 1 (10% => 20%)"
+		Bar          string // e.g. "██░░░░░░░░"
+	}
+
+	// Message is the rendered notification text
+	Message struct {
+		Title string
+		Body  string
+		Items []string
+	}
+
+	parsedTemplates struct {
+		title *template.Template
+		body  *template.Template
+		item  *template.Template
+	}
+)
+
+const (
+	progressBarWidth = 10
+)
+
+var sampleUpdates = []progress.ProgressUpdate{
+	{Title: "Sample Work", Progress: 50, PrevProgress: 40},
+	{Title: "Another Sample Work", Progress: 100, PrevProgress: 95},
+}
+
+// LoadConfigFile reads per-target template configuration, keyed by target name, from a JSON file
+func LoadConfigFile(path string) (map[string]Config, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read message config file: %w", err)
+	}
+
+	var configs map[string]Config
+	if err := json.Unmarshal(contents, &configs); err != nil {
+		return nil, fmt.Errorf("unmarshal message config file: %w", err)
+	}
+	return configs, nil
+}
+
+// New parses and validates the templates in cfg, falling back to defaults for any template cfg leaves empty
+func New(name string, cfg Config, defaults Config) (*Templates, error) {
+	base := cfg.withDefaults(defaults)
+	parsedBase, err := base.parse(name)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := &Templates{
+		name:    name,
+		base:    parsedBase,
+		locales: map[string]parsedTemplates{},
+	}
+	for locale, localeCfg := range cfg.Locales {
+		parsedLocale, err := localeCfg.withDefaults(base).parse(name + "." + locale)
+		if err != nil {
+			return nil, fmt.Errorf("locale %q: %w", locale, err)
+		}
+		templates.locales[locale] = parsedLocale
+	}
+
+	// Execute every template against sample data, so that mistakes such as unknown fields are caught on load
+	for _, locale := range append([]string{""}, templates.Locales()...) {
+		if _, err := templates.Render(locale, sampleUpdates); err != nil {
+			return nil, fmt.Errorf("validate templates: %w", err)
+		}
+	}
+
+	return templates, nil
+}
+
+// MustNew is like New but panics on error. It is intended for built-in defaults.
+func MustNew(name string, defaults Config) *Templates {
+	templates, err := New(name, Config{}, defaults)
+	if err != nil {
+		panic(err)
+	}
+	return templates
+}
+
+// Locales returns the locales that have variants
+func (t *Templates) Locales() []string {
+	locales := make([]string, 0, len(t.locales))
+	for locale := range t.locales {
+		locales = append(locales, locale)
+	}
+	return locales
+}
+
+// Render renders the notification for updates. Unknown locales fall back to the base language (e.g. "pt-BR" to
+// "pt"), then to the base templates.
+func (t *Templates) Render(locale string, updates []progress.ProgressUpdate) (Message, error) {
+	tmpl := t.forLocale(locale)
+	data := NewData(locale, updates)
+
+	title, err := execute(tmpl.title, data)
+	if err != nil {
+		return Message{}, fmt.Errorf("render %s title: %w", t.name, err)
+	}
+	body, err := execute(tmpl.body, data)
+	if err != nil {
+		return Message{}, fmt.Errorf("render %s body: %w", t.name, err)
+	}
+	items := make([]string, len(data.Updates))
+	for i, update := range data.Updates {
+		items[i], err = execute(tmpl.item, update)
+		if err != nil {
+			return Message{}, fmt.Errorf("render %s item: %w", t.name, err)
+		}
+	}
+
+	return Message{
+		Title: title,
+		Body:  body,
+		Items: items,
+	}, nil
+}
+
+// NewData builds the template data for updates
+func NewData(locale string, updates []progress.ProgressUpdate) Data {
+	data := Data{
+		Updates: make([]Update, len(updates)),
+		Changed: []Update{},
+		Summary: progress.Summarize(updates),
+		Locale:  locale,
+	}
+	for i, pu := range updates {
+		update := Update{
+			Title:        pu.Title,
+			WorkID:       pu.WorkID(),
+			Progress:     pu.Progress,
+			PrevProgress: pu.PrevProgress,
+			Delta:        pu.Delta(),
+			Kind:         pu.ChangeKind(),
+			Text:         pu.String(),
+			Bar:          ProgressBar(pu.Progress, progressBarWidth),
+		}
+		data.Updates[i] = update
+		if update.Kind != progress.ChangeKindUnchanged {
+			data.Changed = append(data.Changed, update)
+		}
+	}
+	return data
+}
+
+// ProgressBar draws percent as a bar of width Unicode blocks
+func ProgressBar(percent int, width int) string {
+	percent = max(0, min(percent, 100))
+	filled := (percent*width + 50) / 100
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+func (t *Templates) forLocale(locale string) parsedTemplates {
+	if tmpl, ok := t.locales[locale]; ok {
+		return tmpl
+	}
+	if language, _, found := strings.Cut(locale, "-"); found {
+		if tmpl, ok := t.locales[language]; ok {
+			return tmpl
+		}
+	}
+	return t.base
+}
+
+func (cfg Config) withDefaults(defaults Config) Config {
+	merged := cfg
+	if merged.Title == "" {
+		merged.Title = defaults.Title
+	}
+	if merged.Body == "" {
+		merged.Body = defaults.Body
+	}
+	if merged.Item == "" {
+		merged.Item = defaults.Item
+	}
+	merged.Locales = nil
+	return merged
+}
+
+func (cfg Config) parse(name string) (parsedTemplates, error) {
+	var errs []error
+	parse := func(field, text string) *template.Template {
+		tmpl, err := template.New(name + "." + field).Option("missingkey=error").Parse(text)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parse %s template: %w", field, err))
+		}
+		return tmpl
+	}
+
+	parsed := parsedTemplates{
+		title: parse("title", cfg.Title),
+		body:  parse("body", cfg.Body),
+		item:  parse("item", cfg.Item),
+	}
+	return parsed, errors.Join(errs...)
+}
+
+func execute(tmpl *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,80 @@
+package message
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+var defaults = Config{
+	Title: "Progress update",
+	Body:  "{{.Summary}}",
+	Item:  "{{.Text}}",
+}
+
+func TestRender(t *testing.T) {
+	templates, err := New("test", Config{
+		Title: "{{len .Changed}} of {{len .Updates}} works changed",
+		Item:  "{{.Title}}: {{.Progress}}% ({{.Kind}}{{if gt .Delta 0}}, +{{.Delta}}{{end}})",
+		Locales: map[string]Config{
+			"es": {Title: "{{len .Changed}} de {{len .Updates}} obras cambiaron"},
+		},
+	}, defaults)
+	require.NoError(t, err)
+
+	updates := []progress.ProgressUpdate{
+		{Title: "Book 1", Progress: 20, PrevProgress: 10},
+		{Title: "Book 2", Progress: 50, PrevProgress: 50},
+	}
+
+	msg, err := templates.Render("", updates)
+	require.NoError(t, err)
+	require.Equal(t, Message{
+		Title: "1 of 2 works changed",
+		Body:  "Book 1 (10% => 20%)",
+		Items: []string{"Book 1: 20% (increased, +10)", "Book 2: 50% (unchanged)"},
+	}, msg)
+
+	msg, err = templates.Render("es-MX", updates)
+	require.NoError(t, err)
+	require.Equal(t, "1 de 2 obras cambiaron", msg.Title)
+	require.Equal(t, "Book 1 (10% => 20%)", msg.Body, "locale variants fall back to the base templates")
+	require.Equal(t, "Book 1: 20% (increased, +10)", msg.Items[0], "locale variants fall back to the base templates")
+
+	msg, err = templates.Render("fr", updates)
+	require.NoError(t, err)
+	require.Equal(t, "1 of 2 works changed", msg.Title)
+}
+
+func TestNew_Validation(t *testing.T) {
+	_, err := New("test", Config{Title: "{{.Summary"}, defaults)
+	require.ErrorContains(t, err, "parse title template")
+
+	_, err = New("test", Config{Item: "{{.Nope}}"}, defaults)
+	require.ErrorContains(t, err, "validate templates")
+
+	_, err = New("test", Config{Locales: map[string]Config{"es": {Body: "{{.Nope}}"}}}, defaults)
+	require.ErrorContains(t, err, "validate templates")
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"slack": {"title": "Hi", "locales": {"es": {"title": "Hola"}}}}`), 0o600))
+
+	configs, err := LoadConfigFile(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]Config{
+		"slack": {Title: "Hi", Locales: map[string]Config{"es": {Title: "Hola"}}},
+	}, configs)
+}
+
+func TestProgressBar(t *testing.T) {
+	require.Equal(t, "░░░░░░░░░░", ProgressBar(0, 10))
+	require.Equal(t, "█████░░░░░", ProgressBar(45, 10))
+	require.Equal(t, "██████████", ProgressBar(100, 10))
+	require.Equal(t, "██████████", ProgressBar(120, 10))
+	require.Equal(t, "░░░░░", ProgressBar(-5, 5))
+}
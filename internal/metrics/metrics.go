@@ -0,0 +1,146 @@
+// Package metrics records metrics in CloudWatch's embedded metric format (EMF). Each metric is written to stdout as a
+// JSON log line, which CloudWatch Logs turns into a metric when it is written from a Lambda function.
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Namespace is the CloudWatch namespace that every metric is recorded in
+const Namespace = "StormWatch"
+
+// Metric names
+const (
+	ScrapeLatency  = "ScrapeLatency"
+	ScrapeFailures = "ScrapeFailures"
+	EntriesWritten = "EntriesWritten"
+	PushesSent     = "PushesSent"
+	PushesFailed   = "PushesFailed"
+)
+
+// Dimension names
+const (
+	// EnvironmentDimension is the config profile. Every metric has it, so that environments are kept apart.
+	EnvironmentDimension = "Environment"
+	// TargetDimension is the push target name
+	TargetDimension = "Target"
+)
+
+type (
+	// Unit is a CloudWatch metric unit
+	Unit string
+
+	// Recorder writes metrics for one environment. A nil Recorder discards them.
+	Recorder struct {
+		Writer      io.Writer
+		Environment string
+		// Now returns the current time. Defaults to time.Now.
+		Now func() time.Time
+
+		mu sync.Mutex
+	}
+
+	// Dimension narrows a metric down, e.g. to a single push target
+	Dimension struct {
+		Name  string
+		Value string
+	}
+
+	metadata struct {
+		Timestamp         int64             `json:"Timestamp"`
+		CloudWatchMetrics []metricDirective `json:"CloudWatchMetrics"`
+	}
+
+	metricDirective struct {
+		Namespace  string             `json:"Namespace"`
+		Dimensions [][]string         `json:"Dimensions"`
+		Metrics    []metricDefinition `json:"Metrics"`
+	}
+
+	metricDefinition struct {
+		Name string `json:"Name"`
+		Unit Unit   `json:"Unit"`
+	}
+)
+
+const (
+	Count        Unit = "Count"
+	Milliseconds Unit = "Milliseconds"
+)
+
+// New returns a recorder that writes to stdout
+func New(environment string) *Recorder {
+	return &Recorder{
+		Writer:      os.Stdout,
+		Environment: environment,
+	}
+}
+
+// Count records a count, e.g. a failure
+func (r *Recorder) Count(name string, value int, dimensions ...Dimension) {
+	r.put(name, Count, float64(value), dimensions)
+}
+
+// Duration records how long something took
+func (r *Recorder) Duration(name string, d time.Duration, dimensions ...Dimension) {
+	r.put(name, Milliseconds, float64(d)/float64(time.Millisecond), dimensions)
+}
+
+// put writes a single metric. It is recorded both with and without the extra dimensions, so that it can be alarmed on
+// as a whole as well as broken down.
+func (r *Recorder) put(name string, unit Unit, value float64, dimensions []Dimension) {
+	if r == nil {
+		return
+	}
+
+	dimensionSet := []string{EnvironmentDimension}
+	dimensionSets := [][]string{dimensionSet}
+	if len(dimensions) > 0 {
+		extended := append([]string{}, dimensionSet...)
+		for _, dimension := range dimensions {
+			extended = append(extended, dimension.Name)
+		}
+		dimensionSets = append(dimensionSets, extended)
+	}
+
+	fields := map[string]interface{}{
+		"_aws": metadata{
+			Timestamp: r.now().UnixMilli(),
+			CloudWatchMetrics: []metricDirective{{
+				Namespace:  Namespace,
+				Dimensions: dimensionSets,
+				Metrics:    []metricDefinition{{Name: name, Unit: unit}},
+			}},
+		},
+		EnvironmentDimension: r.Environment,
+		name:                 value,
+	}
+	for _, dimension := range dimensions {
+		fields[dimension.Name] = dimension.Value
+	}
+
+	line, err := json.Marshal(fields)
+	if err != nil {
+		slog.Error("Failed to marshal metric", "metric", name, "error", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := fmt.Fprintln(r.Writer, string(line)); err != nil {
+		slog.Error("Failed to write metric", "metric", name, "error", err)
+	}
+}
+
+func (r *Recorder) now() time.Time {
+	if r.Now == nil {
+		return time.Now()
+	}
+	return r.Now()
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   func(r *Recorder)
+		expected string
+	}{
+		{
+			name:     "count",
+			record:   func(r *Recorder) { r.Count(ScrapeFailures, 1) },
+			expected: `{"Environment":"staging","ScrapeFailures":1,"_aws":{"Timestamp":1700000000000,"CloudWatchMetrics":[{"Namespace":"StormWatch","Dimensions":[["Environment"]],"Metrics":[{"Name":"ScrapeFailures","Unit":"Count"}]}]}}`,
+		},
+		{
+			name:     "duration",
+			record:   func(r *Recorder) { r.Duration(ScrapeLatency, 1500*time.Microsecond) },
+			expected: `{"Environment":"staging","ScrapeLatency":1.5,"_aws":{"Timestamp":1700000000000,"CloudWatchMetrics":[{"Namespace":"StormWatch","Dimensions":[["Environment"]],"Metrics":[{"Name":"ScrapeLatency","Unit":"Milliseconds"}]}]}}`,
+		},
+		{
+			name:     "with dimensions",
+			record:   func(r *Recorder) { r.Count(PushesSent, 1, Dimension{Name: TargetDimension, Value: "slack"}) },
+			expected: `{"Environment":"staging","PushesSent":1,"Target":"slack","_aws":{"Timestamp":1700000000000,"CloudWatchMetrics":[{"Namespace":"StormWatch","Dimensions":[["Environment"],["Environment","Target"]],"Metrics":[{"Name":"PushesSent","Unit":"Count"}]}]}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			recorder := &Recorder{
+				Writer:      &out,
+				Environment: "staging",
+				Now:         func() time.Time { return time.UnixMilli(1700000000000) },
+			}
+			test.record(recorder)
+			require.JSONEq(t, test.expected, out.String())
+		})
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var recorder *Recorder
+	require.NotPanics(t, func() {
+		recorder.Count(PushesFailed, 1)
+	})
+}
@@ -1,6 +1,36 @@
 package progress
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ChangeKind describes how a work's progress changed between two history entries
+type ChangeKind string
+
+const (
+	ChangeKindUnchanged ChangeKind = "unchanged"
+	ChangeKindIncreased ChangeKind = "increased"
+	ChangeKindDecreased ChangeKind = "decreased"
+	ChangeKindCompleted ChangeKind = "completed"
+)
+
+type updateTimeKey struct{}
+
+// WithUpdateTime returns a context carrying the time of the history entry the updates were computed from
+func WithUpdateTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, updateTimeKey{}, t)
+}
+
+// UpdateTime returns the time of the entry the updates were computed from, or the current time if the context has none
+func UpdateTime(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(updateTimeKey{}).(time.Time); ok {
+		return t
+	}
+	return time.Now()
+}
 
 // ProgressUpdate represents each work and its progress, and a comparison against the previous progress
 type ProgressUpdate struct {
@@ -17,6 +47,44 @@ func (pu *ProgressUpdate) String() string {
 	return fmt.Sprintf("%s (%s)", pu.Title, progressStr)
 }
 
+// WorkID returns the identifier derived from the work's title
+func (pu *ProgressUpdate) WorkID() string {
+	return WorkID(pu.Title)
+}
+
+// Delta returns the change in percentage points since the previous progress
+func (pu *ProgressUpdate) Delta() int {
+	return pu.Progress - pu.PrevProgress
+}
+
+// ChangeKind classifies how the work's progress changed
+func (pu *ProgressUpdate) ChangeKind() ChangeKind {
+	switch {
+	case pu.Progress == pu.PrevProgress:
+		return ChangeKindUnchanged
+	case pu.Progress >= 100:
+		return ChangeKindCompleted
+	case pu.Progress > pu.PrevProgress:
+		return ChangeKindIncreased
+	default:
+		return ChangeKindDecreased
+	}
+}
+
+// Summarize describes the works whose progress changed, e.g. "Book 1 (10% => 20%), Book 2 (40% => 50%)"
+func Summarize(updates []ProgressUpdate) string {
+	changed := []string{}
+	for _, update := range updates {
+		if update.ChangeKind() != ChangeKindUnchanged {
+			changed = append(changed, update.String())
+		}
+	}
+	if len(changed) == 0 {
+		return "No progress changes"
+	}
+	return strings.Join(changed, ", ")
+}
+
 func GetProgressUpdate(latestProgress, prevProgress []WorkInProgress) []ProgressUpdate {
 	updates := make([]ProgressUpdate, len(latestProgress))
 
@@ -143,3 +143,42 @@ func TestGetProgressUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestProgressUpdate_ChangeKind(t *testing.T) {
+	testCases := []struct {
+		pu       ProgressUpdate
+		expected ChangeKind
+	}{
+		{pu: ProgressUpdate{Title: "A", Progress: 50, PrevProgress: 50}, expected: ChangeKindUnchanged},
+		{pu: ProgressUpdate{Title: "A", Progress: 60, PrevProgress: 50}, expected: ChangeKindIncreased},
+		{pu: ProgressUpdate{Title: "A", Progress: 40, PrevProgress: 50}, expected: ChangeKindDecreased},
+		{pu: ProgressUpdate{Title: "A", Progress: 100, PrevProgress: 90}, expected: ChangeKindCompleted},
+		{pu: ProgressUpdate{Title: "A", Progress: 100, PrevProgress: 100}, expected: ChangeKindUnchanged},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.pu.String(), func(t *testing.T) {
+			actual := tc.pu.ChangeKind()
+			if actual != tc.expected {
+				t.Errorf("Expected: %q, Actual: %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	updates := []ProgressUpdate{
+		{Title: "Task A", Progress: 50, PrevProgress: 50},
+		{Title: "Task B", Progress: 100, PrevProgress: 75},
+		{Title: "Task C", Progress: 20, PrevProgress: 10},
+	}
+	expected := "Task B (75% => 100%), Task C (10% => 20%)"
+	if actual := Summarize(updates); actual != expected {
+		t.Errorf("Expected: %q, Actual: %q", expected, actual)
+	}
+
+	expected = "No progress changes"
+	if actual := Summarize(updates[:1]); actual != expected {
+		t.Errorf("Expected: %q, Actual: %q", expected, actual)
+	}
+}
@@ -0,0 +1,15 @@
+// Shows the progress notifications sent to the status page's push subscribers
+self.addEventListener("push", (event) => {
+    const message = event.data ? event.data.json() : {};
+    event.waitUntil(self.registration.showNotification(message.title || "Stormwatch", {
+        body: message.body,
+        tag: message.tag,
+        renotify: true,
+        data: { url: message.url || self.registration.scope },
+    }));
+});
+
+self.addEventListener("notificationclick", (event) => {
+    event.notification.close();
+    event.waitUntil(clients.openWindow(event.notification.data.url));
+});
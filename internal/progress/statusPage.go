@@ -10,7 +10,20 @@ import (
 //go:embed status-page.html.tmpl
 var statusPageContent string
 
-func CreateStatusPage(wips []WorkInProgress) ([]byte, error) {
+// ServiceWorkerContent is the service worker that shows web push notifications. It must be served from the same
+// directory as the status page.
+//
+//go:embed service-worker.js
+var ServiceWorkerContent []byte
+
+type statusPage struct {
+	WorksInProgress []WorkInProgress
+	// VAPIDPublicKey lets visitors subscribe to notifications. The button is hidden without it.
+	VAPIDPublicKey string
+}
+
+// CreateStatusPage renders the status page. vapidPublicKey may be empty if web push is not configured.
+func CreateStatusPage(wips []WorkInProgress, vapidPublicKey string) ([]byte, error) {
 	t := template.New("statusPage")
 
 	t, err := t.Parse(statusPageContent)
@@ -19,7 +32,7 @@ func CreateStatusPage(wips []WorkInProgress) ([]byte, error) {
 	}
 
 	var tpl bytes.Buffer
-	if err := t.Execute(&tpl, wips); err != nil {
+	if err := t.Execute(&tpl, statusPage{WorksInProgress: wips, VAPIDPublicKey: vapidPublicKey}); err != nil {
 		return nil, err
 	}
 
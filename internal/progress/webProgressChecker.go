@@ -1,14 +1,19 @@
 package progress
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/Rhionin/SanderServer/internal/tracing"
+
 	"github.com/PuerkitoBio/goquery"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // WebProgressChecker checks progress from an HTML website
@@ -17,8 +22,11 @@ type WebProgressChecker struct {
 }
 
 // GetProgress gets latest works in progress from brandonsanderson.com
-func (wpc WebProgressChecker) GetProgress() ([]WorkInProgress, error) {
-	req, err := http.NewRequest(http.MethodGet, wpc.URL, nil)
+func (wpc WebProgressChecker) GetProgress(ctx context.Context) (wips []WorkInProgress, err error) {
+	ctx, span := tracing.Start(ctx, "WebProgressChecker.GetProgress", attribute.String("url.full", wpc.URL))
+	defer tracing.End(span, &err)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wpc.URL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("new request: %w", err)
 	}
@@ -32,15 +40,41 @@ func (wpc WebProgressChecker) GetProgress() ([]WorkInProgress, error) {
 		return nil, fmt.Errorf("read response body: %w", err)
 	}
 
-	return parseProgressFromHTML(string(responseBody))
+	wips, err = ParseProgressFromHTML(string(responseBody))
+	span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode), tracing.WorksCountKey.Int(len(wips)))
+	return wips, err
 }
 
-func parseProgressFromHTML(html string) ([]WorkInProgress, error) {
+// ParseProgressFromHTML reads the works in progress from a page of brandonsanderson.com, live or archived. It reads
+// both the current Shopify layout and the progress bars of the old WordPress site.
+func ParseProgressFromHTML(html string) ([]WorkInProgress, error) {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
 	if err != nil {
 		return nil, fmt.Errorf("get document from HTML: %w", err)
 	}
 
+	wips, err := parseShopifyProgress(doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(wips) == 0 {
+		wips, err = parseWordPressProgress(doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(wips) == 0 {
+		slog.Debug("No progress entries found in page", "html", html)
+		return nil, errors.New("no progress entries found")
+	}
+
+	return wips, nil
+}
+
+// parseShopifyProgress reads the progress circles of the Shopify theme, each a percentage paragraph followed by a
+// title paragraph
+func parseShopifyProgress(doc *goquery.Document) ([]WorkInProgress, error) {
 	progressEntrySelectors := doc.Find(".progress-item-uniq p")
 
 	textEntries := progressEntrySelectors.Map(func(i int, s *goquery.Selection) string {
@@ -69,11 +103,35 @@ func parseProgressFromHTML(html string) ([]WorkInProgress, error) {
 
 		wips = append(wips, WorkInProgress{Title: title, Progress: progress})
 	}
+	return wips, nil
+}
 
-	if len(wips) == 0 {
-		fmt.Println("No progress entries found from HTML:\n", html)
-		return nil, errors.New("no progress entries found")
-	}
+// parseWordPressProgress reads the Visual Composer progress bars of the WordPress site. Each bar's label holds the
+// title followed by a units span with the percentage, and the bar itself holds the percentage as an attribute.
+func parseWordPressProgress(doc *goquery.Document) ([]WorkInProgress, error) {
+	wips := []WorkInProgress{}
+	var err error
+	doc.Find(".vc_progress_bar .vc_single_bar").EachWithBreak(func(i int, bar *goquery.Selection) bool {
+		progressStr, _ := bar.Find(".vc_bar").Attr("data-percentage-value")
+		progress, parseErr := strconv.Atoi(strings.TrimSpace(progressStr))
+		if parseErr != nil {
+			err = fmt.Errorf("failed to parse progress from progress bar[%d] %q: %w", i, progressStr, parseErr)
+			return false
+		}
 
+		label := bar.Find(".vc_label").Clone()
+		label.Find(".vc_label_units").Remove()
+		title := strings.TrimSpace(label.Text())
+		if title == "" {
+			err = fmt.Errorf("failed to parse title from progress bar[%d]", i)
+			return false
+		}
+
+		wips = append(wips, WorkInProgress{Title: title, Progress: progress})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
 	return wips, nil
 }
@@ -1,10 +1,31 @@
 package progress
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
+// capturesDir holds real captures of the homepage, once any are recorded with cmd/captureProgressPage, and syntheticDir
+// hand-written pages shaped like each layout
+const (
+	capturesDir  = "testdata/captures"
+	syntheticDir = "testdata/synthetic"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files that no longer match, of the pages picked with -run")
+
+// parseResult is what ParseProgressFromHTML returned for a capture, as kept in its golden file
+type parseResult struct {
+	WorksInProgress []WorkInProgress `json:"worksInProgress,omitempty"`
+	Error           string           `json:"error,omitempty"`
+}
+
 const htmlScrape = `<div
   class="section-template--23098442055954__ss_progress_circles_87N98z progress-template--23098442055954__ss_progress_circles_87N98z"
   style="background-color:#ffffff; background-image: ;">
@@ -47,7 +68,7 @@ const htmlScrape = `<div
 </div>`
 
 func TestParseProgressFromHTML(t *testing.T) {
-	wips, err := parseProgressFromHTML(htmlScrape)
+	wips, err := ParseProgressFromHTML(htmlScrape)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -62,3 +83,64 @@ func TestParseProgressFromHTML(t *testing.T) {
 		t.Fatalf("mismatch\nexpected\t%v\ngot\t\t\t%v", expectedWips, wips)
 	}
 }
+
+// TestParseProgressFromHTML_Captures checks the parser against every capture of the homepage and every synthetic page,
+// so that a change for one layout cannot silently break another. Run with -update and a -run filter naming the pages,
+// e.g. -run TestParseProgressFromHTML_Captures/2019-, to write their golden files after adding or changing them.
+func TestParseProgressFromHTML_Captures(t *testing.T) {
+	if *updateGolden && !strings.Contains(flag.Lookup("test.run").Value.String(), "/") {
+		t.Fatal("-update needs -run TestParseProgressFromHTML_Captures/<page>, so that only the pages meant to change are rewritten")
+	}
+
+	var captures []string
+	for _, dir := range []string{capturesDir, syntheticDir} {
+		pages, err := filepath.Glob(filepath.Join(dir, "*.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		captures = append(captures, pages...)
+	}
+	if len(captures) == 0 {
+		t.Fatalf("no pages in %s or %s", capturesDir, syntheticDir)
+	}
+
+	for _, capture := range captures {
+		name := strings.TrimSuffix(filepath.Base(capture), ".html")
+		t.Run(name, func(t *testing.T) {
+			html, err := os.ReadFile(capture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := parseResult{}
+			result.WorksInProgress, err = ParseProgressFromHTML(string(html))
+			if err != nil {
+				result.Error = err.Error()
+			}
+			golden := &bytes.Buffer{}
+			encoder := json.NewEncoder(golden)
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(result); err != nil {
+				t.Fatal(err)
+			}
+			actual := golden.Bytes()
+
+			goldenPath := strings.TrimSuffix(capture, ".html") + ".golden.json"
+			expected, err := os.ReadFile(goldenPath)
+			if err == nil && bytes.Equal(actual, expected) {
+				return
+			}
+			if *updateGolden {
+				if err := os.WriteFile(goldenPath, actual, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				t.Logf("updated %s", goldenPath)
+				return
+			}
+			if err != nil {
+				t.Fatalf("read golden file, run with -update to write it: %s", err)
+			}
+			t.Fatalf("parse result does not match %s, run with -update if the change is intended\nexpected\t%s\ngot\t\t\t%s", goldenPath, expected, actual)
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package progress
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
 
 // WorkInProgress represents each work and its progress
 type WorkInProgress struct {
@@ -11,3 +15,26 @@ type WorkInProgress struct {
 func (wip *WorkInProgress) String() string {
 	return fmt.Sprintf("%s (%d%%)", wip.Title, wip.Progress)
 }
+
+// ID returns the identifier derived from the work's title
+func (wip *WorkInProgress) ID() string {
+	return WorkID(wip.Title)
+}
+
+// WorkID derives a stable, URL-safe identifier from a work title, e.g. "White Sand (Prose Version)" => "white-sand-prose-version"
+func WorkID(title string) string {
+	var b strings.Builder
+	pendingSeparator := false
+	for _, r := range strings.ToLower(title) {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			pendingSeparator = b.Len() > 0
+			continue
+		}
+		if pendingSeparator {
+			b.WriteRune('-')
+			pendingSeparator = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,26 @@
+package progress
+
+import "testing"
+
+func TestWorkID(t *testing.T) {
+	testCases := []struct {
+		title    string
+		expected string
+	}{
+		{title: "Moment Zero 2.0", expected: "moment-zero-2-0"},
+		{title: "White Sand (Prose Version)", expected: "white-sand-prose-version"},
+		{title: "Words of Radiance $650 Signed Edition tier", expected: "words-of-radiance-650-signed-edition-tier"},
+		{title: "  Blightfall 3.0 (Skyward Legacy)\n", expected: "blightfall-3-0-skyward-legacy"},
+		{title: "Café Élan", expected: "caf-lan"},
+		{title: "", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			actual := WorkID(tc.title)
+			if actual != tc.expected {
+				t.Errorf("Expected: %q, Actual: %q", tc.expected, actual)
+			}
+		})
+	}
+}
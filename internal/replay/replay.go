@@ -0,0 +1,222 @@
+// Package replay runs the scrape to push pipeline locally. Each scraped snapshot goes through the progress check,
+// entries it writes become the INSERT stream records DynamoDB would emit, and the records are pushed by
+// PushUpdateHandler to targets that record what they receive. It needs neither a deployed stream nor credentials.
+package replay
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/backfill"
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type (
+	// Harness replays snapshots against a history
+	Harness struct {
+		// History is written to as the snapshots are checked
+		History history.Store
+		// Targets receive the pushes
+		Targets []*RecordingTarget
+		// CoalesceWindow is the push handler's coalescing window. Zero disables coalescing.
+		CoalesceWindow time.Duration
+
+		sequenceNumber int
+	}
+
+	// Snapshot is the progress a scrape found
+	Snapshot struct {
+		Timestamp       time.Time
+		WorksInProgress []progress.WorkInProgress
+	}
+
+	// Step is what replaying a snapshot did
+	Step struct {
+		Snapshot Snapshot
+		// Record is the stream record for the entry the snapshot wrote. It is nil if the progress had not changed.
+		Record *events.DynamoDBEventRecord
+		// Response is the push handler's response
+		Response events.DynamoDBEventResponse
+		// Deliveries are the pushes sent while replaying the snapshot
+		Deliveries []Delivery
+	}
+
+	// RecordingTarget is a push target that records every update it receives instead of sending it
+	RecordingTarget struct {
+		Name string
+		// Rules, if set, decide which updates the target receives
+		Rules *storminglambdas.NotificationRules
+		// Err, if set, fails every push
+		Err error
+		// Received are the pushes received, oldest first
+		Received []Delivery
+
+		now time.Time
+	}
+
+	// Delivery is a push received by a RecordingTarget
+	Delivery struct {
+		Target string
+		// Timestamp is the replayed time of the push
+		Timestamp time.Time
+		Updates   []progress.ProgressUpdate
+	}
+
+	snapshotGetter []progress.WorkInProgress
+)
+
+// NewRecordingTargets returns a recording target for each name
+func NewRecordingTargets(names ...string) []*RecordingTarget {
+	targets := make([]*RecordingTarget, len(names))
+	for i, name := range names {
+		targets[i] = &RecordingTarget{Name: name}
+	}
+	return targets
+}
+
+// Replay checks each snapshot in order at its own timestamp, and pushes the stream record for each entry written, one
+// record per invocation as the stream would deliver them. Records that fail to push are reported in the step's
+// response rather than as an error, as the stream would retry them.
+func (h *Harness) Replay(ctx context.Context, snapshots []Snapshot) ([]Step, error) {
+	steps := make([]Step, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		step, err := h.replaySnapshot(ctx, snapshot)
+		if err != nil {
+			return steps, fmt.Errorf("replay snapshot %s: %w", snapshot.Timestamp.Format(time.RFC3339), err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// Flush invokes the push handler with no records at the given time, as the schedule that closes coalescing windows
+// does
+func (h *Harness) Flush(ctx context.Context, at time.Time) (Step, error) {
+	step := Step{Snapshot: Snapshot{Timestamp: at}}
+	return h.push(ctx, step, events.DynamoDBEvent{})
+}
+
+func (h *Harness) replaySnapshot(ctx context.Context, snapshot Snapshot) (Step, error) {
+	now := func() time.Time { return snapshot.Timestamp }
+	checker := &storminglambdas.ProgressChecker{
+		Checker: snapshotGetter(snapshot.WorksInProgress),
+		History: h.History,
+		Now:     now,
+	}
+	result, err := checker.Check(ctx)
+	if err != nil {
+		return Step{}, fmt.Errorf("check progress: %w", err)
+	}
+
+	step := Step{Snapshot: snapshot}
+	if !result.Changed {
+		if h.CoalesceWindow == 0 {
+			return step, nil
+		}
+		// The schedule keeps flushing while nothing is written
+		return h.push(ctx, step, events.DynamoDBEvent{})
+	}
+
+	h.sequenceNumber++
+	record, err := storminglambdas.NewInsertRecord(result.Latest, strconv.Itoa(h.sequenceNumber))
+	if err != nil {
+		return Step{}, fmt.Errorf("new insert record: %w", err)
+	}
+	step.Record = &record
+	return h.push(ctx, step, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{record}})
+}
+
+// push invokes the push handler with the event at the step's time
+func (h *Harness) push(ctx context.Context, step Step, event events.DynamoDBEvent) (Step, error) {
+	at := step.Snapshot.Timestamp
+	targets, err := h.pushTargets(at)
+	if err != nil {
+		return Step{}, err
+	}
+	handler := &storminglambdas.PushUpdateHandler{
+		History:        h.History,
+		PushTargets:    targets,
+		CoalesceWindow: h.CoalesceWindow,
+		Now:            func() time.Time { return at },
+	}
+
+	received := make([]int, len(h.Targets))
+	for i, target := range h.Targets {
+		received[i] = len(target.Received)
+	}
+	response, err := handler.PushUpdates(ctx, event)
+	if err != nil {
+		return Step{}, fmt.Errorf("push updates: %w", err)
+	}
+	step.Response = response
+	for i, target := range h.Targets {
+		step.Deliveries = append(step.Deliveries, target.Received[received[i]:]...)
+	}
+	return step, nil
+}
+
+func (h *Harness) pushTargets(at time.Time) ([]storminglambdas.PushTarget, error) {
+	targets := make([]storminglambdas.PushTarget, len(h.Targets))
+	for i, target := range h.Targets {
+		target.now = at
+		if target.Rules == nil {
+			targets[i] = target
+			continue
+		}
+		ruled, err := storminglambdas.NewRuledPushTarget(target, *target.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("(%s) %w", target.Name, err)
+		}
+		targets[i] = ruled
+	}
+	return targets, nil
+}
+
+func (t *RecordingTarget) GetName() string {
+	return t.Name
+}
+
+func (t *RecordingTarget) SendUpdate(ctx context.Context, updates []progress.ProgressUpdate) error {
+	if t.Err != nil {
+		return t.Err
+	}
+	t.Received = append(t.Received, Delivery{Target: t.Name, Timestamp: t.now, Updates: updates})
+	return nil
+}
+
+func (s snapshotGetter) GetProgress(ctx context.Context) ([]progress.WorkInProgress, error) {
+	return s, nil
+}
+
+// ReadSnapshots fetches and parses every snapshot from the source, oldest first. Snapshots whose progress cannot be
+// read are skipped, as a scrape of them would have failed.
+func ReadSnapshots(ctx context.Context, source backfill.Source) ([]Snapshot, error) {
+	archived, err := source.Snapshots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	slices.SortFunc(archived, func(a, b backfill.Snapshot) int { return a.Timestamp.Compare(b.Timestamp) })
+
+	snapshots := []Snapshot{}
+	for _, s := range archived {
+		html, err := source.Fetch(ctx, s)
+		if err != nil {
+			return nil, fmt.Errorf("fetch snapshot %s: %w", s.Location, err)
+		}
+		wips, err := progress.ParseProgressFromHTML(html)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Skipping unparseable snapshot", "location", s.Location, "error", err)
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Timestamp: s.Timestamp, WorksInProgress: wips})
+	}
+	return snapshots, nil
+}
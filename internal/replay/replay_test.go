@@ -0,0 +1,121 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/backfill"
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func snapshot(minutes int, stormlight, mistborn int) Snapshot {
+	return Snapshot{
+		Timestamp: start.Add(time.Duration(minutes) * time.Minute),
+		WorksInProgress: []progress.WorkInProgress{
+			{Title: "Stormlight 5", Progress: stormlight},
+			{Title: "Mistborn Era 3", Progress: mistborn},
+		},
+	}
+}
+
+func TestHarness_Replay(t *testing.T) {
+	snapshots := []Snapshot{
+		snapshot(0, 10, 0),
+		snapshot(10, 10, 0),
+		snapshot(20, 12, 0),
+		snapshot(30, 12, 5),
+	}
+
+	tests := []struct {
+		name           string
+		coalesceWindow time.Duration
+		rules          *storminglambdas.NotificationRules
+		expected       [][]progress.ProgressUpdate
+	}{
+		{
+			name: "every change",
+			expected: [][]progress.ProgressUpdate{
+				{{Title: "Stormlight 5", Progress: 12, PrevProgress: 10}, {Title: "Mistborn Era 3", Progress: 0, PrevProgress: 0}},
+				{{Title: "Stormlight 5", Progress: 12, PrevProgress: 12}, {Title: "Mistborn Era 3", Progress: 5, PrevProgress: 0}},
+			},
+		},
+		{
+			name:  "notification rules",
+			rules: &storminglambdas.NotificationRules{TitlePatterns: []string{"Mistborn"}, MinPercentDelta: 1},
+			expected: [][]progress.ProgressUpdate{
+				{{Title: "Mistborn Era 3", Progress: 5, PrevProgress: 0}},
+			},
+		},
+		{
+			name:           "coalesced",
+			coalesceWindow: time.Hour,
+			expected: [][]progress.ProgressUpdate{
+				{{Title: "Stormlight 5", Progress: 12, PrevProgress: 10}, {Title: "Mistborn Era 3", Progress: 5, PrevProgress: 0}},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			target := &RecordingTarget{Name: "demo", Rules: tc.rules}
+			harness := &Harness{History: history.NewMemoryStore(), Targets: []*RecordingTarget{target}, CoalesceWindow: tc.coalesceWindow}
+
+			steps, err := harness.Replay(ctx, snapshots)
+			require.NoError(t, err)
+			require.Len(t, steps, len(snapshots))
+			require.NotNil(t, steps[0].Record)
+			require.Nil(t, steps[1].Record, "unchanged progress writes no entry")
+
+			flushed, err := harness.Flush(ctx, start.Add(2*time.Hour))
+			require.NoError(t, err)
+
+			received := [][]progress.ProgressUpdate{}
+			for _, delivery := range target.Received {
+				received = append(received, delivery.Updates)
+			}
+			require.Equal(t, tc.expected, received)
+			if tc.coalesceWindow > 0 {
+				require.Len(t, flushed.Deliveries, 1)
+				require.Equal(t, start.Add(2*time.Hour), flushed.Deliveries[0].Timestamp)
+			} else {
+				require.Empty(t, flushed.Deliveries)
+			}
+
+			count, err := harness.History.GetEntryCount(ctx)
+			require.NoError(t, err)
+			require.EqualValues(t, 3, count)
+		})
+	}
+}
+
+func TestHarness_ReplayFailure(t *testing.T) {
+	target := &RecordingTarget{Name: "broken", Err: errors.New("unavailable")}
+	harness := &Harness{History: history.NewMemoryStore(), Targets: []*RecordingTarget{target}}
+
+	steps, err := harness.Replay(context.Background(), []Snapshot{snapshot(0, 10, 0), snapshot(10, 20, 0)})
+	require.NoError(t, err)
+	require.Equal(t, []events.DynamoDBBatchItemFailure{{ItemIdentifier: "2"}}, steps[1].Response.BatchItemFailures)
+	require.Empty(t, target.Received)
+}
+
+func TestReadSnapshots(t *testing.T) {
+	snapshots, err := ReadSnapshots(context.Background(), backfill.DirectorySource{Dir: "../backfill/testdata/snapshots"})
+	require.NoError(t, err)
+
+	// The oldest snapshot predates the layout the parser reads
+	require.Len(t, snapshots, 4)
+	require.Equal(t, time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC), snapshots[0].Timestamp)
+	require.Equal(t, []progress.WorkInProgress{
+		{Title: "Stormlight 4", Progress: 30},
+		{Title: "Mistborn Era 2, Book 4", Progress: 10},
+	}, snapshots[0].WorksInProgress)
+}
@@ -0,0 +1,285 @@
+// Package server runs the whole service in one long-running process: progress checks on a ticker, notifications
+// pushed in-process, and the status page and API over HTTP.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/live"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+	"github.com/Rhionin/SanderServer/internal/tracing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	// DefaultCheckInterval matches the EventBridge schedule of the progress check Lambda
+	DefaultCheckInterval = 5 * time.Minute
+
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 500
+	longPollTimeout     = 25 * time.Second
+	maxRequestBodyBytes = 16 << 10
+)
+
+type (
+	Server struct {
+		Checker *storminglambdas.ProgressChecker
+		Pusher  *storminglambdas.PushUpdateHandler
+		History history.Store
+		Watcher *live.Watcher
+		// CheckInterval is how often progress is checked
+		CheckInterval     time.Duration
+		HeartbeatInterval time.Duration
+		// WebPush, if set, lets status page visitors subscribe to web push notifications
+		WebPush *storminglambdas.WebPushHandler
+	}
+)
+
+// New creates a server that records progress in historyStore and notifies pushTargets when it changes
+func New(checker *storminglambdas.ProgressChecker, historyStore history.Store, pushTargets []storminglambdas.PushTarget, coalesceWindow time.Duration) *Server {
+	return &Server{
+		Checker: checker,
+		Pusher: &storminglambdas.PushUpdateHandler{
+			History:        historyStore,
+			PushTargets:    pushTargets,
+			CoalesceWindow: coalesceWindow,
+		},
+		History:           historyStore,
+		Watcher:           live.NewWatcher(historyStore),
+		CheckInterval:     DefaultCheckInterval,
+		HeartbeatInterval: live.DefaultHeartbeatInterval,
+	}
+}
+
+// Run checks progress straight away and then on every tick until ctx is done. Failed checks are logged and retried
+// on the next tick.
+func (server *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(server.CheckInterval)
+	defer ticker.Stop()
+	for {
+		checkCtx := logging.WithCorrelationID(ctx, logging.NewCorrelationID())
+		if err := server.CheckAndPush(checkCtx); err != nil {
+			logging.FromContext(checkCtx).Error("Progress check failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAndPush checks progress once and pushes notifications if it changed, doing in-process what the progress check
+// and push Lambdas do through the DynamoDB stream. The check is given a correlation ID if ctx has none.
+func (server *Server) CheckAndPush(ctx context.Context) (err error) {
+	if logging.CorrelationID(ctx) == "" {
+		ctx = logging.WithCorrelationID(ctx, logging.NewCorrelationID())
+	}
+	ctx, span := tracing.Start(ctx, "Server.CheckAndPush", tracing.CorrelationIDKey.String(logging.CorrelationID(ctx)))
+	defer tracing.End(span, &err)
+	result, err := server.Checker.Check(ctx)
+	if err != nil {
+		return fmt.Errorf("check progress: %w", err)
+	}
+
+	if result.Changed && !result.Previous.Timestamp.IsZero() {
+		if err := server.Pusher.PushEntry(ctx, result.Latest, result.Previous); err != nil {
+			return fmt.Errorf("push entry: %w", err)
+		}
+	}
+	if server.Pusher.CoalesceWindow > 0 {
+		if err := server.Pusher.FlushCoalescedUpdates(ctx); err != nil {
+			return fmt.Errorf("flush coalesced updates: %w", err)
+		}
+	}
+	return nil
+}
+
+// Handler serves the status page, the JSON API and live progress
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", server.handleStatusPage)
+	mux.HandleFunc("GET /service-worker.js", handleServiceWorker)
+	mux.HandleFunc("GET /api/progress", server.handleProgress)
+	mux.HandleFunc("GET /api/history", server.handleHistory)
+	mux.HandleFunc("GET /events", server.handleEvents)
+	mux.HandleFunc("GET /poll", server.handlePoll)
+	mux.HandleFunc(storminglambdas.WebPushSubscriptionsPath, server.handleWebPushSubscription)
+	return withRequestLogger(mux)
+}
+
+// withRequestLogger tags every line logged while handling a request with a request ID
+func withRequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := logging.With(r.Context(), logging.RequestIDKey, logging.NewCorrelationID(), "method", r.Method, "path", r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (server *Server) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	latest, err := server.History.GetLatestProgressEntry(r.Context())
+	if err != nil && !errors.Is(err, history.ErrEmptyHistory) {
+		logging.FromContext(r.Context()).Error("Failed to get latest progress entry", "error", err)
+		http.Error(w, "failed to get progress", http.StatusInternalServerError)
+		return
+	}
+
+	vapidPublicKey := ""
+	if server.WebPush != nil {
+		vapidPublicKey = server.WebPush.PublicKey
+	}
+	page := progress.ErrorPageContent
+	if len(latest.WorksInProgress) > 0 {
+		page, err = progress.CreateStatusPage(latest.WorksInProgress, vapidPublicKey)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to create status page", "error", err)
+			http.Error(w, "failed to create status page", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(page)
+}
+
+func handleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript")
+	w.Write(progress.ServiceWorkerContent)
+}
+
+// handleWebPushSubscription hands the request to the same handler the status page Lambda uses
+func (server *Server) handleWebPushSubscription(w http.ResponseWriter, r *http.Request) {
+	if server.WebPush == nil {
+		http.Error(w, "web push is not enabled", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	if err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	req := events.LambdaFunctionURLRequest{RawPath: r.URL.Path, Body: string(body)}
+	req.RequestContext.HTTP.Method = r.Method
+	response := server.WebPush.HandleSubscriptionRequest(r.Context(), req)
+	for key, value := range response.Headers {
+		w.Header().Set(key, value)
+	}
+	w.WriteHeader(response.StatusCode)
+	io.WriteString(w, response.Body)
+}
+
+func (server *Server) handleProgress(w http.ResponseWriter, r *http.Request) {
+	latest, err := server.History.GetLatestProgressEntry(r.Context())
+	if errors.Is(err, history.ErrEmptyHistory) {
+		http.Error(w, "no progress yet", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to get latest progress entry", "error", err)
+		http.Error(w, "failed to get progress", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, latest)
+}
+
+// handleHistory returns the latest entries, newest first. ?limit= sets how many.
+func (server *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	limit := defaultHistoryLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := server.History.GetProgressEntries(r.Context(), int32(limit))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to get progress entries", "error", err)
+		http.Error(w, "failed to get history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+func (server *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	previous, ok := server.startWatching(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	err := server.Watcher.StreamEvents(r.Context(), w, previous, server.HeartbeatInterval, func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Event stream failed", "error", err)
+	}
+}
+
+func (server *Server) handlePoll(w http.ResponseWriter, r *http.Request) {
+	previous, ok := server.startWatching(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), longPollTimeout)
+	defer cancel()
+	event, ok, err := server.Watcher.Poll(ctx, previous)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Long poll failed", "error", err)
+		http.Error(w, "failed to get progress", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, event)
+}
+
+// startWatching returns the entry to watch from, resuming from Last-Event-ID or ?since= like the live progress Lambda.
+// It writes an error response and returns false if there is none.
+func (server *Server) startWatching(w http.ResponseWriter, r *http.Request) (history.ProgressEntry, bool) {
+	eventID := r.Header.Get("Last-Event-ID")
+	if eventID == "" {
+		eventID = r.URL.Query().Get("since")
+	}
+
+	previous, err := server.Watcher.Start(r.Context(), eventID)
+	if errors.Is(err, live.ErrInvalidEventID) {
+		http.Error(w, "invalid event id", http.StatusBadRequest)
+		return history.ProgressEntry{}, false
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to start watching progress", "error", err)
+		http.Error(w, "failed to get progress", http.StatusInternalServerError)
+		return history.ProgressEntry{}, false
+	}
+	return previous, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	fakeProgressGetter struct {
+		wips []progress.WorkInProgress
+	}
+
+	fakePushTarget struct {
+		received [][]progress.ProgressUpdate
+	}
+)
+
+func (f *fakeProgressGetter) GetProgress(ctx context.Context) ([]progress.WorkInProgress, error) {
+	return f.wips, nil
+}
+
+func (f *fakePushTarget) GetName() string {
+	return "fake"
+}
+
+func (f *fakePushTarget) SendUpdate(ctx context.Context, updates []progress.ProgressUpdate) error {
+	f.received = append(f.received, updates)
+	return nil
+}
+
+func TestCheckAndPush(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 1000).UTC()
+	getter := &fakeProgressGetter{wips: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 80}}}
+	target := &fakePushTarget{}
+	checker := &storminglambdas.ProgressChecker{Checker: getter, Now: func() time.Time { return now }}
+	server := New(checker, history.NewMemoryStore(), []storminglambdas.PushTarget{target}, 0)
+	checker.History = server.History
+
+	// The first entry and unchanged progress push nothing
+	require.NoError(t, server.CheckAndPush(ctx))
+	now = time.Unix(0, 2000).UTC()
+	require.NoError(t, server.CheckAndPush(ctx))
+	require.Empty(t, target.received)
+
+	getter.wips = []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 90}}
+	now = time.Unix(0, 3000).UTC()
+	require.NoError(t, server.CheckAndPush(ctx))
+	require.Equal(t, [][]progress.ProgressUpdate{{{Title: "Moment Zero 2.0", Progress: 90, PrevProgress: 80}}}, target.received)
+
+	testCases := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "progress",
+			path:           "/api/progress",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"Timestamp":"1970-01-01T00:00:00.000003Z","WorksInProgress":[{"title":"Moment Zero 2.0","progress":90}]}`,
+		},
+		{
+			name:           "history",
+			path:           "/api/history?limit=1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"Timestamp":"1970-01-01T00:00:00.000003Z","WorksInProgress":[{"title":"Moment Zero 2.0","progress":90}]}]`,
+		},
+		{
+			name:           "invalid history limit",
+			path:           "/api/history?limit=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "limit must be between 1 and 500\n",
+		},
+		{
+			name:           "poll since",
+			path:           "/poll?since=1000",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":"3000","timestamp":"1970-01-01T00:00:00.000003Z","updates":[{"title":"Moment Zero 2.0","progress":90,"prevProgress":80}]}`,
+		},
+		{
+			name:           "poll unknown event",
+			path:           "/poll?since=1500",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid event id\n",
+		},
+		{
+			name:           "unknown path",
+			path:           "/nope",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "404 page not found\n",
+		},
+	}
+
+	handler := server.Handler()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			require.Equal(t, tc.expectedStatus, recorder.Code)
+			require.Equal(t, tc.expectedBody, recorder.Body.String())
+		})
+	}
+}
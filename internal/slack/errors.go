@@ -0,0 +1,67 @@
+package slack
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors documented for incoming webhooks (https://api.slack.com/messaging/webhooks#handling_errors) and
+// chat.postMessage (https://api.slack.com/methods/chat.postMessage#errors). Use errors.Is to match an *APIError
+// against them.
+var (
+	ErrInvalidPayload                = errors.New("invalid_payload")
+	ErrInvalidToken                  = errors.New("invalid_token")
+	ErrInvalidAuth                   = errors.New("invalid_auth")
+	ErrNotAuthed                     = errors.New("not_authed")
+	ErrTokenRevoked                  = errors.New("token_revoked")
+	ErrActionProhibited              = errors.New("action_prohibited")
+	ErrChannelNotFound               = errors.New("channel_not_found")
+	ErrChannelIsArchived             = errors.New("channel_is_archived")
+	ErrIsArchived                    = errors.New("is_archived")
+	ErrNotInChannel                  = errors.New("not_in_channel")
+	ErrPostingToGeneralChannelDenied = errors.New("posting_to_general_channel_denied")
+	ErrInvalidBlocks                 = errors.New("invalid_blocks")
+	ErrNoService                     = errors.New("no_service")
+	ErrNoServiceID                   = errors.New("no_service_id")
+	ErrNoTeam                        = errors.New("no_team")
+	ErrTeamDisabled                  = errors.New("team_disabled")
+	ErrNoText                        = errors.New("no_text")
+	ErrTooManyAttachments            = errors.New("too_many_attachments")
+	ErrRateLimited                   = errors.New("rate_limited")
+
+	knownErrors = map[string]error{}
+)
+
+func init() {
+	for _, err := range []error{
+		ErrInvalidPayload, ErrInvalidToken, ErrInvalidAuth, ErrNotAuthed, ErrTokenRevoked, ErrActionProhibited,
+		ErrChannelNotFound, ErrChannelIsArchived, ErrIsArchived, ErrNotInChannel, ErrPostingToGeneralChannelDenied,
+		ErrInvalidBlocks, ErrNoService, ErrNoServiceID, ErrNoTeam, ErrTeamDisabled, ErrNoText, ErrTooManyAttachments,
+		ErrRateLimited,
+	} {
+		knownErrors[err.Error()] = err
+	}
+}
+
+// APIError is an error response from Slack
+type APIError struct {
+	StatusCode int
+	// Code is Slack's error string, e.g. "channel_not_found"
+	Code string
+	// RetryAfter is how long Slack asked us to wait before trying again, if rate limited
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("slack error %q (HTTP %d, retry after %s)", e.Code, e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("slack error %q (HTTP %d)", e.Code, e.StatusCode)
+}
+
+// Is matches the error against the documented Slack errors above
+func (e *APIError) Is(target error) bool {
+	known, ok := knownErrors[e.Code]
+	return ok && known == target
+}
@@ -0,0 +1,84 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/justinrixx/retryhttp"
+)
+
+const (
+	// maxRateLimitRetries bounds how many times a rate-limited request is retried after waiting out Retry-After
+	maxRateLimitRetries = 2
+	// defaultRetryAfter is used when Slack rate limits a request without saying how long to wait
+	defaultRetryAfter = time.Second
+)
+
+// DefaultHTTPClient is shared by clients that are not given their own. Rate limiting is handled by the clients, which
+// honor Slack's Retry-After, so the transport only retries transient failures.
+var DefaultHTTPClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: retryhttp.New(retryhttp.WithShouldRetryFn(func(attempt retryhttp.Attempt) bool {
+		if attempt.Res != nil && attempt.Res.StatusCode == http.StatusTooManyRequests {
+			return false
+		}
+		return retryhttp.DefaultShouldRetryFn(attempt)
+	})),
+}
+
+// postJSON posts body to url, waiting and retrying when Slack responds with 429 Too Many Requests. If the wait would
+// outlast ctx, the rate limit is returned as an *APIError instead. The caller must close the response body.
+func postJSON(ctx context.Context, httpClient *http.Client, url string, body []byte, header http.Header) (*http.Response, error) {
+	if httpClient == nil {
+		httpClient = DefaultHTTPClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		rateLimitErr := &APIError{StatusCode: resp.StatusCode, Code: ErrRateLimited.Error(), RetryAfter: retryAfter}
+		if attempt >= maxRateLimitRetries {
+			return nil, rateLimitErr
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < retryAfter {
+			return nil, rateLimitErr
+		}
+
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(t))
+	}
+	return defaultRetryAfter
+}
@@ -0,0 +1,53 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	signatureVersion = "v0"
+	// maxRequestAge guards against replayed requests
+	maxRequestAge = 5 * time.Minute
+)
+
+var (
+	ErrNoSigningSecret  = errors.New("no signing secret")
+	ErrInvalidSignature = errors.New("invalid slack request signature")
+	ErrStaleRequest     = errors.New("slack request timestamp is too old")
+)
+
+// VerifyRequestSignature checks the X-Slack-Signature and X-Slack-Request-Timestamp headers of a request against its raw
+// body, as described in https://api.slack.com/authentication/verifying-requests-from-slack
+func VerifyRequestSignature(signingSecret, timestamp, signature string, body []byte, now time.Time) error {
+	if signingSecret == "" {
+		return ErrNoSigningSecret
+	}
+
+	timestampSeconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse request timestamp %q: %w", timestamp, err)
+	}
+	requestTime := time.Unix(timestampSeconds, 0)
+	if now.Sub(requestTime).Abs() > maxRequestAge {
+		return ErrStaleRequest
+	}
+
+	expected := computeSignature(signingSecret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func computeSignature(signingSecret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(signatureVersion + ":" + timestamp + ":"))
+	mac.Write(body)
+	return signatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
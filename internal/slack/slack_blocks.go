@@ -0,0 +1,137 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/message"
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+const (
+	// maxBlocksPerPost is Slack's limit on the number of blocks in a single message
+	maxBlocksPerPost = 50
+	// maxHeaderLength is Slack's limit on the text of a header block
+	maxHeaderLength = 150
+	// maxSectionLength is Slack's limit on the text of a section block
+	maxSectionLength = 3000
+)
+
+type (
+	slackPost struct {
+		Channel string       `json:"channel,omitempty"`
+		Text    string       `json:"text"` // Fallback for notifications and clients that cannot display blocks
+		Blocks  []slackBlock `json:"blocks"`
+	}
+
+	slackBlock struct {
+		Type     string     `json:"type"`
+		Text     *slackText `json:"text,omitempty"`
+		Elements []any      `json:"elements,omitempty"`
+	}
+
+	slackText struct {
+		Type  string `json:"type"`
+		Text  string `json:"text"`
+		Emoji bool   `json:"emoji,omitempty"`
+	}
+
+	slackButton struct {
+		Type     string    `json:"type"`
+		Text     slackText `json:"text"`
+		URL      string    `json:"url"`
+		ActionID string    `json:"action_id"`
+	}
+)
+
+// buildPosts lays the message out as Block Kit blocks: a header, an optional body section, a section per work, a
+// context block with the time of the update and a button linking to the status page. Messages that would exceed
+// Slack's block limit are split into several posts, each with the header, numbered, and the context and button, so
+// that every post stands on its own.
+func buildPosts(channel string, msg message.Message, updates []progress.ProgressUpdate, statusPageURL string, updated time.Time) []slackPost {
+	var body []slackBlock
+	if strings.TrimSpace(msg.Body) != "" {
+		body = append(body, newSection(msg.Body))
+	}
+
+	trailing := []slackBlock{
+		{Type: "context", Elements: []any{
+			slackText{Type: "mrkdwn", Text: fmt.Sprintf("Updated <!date^%d^{date_short_pretty} at {time}|%s>", updated.Unix(), updated.UTC().Format(time.RFC1123))},
+		}},
+	}
+	if statusPageURL != "" {
+		trailing = append(trailing, slackBlock{Type: "actions", Elements: []any{
+			slackButton{
+				Type:     "button",
+				Text:     slackText{Type: "plain_text", Text: "View progress"},
+				URL:      statusPageURL,
+				ActionID: "view_progress",
+			},
+		}})
+	}
+
+	// Every post has a header and the trailing blocks, and the first has the body too
+	perPost := maxBlocksPerPost - 1 - len(trailing)
+	chunks := [][]slackBlock{body}
+	for _, item := range msg.Items {
+		last := len(chunks) - 1
+		if len(chunks[last]) == perPost {
+			chunks = append(chunks, nil)
+			last++
+		}
+		chunks[last] = append(chunks[last], newSection(item))
+	}
+
+	fallback := fallbackText(msg.Title, updates)
+	posts := make([]slackPost, len(chunks))
+	for i, chunk := range chunks {
+		title, text := msg.Title, fallback
+		if len(chunks) > 1 {
+			part := fmt.Sprintf(" (%d/%d)", i+1, len(chunks))
+			title, text = truncate(title, maxHeaderLength-len(part))+part, text+part
+		}
+		blocks := []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: truncate(title, maxHeaderLength), Emoji: true}},
+		}
+		blocks = append(blocks, chunk...)
+		blocks = append(blocks, trailing...)
+		posts[i] = slackPost{Channel: channel, Text: text, Blocks: blocks}
+	}
+	return posts
+}
+
+// sendPosts sends the posts in order, stopping at the first that fails. When the message was split, the error says
+// which part failed, as the parts before it have already been posted.
+func sendPosts(posts []slackPost, send func(slackPost) error) error {
+	for i, post := range posts {
+		if err := send(post); err != nil {
+			if len(posts) > 1 {
+				return fmt.Errorf("post part %d of %d: %w", i+1, len(posts), err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func newSection(text string) slackBlock {
+	return slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: truncate(text, maxSectionLength)}}
+}
+
+// fallbackText is the plain text shown in notifications, e.g. "Progress update: Book 1 (10% => 20%), Book 2 (50%)"
+func fallbackText(title string, updates []progress.ProgressUpdate) string {
+	works := make([]string, len(updates))
+	for i, update := range updates {
+		works[i] = update.String()
+	}
+	return fmt.Sprintf("%s: %s", title, strings.Join(works, ", "))
+}
+
+func truncate(text string, maxLength int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+	return string(runes[:maxLength-1]) + "…"
+}
@@ -0,0 +1,92 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/message"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPosts(t *testing.T) {
+	updates := []progress.ProgressUpdate{
+		{Title: "Book 1", Progress: 45, PrevProgress: 40},
+		{Title: "Book 2", Progress: 100, PrevProgress: 100},
+	}
+	msg, err := message.MustNew("slack", DefaultMessages).Render("", updates)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	posts := buildPosts("#channel", msg, updates, "https://status.example.com/", now)
+	require.Len(t, posts, 1)
+
+	actual, err := json.Marshal(posts[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"channel": "#channel",
+		"text": "Brandon Sanderson has posted a progress update: Book 1 (40% => 45%), Book 2 (100%)",
+		"blocks": [
+			{"type": "header", "text": {"type": "plain_text", "text": "Brandon Sanderson has posted a progress update", "emoji": true}},
+			{"type": "section", "text": {"type": "mrkdwn", "text": "*Book 1*\n`+"`█████░░░░░`"+` 45% (+5)"}},
+			{"type": "section", "text": {"type": "mrkdwn", "text": "*Book 2*\n`+"`██████████`"+` 100%"}},
+			{"type": "context", "elements": [
+				{"type": "mrkdwn", "text": "Updated <!date^1704164645^{date_short_pretty} at {time}|Tue, 02 Jan 2024 03:04:05 UTC>"}
+			]},
+			{"type": "actions", "elements": [
+				{"type": "button", "text": {"type": "plain_text", "text": "View progress"}, "url": "https://status.example.com/", "action_id": "view_progress"}
+			]}
+		]
+	}`, string(actual))
+}
+
+func TestBuildPosts_SplitsAtBlockLimit(t *testing.T) {
+	updates := make([]progress.ProgressUpdate, 120)
+	for i := range updates {
+		updates[i] = progress.ProgressUpdate{Title: fmt.Sprintf("Book %d", i), Progress: i % 100}
+	}
+	msg, err := message.MustNew("slack", DefaultMessages).Render("", updates)
+	require.NoError(t, err)
+
+	posts := buildPosts("", msg, updates, "https://status.example.com/", time.Now())
+	require.Len(t, posts, 3)
+
+	sections := 0
+	for i, post := range posts {
+		require.LessOrEqual(t, len(post.Blocks), maxBlocksPerPost)
+		for _, block := range post.Blocks {
+			if block.Type == "section" {
+				sections++
+			}
+		}
+		// Every part stands on its own
+		part := fmt.Sprintf("(%d/3)", i+1)
+		require.Equal(t, "header", post.Blocks[0].Type)
+		require.True(t, strings.HasSuffix(post.Blocks[0].Text.Text, part), post.Blocks[0].Text.Text)
+		require.Equal(t, "context", post.Blocks[len(post.Blocks)-2].Type)
+		require.Equal(t, "actions", post.Blocks[len(post.Blocks)-1].Type)
+		require.True(t, strings.HasSuffix(post.Text, part), post.Text)
+	}
+	require.Equal(t, len(updates), sections)
+}
+
+func TestSendPosts(t *testing.T) {
+	posts := []slackPost{{Text: "1"}, {Text: "2"}, {Text: "3"}}
+	sent := []string{}
+	err := sendPosts(posts, func(post slackPost) error {
+		if post.Text == "2" {
+			return ErrChannelNotFound
+		}
+		sent = append(sent, post.Text)
+		return nil
+	})
+	require.ErrorIs(t, err, ErrChannelNotFound)
+	require.ErrorContains(t, err, "post part 2 of 3")
+	require.Equal(t, []string{"1"}, sent)
+
+	err = sendPosts(posts[:1], func(slackPost) error { return ErrChannelNotFound })
+	require.Equal(t, ErrChannelNotFound, err)
+}
@@ -1,45 +1,45 @@
 package slack
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
-	"time"
+	"strings"
 
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/message"
 	"github.com/Rhionin/SanderServer/internal/progress"
-	"github.com/justinrixx/retryhttp"
 )
 
 var (
 	ErrNoWebhookURL      = errors.New("no webhook url")
 	ErrNoProgressUpdates = errors.New("no progress updates")
+
+	// DefaultMessages are the templates used when none are configured. The title is the header block, the body is an
+	// optional section below it and each item is the section for one work.
+	DefaultMessages = message.Config{
+		Title: "Brandon Sanderson has posted a progress update",
+		Item:  "*{{.Title}}*\n`{{.Bar}}` {{.Progress}}%{{if .Delta}} ({{printf \"%+d\" .Delta}}){{end}}",
+	}
 )
 
 type (
 	UpdateClient struct {
+		// Name distinguishes this client from others when several workspaces or channels are notified
+		Name            string
 		WebhookURL      string
 		ChannelOverride string
-	}
-
-	slackAttachment struct {
-		Color  string       `json:"color"`
-		Title  string       `json:"title"`
-		Text   string       `json:"text"`
-		Fields []slackField `json:"fields"`
-	}
-
-	slackField struct {
-		Title string `json:"title"`
-		Value string `json:"value"`
-		Short bool   `json:"short"`
-	}
-
-	slackPost struct {
-		Channel     string            `json:"channel,omitempty"`
-		Text        string            `json:"text"`
-		Attachments []slackAttachment `json:"attachments"`
+		Messages        *message.Templates
+		Locale          string
+		// StatusPageURL is linked from a button at the end of the message, if set
+		StatusPageURL string
+		// HTTPClient sends the webhook requests. Defaults to DefaultHTTPClient.
+		HTTPClient *http.Client
+		// DryRun, if set, gets the posts instead of the webhook
+		DryRun *dryrun.Writer
 	}
 )
 
@@ -47,59 +47,67 @@ func NewUpdateClient(webhookURL, channelOverride string) *UpdateClient {
 	return &UpdateClient{
 		WebhookURL:      webhookURL,
 		ChannelOverride: channelOverride,
+		Messages:        message.MustNew("slack", DefaultMessages),
+		HTTPClient:      DefaultHTTPClient,
 	}
 }
 
 func (client *UpdateClient) GetName() string {
-	return "slack"
+	if client.Name == "" {
+		return "slack"
+	}
+	return "slack:" + client.Name
 }
 
 // SendSlackUpdate sends an update to slack
 func (client *UpdateClient) SendUpdate(ctx context.Context, progressUpdates []progress.ProgressUpdate) error {
-	if client.WebhookURL == "" {
+	if client.WebhookURL == "" && client.DryRun == nil {
 		return ErrNoWebhookURL
 	}
 	if len(progressUpdates) == 0 {
 		return ErrNoProgressUpdates
 	}
 
-	fields := []slackField{}
-	for _, update := range progressUpdates {
-		fields = append(fields, slackField{
-			Value: update.String(),
-		})
-	}
-
-	slackBody, _ := json.Marshal(slackPost{
-		Channel: client.ChannelOverride,
-		Text:    "*Brandon Sanderson has posted a progress update:*",
-		Attachments: []slackAttachment{
-			{
-				Color:  "#007500",
-				Fields: fields,
-			},
-		},
-	})
-	req, err := http.NewRequest(http.MethodPost, client.WebhookURL, bytes.NewBuffer(slackBody))
+	msg, err := client.Messages.Render(client.Locale, progressUpdates)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	posts := buildPosts(client.ChannelOverride, msg, progressUpdates, client.StatusPageURL, progress.UpdateTime(ctx))
+	return sendPosts(posts, func(post slackPost) error {
+		return client.send(ctx, post)
+	})
+}
 
-	httpClient := &http.Client{
-		Timeout:   10 * time.Second,
-		Transport: retryhttp.New(),
+// send posts to the webhook. Slack answers "ok" on success and an error string such as "channel_not_found" otherwise.
+func (client *UpdateClient) send(ctx context.Context, post slackPost) error {
+	slackBody, err := json.Marshal(post)
+	if err != nil {
+		return fmt.Errorf("marshal post: %w", err)
 	}
-	resp, err := httpClient.Do(req)
+	if client.DryRun != nil {
+		// The webhook URL is a credential, so it is left out
+		destination := post.Channel
+		if destination == "" {
+			destination = "webhook"
+		}
+		return client.DryRun.Write(dryrun.Request{Target: client.GetName(), Destination: destination, Payload: slackBody})
+	}
+
+	resp, err := postJSON(ctx, client.HTTPClient, client.WebhookURL, slackBody, http.Header{
+		"Content-Type": {"application/json"},
+	})
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(resp.Body)
-	if buf.String() != "ok" {
-		return errors.New("Non-ok response returned from Slack: " + buf.String())
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response body: %w", err)
+	}
+	if resp.StatusCode == http.StatusOK && string(respBody) == "ok" {
+		return nil
 	}
-	return nil
+	return &APIError{StatusCode: resp.StatusCode, Code: strings.TrimSpace(string(respBody))}
 }
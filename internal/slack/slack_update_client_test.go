@@ -1,13 +1,102 @@
 package slack_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/progress"
 	"github.com/Rhionin/SanderServer/internal/slack"
 	"github.com/Rhionin/SanderServer/internal/storminglambdas"
+	"github.com/stretchr/testify/require"
 )
 
 // Verify that UpdateClient implements the PushTarget interface
 func TestSlackUpdateClientImplementsPushTargetInterface(t *testing.T) {
 	var _ storminglambdas.PushTarget = (*slack.UpdateClient)(nil)
 }
+
+func TestUpdateClient_SendUpdate(t *testing.T) {
+	updates := []progress.ProgressUpdate{{Title: "Book 1", Progress: 20, PrevProgress: 10}}
+
+	t.Run("ok", func(t *testing.T) {
+		var received map[string]any
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		client := slack.NewUpdateClient(server.URL, "#channel")
+		require.NoError(t, client.SendUpdate(context.Background(), updates))
+		require.Equal(t, "#channel", received["channel"])
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		client := slack.NewUpdateClient("", "#channel")
+		client.DryRun = dryrun.New(out)
+		require.NoError(t, client.SendUpdate(context.Background(), updates))
+
+		var request dryrun.Request
+		require.NoError(t, json.Unmarshal(out.Bytes(), &request))
+		require.Equal(t, "slack", request.Target)
+		require.Equal(t, "#channel", request.Destination)
+		var post map[string]any
+		require.NoError(t, json.Unmarshal(request.Payload, &post))
+		require.Equal(t, "#channel", post["channel"])
+		require.NotEmpty(t, post["blocks"])
+	})
+
+	t.Run("typed errors", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("channel_not_found"))
+		}))
+		defer server.Close()
+
+		err := slack.NewUpdateClient(server.URL, "#gone").SendUpdate(context.Background(), updates)
+		require.ErrorIs(t, err, slack.ErrChannelNotFound)
+		var apiErr *slack.APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	})
+
+	t.Run("waits out rate limits", func(t *testing.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		require.NoError(t, slack.NewUpdateClient(server.URL, "").SendUpdate(context.Background(), updates))
+		require.Equal(t, 2, requests)
+	})
+
+	t.Run("gives up when the rate limit outlasts the context", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := slack.NewUpdateClient(server.URL, "").SendUpdate(ctx, updates)
+		require.ErrorIs(t, err, slack.ErrRateLimited)
+		var apiErr *slack.APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, time.Minute, apiErr.RetryAfter)
+	})
+}
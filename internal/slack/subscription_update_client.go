@@ -0,0 +1,124 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/message"
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+type (
+	// SubscriptionUpdateClient posts updates through the Web API to every channel that subscribed to one of the works
+	// that changed, using the slash commands, and removes the subscriptions of channels that are gone
+	SubscriptionUpdateClient struct {
+		Poster        messagePoster
+		Subscriptions subscriptionStore
+		Messages      *message.Templates
+		Locale        string
+		StatusPageURL string
+		// DryRun, if set, gets the posts instead of Poster
+		DryRun *dryrun.Writer
+	}
+
+	messagePoster interface {
+		PostMessage(ctx context.Context, post slackPost) error
+	}
+
+	subscriptionStore interface {
+		GetSubscriptions(ctx context.Context) ([]Subscription, error)
+		RemoveSubscriptions(ctx context.Context, channelIDs ...string) error
+	}
+)
+
+func NewSubscriptionUpdateClient(poster messagePoster, subscriptions subscriptionStore) *SubscriptionUpdateClient {
+	return &SubscriptionUpdateClient{
+		Poster:        poster,
+		Subscriptions: subscriptions,
+		Messages:      message.MustNew("slack", DefaultMessages),
+	}
+}
+
+func (client *SubscriptionUpdateClient) GetName() string {
+	return "slack-subscriptions"
+}
+
+// SendUpdate posts the subscribed works' updates to each subscribed channel. Channels whose works did not change are
+// skipped. A channel that fails does not stop the others; channels that were deleted or archived are unsubscribed.
+func (client *SubscriptionUpdateClient) SendUpdate(ctx context.Context, progressUpdates []progress.ProgressUpdate) error {
+	if len(progressUpdates) == 0 {
+		return ErrNoProgressUpdates
+	}
+
+	subscriptions, err := client.Subscriptions.GetSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("get subscriptions: %w", err)
+	}
+
+	updated := progress.UpdateTime(ctx)
+	gone := []string{}
+	var errs []error
+	for _, subscription := range subscriptions {
+		updates := subscribedUpdates(subscription, progressUpdates)
+		if len(updates) == 0 {
+			continue
+		}
+
+		msg, err := client.Messages.Render(client.Locale, updates)
+		if err != nil {
+			return err
+		}
+		posts := buildPosts(subscription.ChannelID, msg, updates, client.StatusPageURL, updated)
+		err = sendPosts(posts, func(post slackPost) error {
+			return client.postMessage(ctx, post)
+		})
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrChannelNotFound), errors.Is(err, ErrChannelIsArchived), errors.Is(err, ErrIsArchived):
+			gone = append(gone, subscription.ChannelID)
+		default:
+			errs = append(errs, fmt.Errorf("post to %s: %w", subscription.ChannelID, err))
+		}
+	}
+
+	if len(gone) > 0 {
+		logging.FromContext(ctx).Info("Removing subscriptions of deleted or archived channels", "channels", gone)
+		if err := client.Subscriptions.RemoveSubscriptions(ctx, gone...); err != nil {
+			errs = append(errs, fmt.Errorf("remove subscriptions: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (client *SubscriptionUpdateClient) postMessage(ctx context.Context, post slackPost) error {
+	if client.DryRun == nil {
+		return client.Poster.PostMessage(ctx, post)
+	}
+	body, err := json.Marshal(post)
+	if err != nil {
+		return fmt.Errorf("marshal post: %w", err)
+	}
+	return client.DryRun.Write(dryrun.Request{Target: client.GetName(), Destination: post.Channel, Payload: body})
+}
+
+// subscribedUpdates returns the updates for the subscription's works, or none if none of those works changed
+func subscribedUpdates(subscription Subscription, progressUpdates []progress.ProgressUpdate) []progress.ProgressUpdate {
+	updates := []progress.ProgressUpdate{}
+	anyChanged := false
+	for _, update := range progressUpdates {
+		if !slices.Contains(subscription.WorkIDs, update.WorkID()) {
+			continue
+		}
+		updates = append(updates, update)
+		anyChanged = anyChanged || update.ChangeKind() != progress.ChangeKindUnchanged
+	}
+	if !anyChanged {
+		return nil
+	}
+	return updates
+}
@@ -0,0 +1,93 @@
+package slack
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	fakePoster struct {
+		posts []slackPost
+		// errs fails the posts to the channels
+		errs map[string]error
+	}
+
+	fakeSubscriptions struct {
+		subscriptions []Subscription
+		removed       []string
+	}
+)
+
+func (f *fakePoster) PostMessage(ctx context.Context, post slackPost) error {
+	if err := f.errs[post.Channel]; err != nil {
+		return err
+	}
+	f.posts = append(f.posts, post)
+	return nil
+}
+
+func (f *fakeSubscriptions) GetSubscriptions(ctx context.Context) ([]Subscription, error) {
+	return f.subscriptions, nil
+}
+
+func (f *fakeSubscriptions) RemoveSubscriptions(ctx context.Context, channelIDs ...string) error {
+	f.removed = append(f.removed, channelIDs...)
+	return nil
+}
+
+func TestSubscriptionUpdateClient_SendUpdate(t *testing.T) {
+	poster := &fakePoster{}
+	client := NewSubscriptionUpdateClient(poster, &fakeSubscriptions{subscriptions: []Subscription{
+		{ChannelID: "C1", WorkIDs: []string{"book-1"}},
+		{ChannelID: "C2", WorkIDs: []string{"book-2"}},
+		{ChannelID: "C3", WorkIDs: []string{"book-1", "book-2"}},
+	}})
+
+	updated := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	ctx := progress.WithUpdateTime(context.Background(), updated)
+	err := client.SendUpdate(ctx, []progress.ProgressUpdate{
+		{Title: "Book 1", Progress: 20, PrevProgress: 10},
+		{Title: "Book 2", Progress: 50, PrevProgress: 50},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, poster.posts, 2)
+	require.Equal(t, "C1", poster.posts[0].Channel)
+	require.Len(t, poster.posts[0].Blocks, 3, "header, one work, context")
+	require.Equal(t, "C3", poster.posts[1].Channel)
+	require.Len(t, poster.posts[1].Blocks, 4, "header, two works, context")
+
+	// The context block shows when the entry was written, not when it was pushed
+	contextText := poster.posts[0].Blocks[2].Elements[0].(slackText).Text
+	require.Contains(t, contextText, strconv.FormatInt(updated.Unix(), 10))
+}
+
+func TestSubscriptionUpdateClient_SendUpdateFailures(t *testing.T) {
+	poster := &fakePoster{errs: map[string]error{
+		"C1": fmt.Errorf("chat.postMessage to C1: %w", &APIError{StatusCode: 200, Code: "not_in_channel"}),
+		"C2": fmt.Errorf("chat.postMessage to C2: %w", &APIError{StatusCode: 200, Code: "is_archived"}),
+		"C3": fmt.Errorf("chat.postMessage to C3: %w", &APIError{StatusCode: 200, Code: "channel_not_found"}),
+	}}
+	subscriptions := &fakeSubscriptions{subscriptions: []Subscription{
+		{ChannelID: "C1", WorkIDs: []string{"book-1"}},
+		{ChannelID: "C2", WorkIDs: []string{"book-1"}},
+		{ChannelID: "C3", WorkIDs: []string{"book-1"}},
+		{ChannelID: "C4", WorkIDs: []string{"book-1"}},
+	}}
+	client := NewSubscriptionUpdateClient(poster, subscriptions)
+
+	err := client.SendUpdate(context.Background(), []progress.ProgressUpdate{{Title: "Book 1", Progress: 20, PrevProgress: 10}})
+	require.ErrorIs(t, err, ErrNotInChannel)
+	require.ErrorContains(t, err, "post to C1")
+	require.NotErrorIs(t, err, ErrIsArchived, "gone channels are not errors")
+
+	require.Len(t, poster.posts, 1, "channels after a failed one are still posted to")
+	require.Equal(t, "C4", poster.posts[0].Channel)
+	require.Equal(t, []string{"C2", "C3"}, subscriptions.removed)
+}
@@ -0,0 +1,113 @@
+package slack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type (
+	// Subscription lists the works a channel follows
+	Subscription struct {
+		ChannelID string
+		WorkIDs   []string `dynamodbav:",stringset,omitempty"`
+	}
+
+	// DynamoSubscriptionStore stores channel subscriptions in DynamoDB, one item per channel
+	DynamoSubscriptionStore struct {
+		client    *dynamodb.Client
+		tableName string
+	}
+)
+
+func NewDynamoSubscriptionStore(dbClient *dynamodb.Client, tableName string) *DynamoSubscriptionStore {
+	return &DynamoSubscriptionStore{client: dbClient, tableName: tableName}
+}
+
+// GetSubscriptions returns every channel's subscription
+func (s *DynamoSubscriptionStore) GetSubscriptions(ctx context.Context) ([]Subscription, error) {
+	subscriptions := []Subscription{}
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName: aws.String(s.tableName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("scan slack subscriptions: %w", err)
+		}
+		var pageSubscriptions []Subscription
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageSubscriptions); err != nil {
+			return nil, fmt.Errorf("unmarshal slack subscriptions: %w", err)
+		}
+		subscriptions = append(subscriptions, pageSubscriptions...)
+	}
+	return subscriptions, nil
+}
+
+// GetSubscription returns the channel's subscription. Channels without one follow no works.
+func (s *DynamoSubscriptionStore) GetSubscription(ctx context.Context, channelID string) (Subscription, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"ChannelID": &types.AttributeValueMemberS{Value: channelID},
+		},
+	})
+	if err != nil {
+		return Subscription{}, fmt.Errorf("get slack subscription: %w", err)
+	}
+	subscription := Subscription{ChannelID: channelID}
+	if result.Item == nil {
+		return subscription, nil
+	}
+	if err := attributevalue.UnmarshalMap(result.Item, &subscription); err != nil {
+		return Subscription{}, fmt.Errorf("unmarshal slack subscription: %w", err)
+	}
+	return subscription, nil
+}
+
+// Subscribe adds the work to the channel's subscription
+func (s *DynamoSubscriptionStore) Subscribe(ctx context.Context, channelID, workID string) error {
+	return s.updateWorkIDs(ctx, "ADD", channelID, workID)
+}
+
+// Unsubscribe removes the work from the channel's subscription
+func (s *DynamoSubscriptionStore) Unsubscribe(ctx context.Context, channelID, workID string) error {
+	return s.updateWorkIDs(ctx, "DELETE", channelID, workID)
+}
+
+// RemoveSubscriptions removes the channels' subscriptions. Unknown channels are ignored.
+func (s *DynamoSubscriptionStore) RemoveSubscriptions(ctx context.Context, channelIDs ...string) error {
+	var errs []error
+	for _, channelID := range channelIDs {
+		if _, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.tableName),
+			Key: map[string]types.AttributeValue{
+				"ChannelID": &types.AttributeValueMemberS{Value: channelID},
+			},
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("delete slack subscription: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *DynamoSubscriptionStore) updateWorkIDs(ctx context.Context, action, channelID, workID string) error {
+	if _, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"ChannelID": &types.AttributeValueMemberS{Value: channelID},
+		},
+		UpdateExpression: aws.String(action + " WorkIDs :workIDs"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":workIDs": &types.AttributeValueMemberSS{Value: []string{workID}},
+		},
+	}); err != nil {
+		return fmt.Errorf("update slack subscription: %w", err)
+	}
+	return nil
+}
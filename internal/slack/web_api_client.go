@@ -0,0 +1,70 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	defaultWebAPIBaseURL = "https://slack.com/api"
+)
+
+var (
+	ErrNoBotToken = errors.New("no bot token")
+)
+
+type (
+	// WebAPIClient posts messages as a Slack app's bot user, which unlike an incoming webhook can post to any channel
+	// the app has been invited to
+	WebAPIClient struct {
+		BotToken string
+		BaseURL  string
+		// HTTPClient sends the API requests. Defaults to DefaultHTTPClient.
+		HTTPClient *http.Client
+	}
+
+	webAPIResponse struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+)
+
+func NewWebAPIClient(botToken string) *WebAPIClient {
+	return &WebAPIClient{
+		BotToken:   botToken,
+		BaseURL:    defaultWebAPIBaseURL,
+		HTTPClient: DefaultHTTPClient,
+	}
+}
+
+// PostMessage posts to a channel via chat.postMessage
+func (client *WebAPIClient) PostMessage(ctx context.Context, post slackPost) error {
+	if client.BotToken == "" {
+		return ErrNoBotToken
+	}
+
+	slackBody, err := json.Marshal(post)
+	if err != nil {
+		return fmt.Errorf("marshal post: %w", err)
+	}
+	resp, err := postJSON(ctx, client.HTTPClient, client.BaseURL+"/chat.postMessage", slackBody, http.Header{
+		"Content-Type":  {"application/json; charset=utf-8"},
+		"Authorization": {"Bearer " + client.BotToken},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result webAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode chat.postMessage response (status %d): %w", resp.StatusCode, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("chat.postMessage to %s: %w", post.Channel, &APIError{StatusCode: resp.StatusCode, Code: result.Error})
+	}
+	return nil
+}
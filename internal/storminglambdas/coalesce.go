@@ -0,0 +1,92 @@
+package storminglambdas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+// FlushCoalescedUpdates sends each push target a single notification for every history entry written since the target
+// was last notified, once the coalescing window opened by the first of those entries has closed. The notification is
+// diffed against the progress last notified to the target rather than the immediately previous entry.
+//
+// Each target's notification is claimed before it is sent, so that a scheduled flush and a stream invocation flushing
+// at once never both send it, and a failed send is retried by the next flush for that target alone.
+func (handler *PushUpdateHandler) FlushCoalescedUpdates(ctx context.Context) error {
+	latest, err := handler.History.GetLatestProgressEntry(ctx)
+	if errors.Is(err, history.ErrEmptyHistory) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("get latest progress entry: %w", err)
+	}
+	if latest.Historical {
+		logging.FromContext(ctx).Debug("The latest entry is historical. No updates to push.")
+		return nil
+	}
+	ctx = withEntryCorrelationID(ctx, latest)
+
+	var errs []error
+	for _, target := range handler.PushTargets {
+		if err := handler.flushTarget(ctx, target, latest); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flushTarget sends the target what changed since it was last notified, if its coalescing window has closed
+func (handler *PushUpdateHandler) flushTarget(ctx context.Context, target PushTarget, latest history.ProgressEntry) error {
+	targetName := target.GetName()
+	logger := logging.FromContext(ctx).With("target", targetName)
+
+	notified, err := handler.getLatestNotifiedEntry(ctx, targetName)
+	if errors.Is(err, history.ErrNoNotifiedEntry) {
+		// The target has not been notified since coalescing was enabled, so fall back to the entry before the latest one
+		notified, err = handler.History.GetLatestProgressEntryBeforeID(ctx, history.ProgressDynamoEntry{
+			TimestampUnixNano: latest.Timestamp.UnixNano(),
+		})
+		if errors.Is(err, history.ErrNoEntryBeforeTarget) {
+			logger.Info("This appears to be the first history entry. No updates to push.")
+			return handler.markNotified(ctx, latest, targetName)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("(%s) get latest notified entry: %w", targetName, err)
+	}
+
+	if !latest.Timestamp.After(notified.Timestamp) {
+		return nil // Nothing pending
+	}
+
+	windowStart, err := handler.History.GetEarliestProgressEntryAfter(ctx, notified.Timestamp)
+	if err != nil {
+		return fmt.Errorf("(%s) get first pending entry: %w", targetName, err)
+	}
+	windowEnd := windowStart.Timestamp.Add(handler.CoalesceWindow)
+	if handler.now().Before(windowEnd) {
+		logger.Debug("Coalescing window is open", "until", windowEnd)
+		return nil
+	}
+
+	if reflect.DeepEqual(latest.WorksInProgress, notified.WorksInProgress) {
+		logger.Info("Progress returned to the last notified state. No updates to push.")
+		return handler.markNotified(ctx, latest, targetName)
+	}
+
+	updates := progress.GetProgressUpdate(latest.WorksInProgress, notified.WorksInProgress)
+	return handler.deliver(ctx, target, latest, updates)
+}
+
+// markNotified records the entry as notified to the target without sending anything
+func (handler *PushUpdateHandler) markNotified(ctx context.Context, entry history.ProgressEntry, targetName string) error {
+	err := handler.addNotifiedEntry(ctx, entry, targetName)
+	if err != nil && !errors.Is(err, history.ErrAlreadyNotified) {
+		return fmt.Errorf("(%s) add notified entry: %w", targetName, err)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package storminglambdas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/metrics"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushUpdates_Coalescing(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	entryAt := func(minutes int, bookProgress int) history.ProgressEntry {
+		return history.ProgressEntry{
+			Timestamp:       start.Add(time.Duration(minutes) * time.Minute),
+			WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: bookProgress}},
+		}
+	}
+
+	historyClient := &fakeHistoryClient{
+		entries:  map[int64]history.ProgressEntry{},
+		notified: map[string][]history.ProgressEntry{"fake": {entryAt(0, 10)}},
+	}
+	historyClient.entries[start.UnixNano()] = entryAt(0, 10)
+	target := &fakePushTarget{name: "fake"}
+	now := start
+	handler := PushUpdateHandler{
+		History:        historyClient,
+		PushTargets:    []PushTarget{target},
+		CoalesceWindow: 5 * time.Minute,
+		Now:            func() time.Time { return now },
+	}
+
+	// Three edits within a few minutes
+	for i, bookProgress := range []int{15, 20, 25} {
+		entry := entryAt(i+1, bookProgress)
+		historyClient.entries[entry.Timestamp.UnixNano()] = entry
+		now = entry.Timestamp
+
+		record := newInsertRecord("1", entry.Timestamp.UnixNano(), "0")
+		response, err := handler.PushUpdates(ctx, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{record}})
+		require.NoError(t, err)
+		require.Empty(t, response.BatchItemFailures)
+		require.Empty(t, target.received, "nothing should be sent while the window is open")
+	}
+
+	// Scheduled flush after the window closes
+	now = start.Add(7 * time.Minute)
+	_, err := handler.PushUpdates(ctx, events.DynamoDBEvent{})
+	require.NoError(t, err)
+	require.Equal(t, [][]progress.ProgressUpdate{
+		{{Title: "Book 1", Progress: 25, PrevProgress: 10}},
+	}, target.received)
+	require.Equal(t, entryAt(3, 25), historyClient.notified["fake"][len(historyClient.notified["fake"])-1])
+
+	// Nothing is pending anymore
+	now = start.Add(20 * time.Minute)
+	_, err = handler.PushUpdates(ctx, events.DynamoDBEvent{})
+	require.NoError(t, err)
+	require.Len(t, target.received, 1)
+}
+
+func TestPushUpdates_CoalescingRevertedEdit(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	baseline := history.ProgressEntry{Timestamp: start, WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 10}}}
+	edit := history.ProgressEntry{Timestamp: start.Add(time.Minute), WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 11}}}
+	revert := history.ProgressEntry{Timestamp: start.Add(2 * time.Minute), WorksInProgress: baseline.WorksInProgress}
+
+	historyClient := &fakeHistoryClient{
+		entries: map[int64]history.ProgressEntry{
+			baseline.Timestamp.UnixNano(): baseline,
+			edit.Timestamp.UnixNano():     edit,
+			revert.Timestamp.UnixNano():   revert,
+		},
+		notified: map[string][]history.ProgressEntry{"fake": {baseline}},
+	}
+	target := &fakePushTarget{name: "fake"}
+	handler := PushUpdateHandler{
+		History:        historyClient,
+		PushTargets:    []PushTarget{target},
+		CoalesceWindow: 5 * time.Minute,
+		Now:            func() time.Time { return start.Add(10 * time.Minute) },
+	}
+
+	_, err := handler.PushUpdates(context.Background(), events.DynamoDBEvent{})
+	require.NoError(t, err)
+	require.Empty(t, target.received)
+	require.Equal(t, revert, historyClient.notified["fake"][len(historyClient.notified["fake"])-1])
+}
+
+// racingHistoryClient loses every notified entry claim to another invocation
+type racingHistoryClient struct {
+	*fakeHistoryClient
+}
+
+func (r racingHistoryClient) AddNotifiedEntry(ctx context.Context, entry history.ProgressEntry, target string) error {
+	if err := r.fakeHistoryClient.AddNotifiedEntry(ctx, entry, target); err != nil {
+		return err
+	}
+	return r.fakeHistoryClient.AddNotifiedEntry(ctx, entry, target)
+}
+
+func TestPushUpdates_CoalescingPerTarget(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	baseline := history.ProgressEntry{Timestamp: start, WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 10}}}
+	edit := history.ProgressEntry{Timestamp: start.Add(time.Minute), WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 20}}}
+	newHistoryClient := func() *fakeHistoryClient {
+		return &fakeHistoryClient{
+			entries: map[int64]history.ProgressEntry{
+				baseline.Timestamp.UnixNano(): baseline,
+				edit.Timestamp.UnixNano():     edit,
+			},
+			notified: map[string][]history.ProgressEntry{"sent": {baseline}, "failed": {baseline}},
+		}
+	}
+	now := func() time.Time { return start.Add(10 * time.Minute) }
+
+	t.Run("retries only the failed target", func(t *testing.T) {
+		historyClient := newHistoryClient()
+		sent := &fakePushTarget{name: "sent"}
+		failed := &fakePushTarget{name: "failed", err: errors.New("boom")}
+		handler := PushUpdateHandler{
+			History:        historyClient,
+			PushTargets:    []PushTarget{sent, failed},
+			CoalesceWindow: 5 * time.Minute,
+			Now:            now,
+		}
+
+		_, err := handler.PushUpdates(context.Background(), events.DynamoDBEvent{})
+		require.ErrorContains(t, err, "boom")
+		require.Len(t, sent.received, 1)
+		require.Equal(t, []history.ProgressEntry{baseline}, historyClient.notified["failed"], "the failed target's claim is released")
+
+		failed.err = nil
+		_, err = handler.PushUpdates(context.Background(), events.DynamoDBEvent{})
+		require.NoError(t, err)
+		require.Len(t, sent.received, 1, "the target that succeeded is not notified again")
+		require.Len(t, failed.received, 1)
+	})
+
+	t.Run("skips targets claimed by another invocation", func(t *testing.T) {
+		target := &fakePushTarget{name: "sent"}
+		handler := PushUpdateHandler{
+			History:        racingHistoryClient{newHistoryClient()},
+			PushTargets:    []PushTarget{target},
+			CoalesceWindow: 5 * time.Minute,
+			Now:            now,
+		}
+
+		_, err := handler.PushUpdates(context.Background(), events.DynamoDBEvent{})
+		require.NoError(t, err)
+		require.Empty(t, target.received)
+	})
+}
+
+func TestPushUpdates_CoalescingDryRun(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	baseline := history.ProgressEntry{Timestamp: start, WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 10}}}
+	edit := history.ProgressEntry{Timestamp: start.Add(time.Minute), WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 20}}}
+
+	historyClient := &fakeHistoryClient{
+		entries: map[int64]history.ProgressEntry{
+			baseline.Timestamp.UnixNano(): baseline,
+			edit.Timestamp.UnixNano():     edit,
+		},
+		notified: map[string][]history.ProgressEntry{"fake": {baseline}},
+	}
+	target := &fakePushTarget{name: "fake"}
+	metricsOut := &bytes.Buffer{}
+	handler := PushUpdateHandler{
+		History:        historyClient,
+		PushTargets:    []PushTarget{target},
+		CoalesceWindow: 5 * time.Minute,
+		Metrics:        &metrics.Recorder{Writer: metricsOut, Environment: "test"},
+		DryRun:         true,
+		Now:            func() time.Time { return start.Add(10 * time.Minute) },
+	}
+
+	// The window is rendered once, although the history records nothing
+	for range 2 {
+		_, err := handler.PushUpdates(context.Background(), events.DynamoDBEvent{})
+		require.NoError(t, err)
+	}
+	require.Len(t, target.received, 1)
+	require.Equal(t, []history.ProgressEntry{baseline}, historyClient.notified["fake"])
+	require.Empty(t, metricsOut.String())
+
+	// A later edit opens a new window
+	later := history.ProgressEntry{Timestamp: start.Add(11 * time.Minute), WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 30}}}
+	historyClient.entries[later.Timestamp.UnixNano()] = later
+	handler.Now = func() time.Time { return start.Add(20 * time.Minute) }
+	_, err := handler.PushUpdates(context.Background(), events.DynamoDBEvent{})
+	require.NoError(t, err)
+	require.Len(t, target.received, 2)
+	require.Equal(t, []progress.ProgressUpdate{{Title: "Book 1", Progress: 30, PrevProgress: 20}}, target.received[1])
+}
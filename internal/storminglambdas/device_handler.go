@@ -0,0 +1,170 @@
+package storminglambdas
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/firebase"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/progress"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+const (
+	devicesPath = "/devices"
+
+	// maxTokenLength is well above the length of FCM registration tokens, which are a few hundred characters
+	maxTokenLength   = 4096
+	maxFollowedWorks = 50
+)
+
+var devicePlatforms = []string{"android", "ios", "web"}
+
+type (
+	// DeviceHandler lets the app register devices for FCM notifications
+	DeviceHandler struct {
+		Devices deviceRegistry
+		// Tokens checks registration tokens with FCM, so that only tokens issued to the app are registered
+		Tokens tokenValidator
+		// Topic, if set, is notified instead of the registered devices, so registrations are refused
+		Topic string
+		// Now returns the current time. Defaults to time.Now.
+		Now func() time.Time
+	}
+
+	deviceRegistry interface {
+		RegisterDevice(ctx context.Context, device firebase.Device) error
+		UnregisterDevices(ctx context.Context, tokens ...string) error
+	}
+
+	tokenValidator interface {
+		ValidateToken(ctx context.Context, token string) error
+	}
+
+	// deviceRequest registers a device with PUT and unregisters it with DELETE. Works may be given by title or ID.
+	deviceRequest struct {
+		Token    string   `json:"token"`
+		Platform string   `json:"platform,omitempty"`
+		Works    []string `json:"works,omitempty"`
+	}
+)
+
+// containerDeviceHandler is the device handler shared by every invocation in the Lambda container
+var containerDeviceHandler = perContainer(NewDeviceHandlerFromContext)
+
+// HandleDeviceRequest answers a device registration request received through the Function URL
+func HandleDeviceRequest(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	ctx = logging.WithLambdaRequest(ctx)
+	handler, err := containerDeviceHandler(ctx)
+	if err != nil {
+		return events.LambdaFunctionURLResponse{}, fmt.Errorf("new device handler from context: %w", err)
+	}
+
+	return handler.HandleDeviceRequest(ctx, req)
+}
+
+// NewDeviceHandlerFromContext creates a new device handler by initializing dependencies from ctx
+func NewDeviceHandlerFromContext(ctx context.Context) (*DeviceHandler, error) {
+	cfg, awsCfg, secrets, err := loadConfig(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets.FirebaseCredentials) == 0 {
+		return nil, errors.New("no firebase credentials to validate registration tokens with")
+	}
+	messagingClient, err := firebase.NewMessagingClientFromJSON(ctx, secrets.FirebaseCredentials)
+	if err != nil {
+		return nil, fmt.Errorf("new firebase messaging client: %w", err)
+	}
+
+	return &DeviceHandler{
+		Devices: firebase.NewDynamoDeviceStore(dynamodb.NewFromConfig(awsCfg), cfg.FCMDevicesTable),
+		Tokens:  firebase.NewTokenValidator(messagingClient),
+		Topic:   cfg.FCMTopic,
+	}, nil
+}
+
+// HandleDeviceRequest registers or unregisters the device in the request
+func (handler *DeviceHandler) HandleDeviceRequest(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	if req.RawPath != devicesPath {
+		return textResponse(http.StatusNotFound, "not found"), nil
+	}
+	method := req.RequestContext.HTTP.Method
+	if method != http.MethodPut && method != http.MethodDelete {
+		return textResponse(http.StatusMethodNotAllowed, "method not allowed"), nil
+	}
+
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return textResponse(http.StatusBadRequest, "invalid body"), nil
+		}
+	}
+	var request deviceRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return textResponse(http.StatusBadRequest, "invalid body"), nil
+	}
+	if request.Token == "" || len(request.Token) > maxTokenLength {
+		return textResponse(http.StatusBadRequest, "invalid token"), nil
+	}
+
+	if method == http.MethodDelete {
+		if err := handler.Devices.UnregisterDevices(ctx, request.Token); err != nil {
+			logging.FromContext(ctx).Error("Failed to unregister device", "error", err)
+			return textResponse(http.StatusInternalServerError, "failed to unregister device"), nil
+		}
+		return events.LambdaFunctionURLResponse{StatusCode: http.StatusNoContent}, nil
+	}
+
+	if handler.Topic != "" {
+		return textResponse(http.StatusConflict, "devices are notified through the FCM topic"), nil
+	}
+	if request.Platform != "" && !slices.Contains(devicePlatforms, request.Platform) {
+		return textResponse(http.StatusBadRequest, "invalid platform"), nil
+	}
+	if len(request.Works) > maxFollowedWorks {
+		return textResponse(http.StatusBadRequest, fmt.Sprintf("too many works, the limit is %d", maxFollowedWorks)), nil
+	}
+	workIDs := []string{}
+	for _, work := range request.Works {
+		if workID := progress.WorkID(work); workID != "" && !slices.Contains(workIDs, workID) {
+			workIDs = append(workIDs, workID)
+		}
+	}
+
+	if err := handler.Tokens.ValidateToken(ctx, request.Token); errors.Is(err, firebase.ErrInvalidToken) {
+		logging.FromContext(ctx).Info("Refused registration token", "error", err)
+		return textResponse(http.StatusBadRequest, "invalid token"), nil
+	} else if err != nil {
+		logging.FromContext(ctx).Error("Failed to validate registration token", "error", err)
+		return textResponse(http.StatusBadGateway, "failed to validate token"), nil
+	}
+
+	if err := handler.Devices.RegisterDevice(ctx, firebase.Device{
+		Token:     request.Token,
+		Platform:  request.Platform,
+		WorkIDs:   workIDs,
+		UpdatedAt: handler.now(),
+	}); err != nil {
+		logging.FromContext(ctx).Error("Failed to register device", "error", err)
+		return textResponse(http.StatusInternalServerError, "failed to register device"), nil
+	}
+	return events.LambdaFunctionURLResponse{StatusCode: http.StatusNoContent}, nil
+}
+
+func (handler *DeviceHandler) now() time.Time {
+	if handler.Now == nil {
+		return time.Now()
+	}
+	return handler.Now()
+}
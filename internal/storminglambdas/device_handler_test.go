@@ -0,0 +1,151 @@
+package storminglambdas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/firebase"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	fakeDeviceRegistry struct {
+		devices map[string]firebase.Device
+	}
+
+	// fakeTokenValidator accepts every token but those with an error
+	fakeTokenValidator map[string]error
+)
+
+func (f *fakeDeviceRegistry) RegisterDevice(ctx context.Context, device firebase.Device) error {
+	f.devices[device.Token] = device
+	return nil
+}
+
+func (f *fakeDeviceRegistry) UnregisterDevices(ctx context.Context, tokens ...string) error {
+	for _, token := range tokens {
+		delete(f.devices, token)
+	}
+	return nil
+}
+
+func (f fakeTokenValidator) ValidateToken(ctx context.Context, token string) error {
+	return f[token]
+}
+
+func newDeviceRequest(method, path, body string) events.LambdaFunctionURLRequest {
+	req := events.LambdaFunctionURLRequest{RawPath: path, Body: body}
+	req.RequestContext.HTTP.Method = method
+	return req
+}
+
+func TestHandleDeviceRequest(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	registry := &fakeDeviceRegistry{devices: map[string]firebase.Device{}}
+	tokens := fakeTokenValidator{
+		"forged": fmt.Errorf("%w: not issued to the app", firebase.ErrInvalidToken),
+		"down":   errors.New("unavailable"),
+	}
+	handler := DeviceHandler{Devices: registry, Tokens: tokens, Now: func() time.Time { return now }}
+
+	testCases := []struct {
+		name           string
+		req            events.LambdaFunctionURLRequest
+		expectedStatus int
+		expectedTokens []string
+	}{
+		{
+			name:           "register",
+			req:            newDeviceRequest(http.MethodPut, "/devices", `{"token":"abc","platform":"ios","works":["Moment Zero 2.0","moment-zero-2-0","white-sand-prose-version"]}`),
+			expectedStatus: http.StatusNoContent,
+			expectedTokens: []string{"abc"},
+		},
+		{
+			name:           "register another",
+			req:            newDeviceRequest(http.MethodPut, "/devices", `{"token":"def"}`),
+			expectedStatus: http.StatusNoContent,
+			expectedTokens: []string{"abc", "def"},
+		},
+		{
+			name:           "unregister",
+			req:            newDeviceRequest(http.MethodDelete, "/devices", `{"token":"def"}`),
+			expectedStatus: http.StatusNoContent,
+			expectedTokens: []string{"abc"},
+		},
+		{
+			name:           "missing token",
+			req:            newDeviceRequest(http.MethodPut, "/devices", `{"platform":"web"}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedTokens: []string{"abc"},
+		},
+		{
+			name:           "unknown platform",
+			req:            newDeviceRequest(http.MethodPut, "/devices", `{"token":"ghi","platform":"palm"}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedTokens: []string{"abc"},
+		},
+		{
+			name:           "token refused by FCM",
+			req:            newDeviceRequest(http.MethodPut, "/devices", `{"token":"forged"}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedTokens: []string{"abc"},
+		},
+		{
+			name:           "FCM unavailable",
+			req:            newDeviceRequest(http.MethodPut, "/devices", `{"token":"down"}`),
+			expectedStatus: http.StatusBadGateway,
+			expectedTokens: []string{"abc"},
+		},
+		{
+			name:           "wrong method",
+			req:            newDeviceRequest(http.MethodGet, "/devices", ""),
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedTokens: []string{"abc"},
+		},
+		{
+			name:           "wrong path",
+			req:            newDeviceRequest(http.MethodPut, "/", `{"token":"ghi"}`),
+			expectedStatus: http.StatusNotFound,
+			expectedTokens: []string{"abc"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := handler.HandleDeviceRequest(context.Background(), tc.req)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedStatus, response.StatusCode)
+
+			tokens := []string{}
+			for token := range registry.devices {
+				tokens = append(tokens, token)
+			}
+			slices.Sort(tokens)
+			require.Equal(t, tc.expectedTokens, tokens)
+		})
+	}
+
+	require.Equal(t, firebase.Device{
+		Token:     "abc",
+		Platform:  "ios",
+		WorkIDs:   []string{"moment-zero-2-0", "white-sand-prose-version"},
+		UpdatedAt: now,
+	}, registry.devices["abc"])
+}
+
+func TestHandleDeviceRequest_Topic(t *testing.T) {
+	registry := &fakeDeviceRegistry{devices: map[string]firebase.Device{}}
+	handler := DeviceHandler{Devices: registry, Tokens: fakeTokenValidator{}, Topic: "progress"}
+
+	// Devices subscribed to the topic would be notified twice if they registered too
+	response, err := handler.HandleDeviceRequest(context.Background(), newDeviceRequest(http.MethodPut, "/devices", `{"token":"abc"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, response.StatusCode)
+	require.Empty(t, registry.devices)
+}
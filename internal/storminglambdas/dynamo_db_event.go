@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/Rhionin/SanderServer/internal/history"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
@@ -31,3 +32,76 @@ func UnmarshalStreamImage[V any](attribute map[string]events.DynamoDBAttributeVa
 	return dynamodbattribute.UnmarshalMap(dbAttrMap, out)
 
 }
+
+// MarshalStreamImage converts a struct to the image a DynamoDB stream record carries for it, the reverse of
+// UnmarshalStreamImage
+func MarshalStreamImage[V any](in V) (map[string]events.DynamoDBAttributeValue, error) {
+	dbAttrMap, err := dynamodbattribute.MarshalMap(in)
+	if err != nil {
+		return nil, fmt.Errorf("marshal item: %w", err)
+	}
+
+	image := make(map[string]events.DynamoDBAttributeValue, len(dbAttrMap))
+	for k, v := range dbAttrMap {
+		image[k] = toStreamAttribute(v)
+	}
+	return image, nil
+}
+
+// NewInsertRecord returns the stream record DynamoDB emits when the history entry is written
+func NewInsertRecord(entry history.ProgressEntry, sequenceNumber string) (events.DynamoDBEventRecord, error) {
+	image, err := MarshalStreamImage(entry.ToDynamoProgressEntry())
+	if err != nil {
+		return events.DynamoDBEventRecord{}, err
+	}
+	return events.DynamoDBEventRecord{
+		EventID:   sequenceNumber,
+		EventName: string(events.DynamoDBOperationTypeInsert),
+		Change: events.DynamoDBStreamRecord{
+			SequenceNumber: sequenceNumber,
+			NewImage:       image,
+			StreamViewType: string(events.DynamoDBStreamViewTypeNewImage),
+		},
+	}, nil
+}
+
+func toStreamAttribute(v *dynamodb.AttributeValue) events.DynamoDBAttributeValue {
+	switch {
+	case v.S != nil:
+		return events.NewStringAttribute(*v.S)
+	case v.N != nil:
+		return events.NewNumberAttribute(*v.N)
+	case v.BOOL != nil:
+		return events.NewBooleanAttribute(*v.BOOL)
+	case v.B != nil:
+		return events.NewBinaryAttribute(v.B)
+	case v.SS != nil:
+		return events.NewStringSetAttribute(derefAll(v.SS))
+	case v.NS != nil:
+		return events.NewNumberSetAttribute(derefAll(v.NS))
+	case v.BS != nil:
+		return events.NewBinarySetAttribute(v.BS)
+	case v.L != nil:
+		list := make([]events.DynamoDBAttributeValue, len(v.L))
+		for i, item := range v.L {
+			list[i] = toStreamAttribute(item)
+		}
+		return events.NewListAttribute(list)
+	case v.M != nil:
+		m := make(map[string]events.DynamoDBAttributeValue, len(v.M))
+		for k, item := range v.M {
+			m[k] = toStreamAttribute(item)
+		}
+		return events.NewMapAttribute(m)
+	default:
+		return events.NewNullAttribute()
+	}
+}
+
+func derefAll(values []*string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = *v
+	}
+	return out
+}
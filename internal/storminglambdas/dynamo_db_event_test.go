@@ -3,6 +3,7 @@ package storminglambdas
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/Rhionin/SanderServer/internal/history"
 	"github.com/Rhionin/SanderServer/internal/progress"
@@ -90,3 +91,29 @@ func TestUnmarshalStreamImage(t *testing.T) {
 	}
 	require.Equal(t, expectedEntry, actualEntry)
 }
+
+func TestNewInsertRecord(t *testing.T) {
+	entry := history.ProgressEntry{
+		Timestamp: time.Unix(0, 12345),
+		WorksInProgress: []progress.WorkInProgress{
+			{Title: "Moment Zero 2.0", Progress: 100},
+			{Title: "White Sand Prewriting (Prose Version)", Progress: 28},
+		},
+		CorrelationID: "check-1",
+	}
+	record, err := NewInsertRecord(entry, "100")
+	require.NoError(t, err)
+	require.Equal(t, string(events.DynamoDBOperationTypeInsert), record.EventName)
+	require.Equal(t, "100", record.Change.SequenceNumber)
+
+	// The record survives the JSON a real stream delivers it as
+	payload, err := json.Marshal(record)
+	require.NoError(t, err)
+	var delivered events.DynamoDBEventRecord
+	require.NoError(t, json.Unmarshal(payload, &delivered))
+
+	var actualEntry history.ProgressDynamoEntry
+	require.NoError(t, UnmarshalStreamImage(delivered.Change.NewImage, &actualEntry))
+	require.True(t, actualEntry.IsProgressEntry())
+	require.Equal(t, entry, actualEntry.ToProgressEntry())
+}
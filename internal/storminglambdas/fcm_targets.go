@@ -0,0 +1,62 @@
+package storminglambdas
+
+import (
+	"context"
+	"fmt"
+
+	"firebase.google.com/go/v4/messaging"
+	appconfig "github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/firebase"
+	"github.com/Rhionin/SanderServer/internal/message"
+)
+
+// newFCMTargets builds the FCM push target: the topic if one is configured, otherwise the registered devices if there is
+// a device store. Never both, as a device subscribed to the topic and registered would be notified twice. Without
+// Firebase credentials there is none, unless it is a dry run.
+func newFCMTargets(ctx context.Context, cfg appconfig.Config, config StormlightArchive, devices *firebase.DynamoDeviceStore, dryRun *dryrun.Writer) ([]PushTarget, error) {
+	if len(config.FirebaseCredentials) == 0 && dryRun == nil {
+		return nil, nil
+	}
+
+	var messagingClient *messaging.Client
+	var err error
+	if len(config.FirebaseCredentials) > 0 {
+		messagingClient, err = firebase.NewMessagingClientFromJSON(ctx, config.FirebaseCredentials)
+		if err != nil {
+			return nil, fmt.Errorf("new firebase messaging client: %w", err)
+		}
+	}
+	messages := message.MustNew("fcm", firebase.DefaultMessages)
+	if messageConfig, ok := config.MessageTemplates["fcm"]; ok {
+		messages, err = message.New("fcm", messageConfig, firebase.DefaultMessages)
+		if err != nil {
+			return nil, fmt.Errorf("load fcm message templates: %w", err)
+		}
+	}
+
+	if cfg.FCMTopic != "" {
+		topicClient := firebase.NewUpdateClient(messagingClient, cfg.FCMTopic)
+		topicClient.Messages = messages
+		topicClient.Link = cfg.StatusPageURL
+		topicClient.DryRun = dryRun
+		target, err := withRules(topicClient, config.FCMNotificationRules)
+		if err != nil {
+			return nil, err
+		}
+		return []PushTarget{target}, nil
+	}
+
+	if devices == nil {
+		return nil, nil
+	}
+	deviceClient := firebase.NewDeviceUpdateClient(messagingClient, devices)
+	deviceClient.Messages = messages
+	deviceClient.Link = cfg.StatusPageURL
+	deviceClient.DryRun = dryRun
+	target, err := withRules(deviceClient, config.FCMNotificationRules)
+	if err != nil {
+		return nil, err
+	}
+	return []PushTarget{target}, nil
+}
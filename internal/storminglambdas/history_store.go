@@ -0,0 +1,40 @@
+package storminglambdas
+
+import (
+	"context"
+	"fmt"
+
+	appconfig "github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/history"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// History kinds, for the tools that run outside of Lambda
+const (
+	HistoryMemory = "memory"
+	HistoryFile   = "file"
+	HistoryDynamo = "dynamo"
+)
+
+// OpenHistory returns the history of the kind, and the subscriber stores that live alongside it. Subscribers are only
+// kept in DynamoDB, so the other kinds have no subscriber stores.
+func OpenHistory(ctx context.Context, cfg appconfig.Config, kind, path string) (history.Store, PushTargetStores, error) {
+	switch kind {
+	case HistoryMemory:
+		return history.NewMemoryStore(), PushTargetStores{}, nil
+	case HistoryFile:
+		store, err := history.NewFileStore(path)
+		return store, PushTargetStores{}, err
+	case HistoryDynamo:
+		awsCfg, err := cfg.AWSConfig(ctx)
+		if err != nil {
+			return nil, PushTargetStores{}, err
+		}
+		dynamoClient := dynamodb.NewFromConfig(awsCfg)
+		store, err := history.NewDynamoClient(dynamoClient, cfg.HistoryTable)
+		return store, NewDynamoPushTargetStores(dynamoClient, cfg), err
+	default:
+		return nil, PushTargetStores{}, fmt.Errorf("unknown history %q", kind)
+	}
+}
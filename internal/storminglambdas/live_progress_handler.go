@@ -0,0 +1,175 @@
+package storminglambdas
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/live"
+	"github.com/Rhionin/SanderServer/internal/logging"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+const (
+	liveEventsPath = "/events"
+	livePollPath   = "/poll"
+
+	// defaultLongPollTimeout is below the 29 second timeout common to HTTP clients and API gateways
+	defaultLongPollTimeout = 25 * time.Second
+	// streamDeadlineMargin leaves time to close the stream cleanly before the Lambda times out
+	streamDeadlineMargin = 5 * time.Second
+)
+
+type (
+	// LiveProgressHandler streams new history entries through a Function URL in RESPONSE_STREAM mode. /events is an
+	// event stream that lasts until the Lambda's deadline, after which browsers reconnect with Last-Event-ID. /poll is
+	// a long-poll fallback for clients that cannot stream.
+	LiveProgressHandler struct {
+		Watcher           *live.Watcher
+		HeartbeatInterval time.Duration
+		LongPollTimeout   time.Duration
+	}
+)
+
+// containerLiveProgressHandler is the live progress handler shared by every invocation in the Lambda container, so
+// that its clients share one poller
+var containerLiveProgressHandler = perContainer(NewLiveProgressHandlerFromContext)
+
+// HandleLiveProgress answers a live progress request received through the Function URL
+func HandleLiveProgress(ctx context.Context, req events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLStreamingResponse, error) {
+	ctx = logging.WithLambdaRequest(ctx)
+	handler, err := containerLiveProgressHandler(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("new live progress handler from context: %w", err)
+	}
+	return handler.HandleLiveProgress(ctx, req)
+}
+
+// NewLiveProgressHandlerFromContext creates a new live progress handler by initializing dependencies from ctx
+func NewLiveProgressHandlerFromContext(ctx context.Context) (*LiveProgressHandler, error) {
+	cfg, awsCfg, _, err := loadConfig(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	historyClient, err := history.NewDynamoClient(dynamodb.NewFromConfig(awsCfg), cfg.HistoryTable)
+	if err != nil {
+		return nil, fmt.Errorf("new dynamo client: %w", err)
+	}
+
+	return &LiveProgressHandler{
+		Watcher:           live.NewWatcher(historyClient),
+		HeartbeatInterval: live.DefaultHeartbeatInterval,
+		LongPollTimeout:   defaultLongPollTimeout,
+	}, nil
+}
+
+// HandleLiveProgress streams events, or waits for one event, depending on the path
+func (handler *LiveProgressHandler) HandleLiveProgress(ctx context.Context, req events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLStreamingResponse, error) {
+	if req.RawPath != liveEventsPath && req.RawPath != livePollPath {
+		return streamingTextResponse(http.StatusNotFound, "not found"), nil
+	}
+	if req.RequestContext.HTTP.Method != http.MethodGet {
+		return streamingTextResponse(http.StatusMethodNotAllowed, "method not allowed"), nil
+	}
+
+	// Browsers send Last-Event-ID when reconnecting. Clients can also resume with ?since=<event ID>.
+	eventID := req.Headers["last-event-id"]
+	if eventID == "" {
+		eventID = req.QueryStringParameters["since"]
+	}
+
+	previous, err := handler.Watcher.Start(ctx, eventID)
+	if errors.Is(err, live.ErrInvalidEventID) {
+		return streamingTextResponse(http.StatusBadRequest, "invalid event id"), nil
+	} else if err != nil {
+		logging.FromContext(ctx).Error("Failed to start watching progress", "error", err)
+		return streamingTextResponse(http.StatusInternalServerError, "failed to get progress"), nil
+	}
+
+	if req.RawPath == liveEventsPath {
+		return handler.streamEvents(ctx, previous), nil
+	}
+	return handler.poll(ctx, previous)
+}
+
+func (handler *LiveProgressHandler) streamEvents(ctx context.Context, previous history.ProgressEntry) *events.LambdaFunctionURLStreamingResponse {
+	reader, writer := io.Pipe()
+	go func() {
+		streamCtx, cancel := withDeadlineMargin(ctx, streamDeadlineMargin)
+		defer cancel()
+		err := handler.Watcher.StreamEvents(streamCtx, writer, previous, handler.HeartbeatInterval, nil)
+		if err != nil {
+			logging.FromContext(ctx).Error("Event stream failed", "error", err)
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type":                "text/event-stream",
+			"Cache-Control":               "no-cache",
+			"Access-Control-Allow-Origin": "*",
+		},
+		Body: reader,
+	}
+}
+
+func (handler *LiveProgressHandler) poll(ctx context.Context, previous history.ProgressEntry) (*events.LambdaFunctionURLStreamingResponse, error) {
+	pollCtx, cancel := withDeadlineMargin(ctx, streamDeadlineMargin)
+	defer cancel()
+	pollCtx, cancelTimeout := context.WithTimeout(pollCtx, handler.LongPollTimeout)
+	defer cancelTimeout()
+
+	event, ok, err := handler.Watcher.Poll(pollCtx, previous)
+	if err != nil {
+		logging.FromContext(ctx).Error("Long poll failed", "error", err)
+		return streamingTextResponse(http.StatusInternalServerError, "failed to get progress"), nil
+	}
+	if !ok {
+		return &events.LambdaFunctionURLStreamingResponse{
+			StatusCode: http.StatusNoContent,
+			Headers:    map[string]string{"Access-Control-Allow-Origin": "*"},
+			Body:       strings.NewReader(""),
+		}, nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type":                "application/json",
+			"Access-Control-Allow-Origin": "*",
+		},
+		Body: strings.NewReader(string(body)),
+	}, nil
+}
+
+// withDeadlineMargin shortens ctx's deadline, if it has one, by margin
+func withDeadlineMargin(ctx context.Context, margin time.Duration) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline.Add(-margin))
+	}
+	return context.WithCancel(ctx)
+}
+
+func streamingTextResponse(statusCode int, body string) *events.LambdaFunctionURLStreamingResponse {
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type": "text/plain",
+		},
+		Body: strings.NewReader(body),
+	}
+}
@@ -0,0 +1,78 @@
+package storminglambdas
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/live"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func newLiveProgressRequest(path string, query map[string]string) events.LambdaFunctionURLRequest {
+	req := events.LambdaFunctionURLRequest{RawPath: path, QueryStringParameters: query}
+	req.RequestContext.HTTP.Method = http.MethodGet
+	return req
+}
+
+func TestHandleLiveProgress_Poll(t *testing.T) {
+	first := time.Unix(0, 1000).UTC()
+	second := time.Unix(0, 2000).UTC()
+	handler := LiveProgressHandler{
+		Watcher: &live.Watcher{
+			History: &fakeHistoryClient{entries: map[int64]history.ProgressEntry{
+				first.UnixNano():  {Timestamp: first, WorksInProgress: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 80}}},
+				second.UnixNano(): {Timestamp: second, WorksInProgress: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 90}}},
+			}},
+			PollInterval: time.Millisecond,
+		},
+		LongPollTimeout: 10 * time.Millisecond,
+	}
+
+	testCases := []struct {
+		name           string
+		req            events.LambdaFunctionURLRequest
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "new entry since",
+			req:            newLiveProgressRequest("/poll", map[string]string{"since": "1000"}),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":"2000","timestamp":"1970-01-01T00:00:00.000002Z","updates":[{"title":"Moment Zero 2.0","progress":90,"prevProgress":80}]}`,
+		},
+		{
+			name:           "nothing new",
+			req:            newLiveProgressRequest("/poll", nil),
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "unknown event",
+			req:            newLiveProgressRequest("/poll", map[string]string{"since": "1500"}),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid event id",
+		},
+		{
+			name:           "unknown path",
+			req:            newLiveProgressRequest("/", nil),
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := handler.HandleLiveProgress(context.Background(), tc.req)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedStatus, response.StatusCode)
+			body, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedBody, string(body))
+		})
+	}
+}
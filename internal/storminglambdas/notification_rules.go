@@ -0,0 +1,228 @@
+package storminglambdas
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+const (
+	quietHoursLayout = "15:04"
+)
+
+type (
+	// NotificationRules decides which progress updates a push target receives. The zero value lets every update through.
+	NotificationRules struct {
+		// WorkIDs limits updates to the given works (see progress.WorkID)
+		WorkIDs []string `json:"workIds,omitempty"`
+		// TitlePatterns limits updates to works whose title matches one of the given regular expressions
+		TitlePatterns []string `json:"titlePatterns,omitempty"`
+		// MinPercentDelta drops updates whose progress moved by less than the given number of percentage points
+		MinPercentDelta int `json:"minPercentDelta,omitempty"`
+		// OnlyCompletions drops updates for works that did not just reach 100%
+		OnlyCompletions bool `json:"onlyCompletions,omitempty"`
+		// SuppressRegressions drops updates for works whose progress went down
+		SuppressRegressions bool `json:"suppressRegressions,omitempty"`
+		// QuietHours drops all updates that occur within the given window. They are dropped for good, not sent once the
+		// window closes.
+		QuietHours *QuietHours `json:"quietHours,omitempty"`
+
+		// titlePatterns are the compiled TitlePatterns, set by Compile
+		titlePatterns []*regexp.Regexp
+	}
+
+	// QuietHours is a daily window, in a time zone, during which no notifications are sent
+	QuietHours struct {
+		Start    string `json:"start"`    // e.g. "22:00"
+		End      string `json:"end"`      // e.g. "07:00"
+		TimeZone string `json:"timeZone"` // IANA name, e.g. "America/Denver". Defaults to UTC.
+
+		// location, startMinute and endMinute are the parsed window, set by compile
+		location    *time.Location
+		startMinute int
+		endMinute   int
+	}
+
+	// RuledPushTarget pairs a push target with the rules that decide which updates it receives
+	RuledPushTarget struct {
+		PushTarget
+		Rules NotificationRules
+	}
+
+	rulesProvider interface {
+		GetNotificationRules() NotificationRules
+	}
+)
+
+// withRules wraps target in its notification rules, if it has any
+func withRules(target PushTarget, rules *NotificationRules) (PushTarget, error) {
+	if rules == nil {
+		return target, nil
+	}
+	ruled, err := NewRuledPushTarget(target, *rules)
+	if err != nil {
+		return nil, err
+	}
+	return ruled, nil
+}
+
+// NewRuledPushTarget wraps target so that it only receives updates allowed by rules. The rules are compiled once, here.
+func NewRuledPushTarget(target PushTarget, rules NotificationRules) (*RuledPushTarget, error) {
+	compiled, err := rules.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("compile notification rules: %w", err)
+	}
+	return &RuledPushTarget{
+		PushTarget: target,
+		Rules:      compiled,
+	}, nil
+}
+
+func (target *RuledPushTarget) GetNotificationRules() NotificationRules {
+	return target.Rules
+}
+
+// Validate reports whether the rules are well-formed
+func (rules NotificationRules) Validate() error {
+	var errs []error
+	for _, pattern := range rules.TitlePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("title pattern %q: %w", pattern, err))
+		}
+	}
+	if rules.MinPercentDelta < 0 || rules.MinPercentDelta > 100 {
+		errs = append(errs, fmt.Errorf("min percent delta must be between 0 and 100, got %d", rules.MinPercentDelta))
+	}
+	if rules.QuietHours != nil {
+		if _, err := rules.QuietHours.compile(); err != nil {
+			errs = append(errs, fmt.Errorf("quiet hours: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Compile validates the rules and returns them ready for Apply
+func (rules NotificationRules) Compile() (NotificationRules, error) {
+	if err := rules.Validate(); err != nil {
+		return NotificationRules{}, err
+	}
+	rules.titlePatterns = make([]*regexp.Regexp, len(rules.TitlePatterns))
+	for i, pattern := range rules.TitlePatterns {
+		rules.titlePatterns[i] = regexp.MustCompile(pattern)
+	}
+	if rules.QuietHours != nil {
+		quietHours, err := rules.QuietHours.compile()
+		if err != nil {
+			return NotificationRules{}, fmt.Errorf("quiet hours: %w", err)
+		}
+		rules.QuietHours = &quietHours
+	}
+	return rules, nil
+}
+
+// Apply returns the updates that pass the compiled rules for a history entry written at timestamp. Rules that filter
+// works drop the works that did not change, and no updates are returned unless one of the works left changed.
+func (rules NotificationRules) Apply(updates []progress.ProgressUpdate, timestamp time.Time) ([]progress.ProgressUpdate, error) {
+	if len(rules.titlePatterns) != len(rules.TitlePatterns) || (rules.QuietHours != nil && rules.QuietHours.location == nil) {
+		return nil, errors.New("notification rules are not compiled")
+	}
+
+	if rules.QuietHours != nil && rules.QuietHours.contains(timestamp) {
+		return []progress.ProgressUpdate{}, nil
+	}
+
+	filtered := []progress.ProgressUpdate{}
+	anyChanged := false
+	for _, update := range updates {
+		unchanged := update.ChangeKind() == progress.ChangeKindUnchanged
+		if unchanged && rules.filtersWorks() {
+			continue
+		}
+		if !rules.matchesWork(update) {
+			continue
+		}
+		delta := update.Progress - update.PrevProgress
+		if delta < 0 && rules.SuppressRegressions {
+			continue
+		}
+		if abs(delta) < rules.MinPercentDelta {
+			continue
+		}
+		if rules.OnlyCompletions && (update.Progress < 100 || update.PrevProgress >= 100) {
+			continue
+		}
+		filtered = append(filtered, update)
+		anyChanged = anyChanged || !unchanged
+	}
+
+	if !anyChanged {
+		return []progress.ProgressUpdate{}, nil
+	}
+	return filtered, nil
+}
+
+// filtersWorks reports whether the rules pick works out of an update, rather than let all of them through
+func (rules NotificationRules) filtersWorks() bool {
+	return len(rules.WorkIDs) > 0 || len(rules.TitlePatterns) > 0 || rules.MinPercentDelta > 0 ||
+		rules.OnlyCompletions || rules.SuppressRegressions
+}
+
+func (rules NotificationRules) matchesWork(update progress.ProgressUpdate) bool {
+	if len(rules.WorkIDs) == 0 && len(rules.titlePatterns) == 0 {
+		return true
+	}
+	if slices.Contains(rules.WorkIDs, update.WorkID()) {
+		return true
+	}
+	for _, pattern := range rules.titlePatterns {
+		if pattern.MatchString(update.Title) {
+			return true
+		}
+	}
+	return false
+}
+
+// compile parses the window and loads its time zone
+func (qh QuietHours) compile() (QuietHours, error) {
+	qh.location = time.UTC
+	if qh.TimeZone != "" {
+		var err error
+		qh.location, err = time.LoadLocation(qh.TimeZone)
+		if err != nil {
+			return QuietHours{}, fmt.Errorf("load time zone: %w", err)
+		}
+	}
+	start, err := time.Parse(quietHoursLayout, qh.Start)
+	if err != nil {
+		return QuietHours{}, fmt.Errorf("parse start: %w", err)
+	}
+	end, err := time.Parse(quietHoursLayout, qh.End)
+	if err != nil {
+		return QuietHours{}, fmt.Errorf("parse end: %w", err)
+	}
+	qh.startMinute = start.Hour()*60 + start.Minute()
+	qh.endMinute = end.Hour()*60 + end.Minute()
+	return qh, nil
+}
+
+// contains reports whether t falls within the compiled quiet hours. Windows that cross midnight (e.g. 22:00-07:00) are
+// supported.
+func (qh QuietHours) contains(t time.Time) bool {
+	local := t.In(qh.location)
+	minuteOfDay := local.Hour()*60 + local.Minute()
+	if qh.startMinute <= qh.endMinute {
+		return minuteOfDay >= qh.startMinute && minuteOfDay < qh.endMinute
+	}
+	return minuteOfDay >= qh.startMinute || minuteOfDay < qh.endMinute
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -0,0 +1,132 @@
+package storminglambdas
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationRules_Apply(t *testing.T) {
+	updates := []progress.ProgressUpdate{
+		{Title: "Moment Zero 2.0", Progress: 100, PrevProgress: 95},
+		{Title: "White Sand (Prose Version)", Progress: 12, PrevProgress: 10},
+		{Title: "Blightfall 3.0 (Skyward Legacy)", Progress: 40, PrevProgress: 55},
+		{Title: "Words of Radiance $650 Signed Edition tier", Progress: 34, PrevProgress: 34},
+	}
+	// 2024-01-02 05:30 UTC is 2024-01-01 22:30 in Denver
+	timestamp := time.Date(2024, 1, 2, 5, 30, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		rules    NotificationRules
+		expected []progress.ProgressUpdate
+	}{
+		{
+			name:     "No rules",
+			rules:    NotificationRules{},
+			expected: updates,
+		},
+		{
+			name:     "Work IDs",
+			rules:    NotificationRules{WorkIDs: []string{"white-sand-prose-version"}},
+			expected: []progress.ProgressUpdate{updates[1]},
+		},
+		{
+			name:     "Work IDs or title patterns",
+			rules:    NotificationRules{WorkIDs: []string{"moment-zero-2-0"}, TitlePatterns: []string{`(?i)skyward`}},
+			expected: []progress.ProgressUpdate{updates[0], updates[2]},
+		},
+		{
+			name:     "Min percent delta",
+			rules:    NotificationRules{MinPercentDelta: 5},
+			expected: []progress.ProgressUpdate{updates[0], updates[2]},
+		},
+		{
+			name:     "Only completions",
+			rules:    NotificationRules{OnlyCompletions: true},
+			expected: []progress.ProgressUpdate{updates[0]},
+		},
+		{
+			name:     "Suppress regressions",
+			rules:    NotificationRules{SuppressRegressions: true},
+			expected: []progress.ProgressUpdate{updates[0], updates[1]},
+		},
+		{
+			name:     "Only unchanged works matched",
+			rules:    NotificationRules{TitlePatterns: []string{`Words of Radiance`}},
+			expected: []progress.ProgressUpdate{},
+		},
+		{
+			name:     "Within quiet hours",
+			rules:    NotificationRules{QuietHours: &QuietHours{Start: "22:00", End: "07:00", TimeZone: "America/Denver"}},
+			expected: []progress.ProgressUpdate{},
+		},
+		{
+			name:     "Outside quiet hours",
+			rules:    NotificationRules{QuietHours: &QuietHours{Start: "12:00", End: "13:00"}},
+			expected: updates,
+		},
+		{
+			name:     "Same-day quiet hours",
+			rules:    NotificationRules{QuietHours: &QuietHours{Start: "05:00", End: "06:00"}},
+			expected: []progress.ProgressUpdate{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := tc.rules.Compile()
+			require.NoError(t, err)
+			actual, err := rules.Apply(updates, timestamp)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestNotificationRules_Validate(t *testing.T) {
+	require.NoError(t, NotificationRules{}.Validate())
+	require.Error(t, NotificationRules{TitlePatterns: []string{"("}}.Validate())
+	require.Error(t, NotificationRules{MinPercentDelta: 101}.Validate())
+	require.Error(t, NotificationRules{QuietHours: &QuietHours{Start: "10pm", End: "07:00"}}.Validate())
+	require.Error(t, NotificationRules{QuietHours: &QuietHours{Start: "22:00", End: "07:00", TimeZone: "Roshar/Urithiru"}}.Validate())
+}
+
+func TestNotificationRules_ApplyUncompiled(t *testing.T) {
+	_, err := NotificationRules{TitlePatterns: []string{"Stormlight"}}.Apply(nil, time.Now())
+	require.ErrorContains(t, err, "not compiled")
+	_, err = NotificationRules{QuietHours: &QuietHours{Start: "22:00", End: "07:00"}}.Apply(nil, time.Now())
+	require.ErrorContains(t, err, "not compiled")
+}
+
+func TestPushUpdates_NotificationRules(t *testing.T) {
+	historyClient := &fakeHistoryClient{
+		entries: map[int64]history.ProgressEntry{
+			100: {
+				Timestamp:       time.Unix(0, 100),
+				WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 10}},
+			},
+		},
+	}
+	everything := &fakePushTarget{name: "everything"}
+	completions := &fakePushTarget{name: "completions"}
+	ruledCompletions, err := NewRuledPushTarget(completions, NotificationRules{OnlyCompletions: true})
+	require.NoError(t, err)
+	handler := PushUpdateHandler{
+		History:     historyClient,
+		PushTargets: []PushTarget{everything, ruledCompletions},
+	}
+
+	response, err := handler.PushUpdates(context.Background(), events.DynamoDBEvent{
+		Records: []events.DynamoDBEventRecord{newInsertRecord("1", 200, "20")},
+	})
+	require.NoError(t, err)
+	require.Empty(t, response.BatchItemFailures)
+	require.Len(t, everything.received, 1)
+	require.Empty(t, completions.received)
+}
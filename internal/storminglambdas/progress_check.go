@@ -0,0 +1,100 @@
+package storminglambdas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/metrics"
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+type (
+	// ProgressChecker scrapes the current progress and records it in the history when it has changed
+	ProgressChecker struct {
+		Checker progressGetter
+		History progressRecorder
+		// Metrics records scrape latency, failures and entries written. Nil discards them.
+		Metrics *metrics.Recorder
+		// Now returns the current time. Defaults to time.Now.
+		Now func() time.Time
+	}
+
+	// ProgressCheckResult is what a check found
+	ProgressCheckResult struct {
+		// Latest is the current progress. It is only written to the history if Changed.
+		Latest history.ProgressEntry
+		// Previous is the latest entry in the history before the check. It is empty if the history was empty.
+		Previous history.ProgressEntry
+		Changed  bool
+	}
+
+	progressGetter interface {
+		GetProgress(ctx context.Context) ([]progress.WorkInProgress, error)
+	}
+
+	progressRecorder interface {
+		GetLatestProgressEntry(ctx context.Context) (history.ProgressEntry, error)
+		AddNewProgressEntry(ctx context.Context, entry history.ProgressEntry) error
+	}
+)
+
+// Check gets the current progress and adds a history entry if it differs from the latest one. The entry carries the
+// context's correlation ID, so that the pushes it causes are logged with it.
+func (checker *ProgressChecker) Check(ctx context.Context) (ProgressCheckResult, error) {
+	logger := logging.FromContext(ctx)
+	start := time.Now()
+	latestProgress, err := checker.Checker.GetProgress(ctx)
+	checker.Metrics.Duration(metrics.ScrapeLatency, time.Since(start))
+	if err != nil {
+		checker.Metrics.Count(metrics.ScrapeFailures, 1)
+		return ProgressCheckResult{}, fmt.Errorf("get progress: %w", err)
+	}
+	// Zeros are recorded too, so that the alarms can tell a healthy check from a missing one
+	checker.Metrics.Count(metrics.ScrapeFailures, 0)
+
+	latestProgressFromHistory, err := checker.History.GetLatestProgressEntry(ctx)
+	if err != nil && !errors.Is(err, history.ErrEmptyHistory) {
+		return ProgressCheckResult{}, fmt.Errorf("get latest progress entry from history: %w", err)
+	}
+	emptyHistory := errors.Is(err, history.ErrEmptyHistory)
+
+	result := ProgressCheckResult{
+		Latest: history.ProgressEntry{
+			Timestamp:       checker.now(),
+			WorksInProgress: latestProgress,
+			CorrelationID:   logging.CorrelationID(ctx),
+		},
+		Previous: latestProgressFromHistory,
+		Changed:  emptyHistory || !reflect.DeepEqual(latestProgressFromHistory.WorksInProgress, latestProgress),
+	}
+	if !result.Changed {
+		logger.Info("No progress change")
+		checker.Metrics.Count(metrics.EntriesWritten, 0)
+		return result, nil
+	}
+
+	if emptyHistory {
+		logger.Info("History does not have any entries yet. Adding new entry", "timestamp", result.Latest.Timestamp)
+	} else {
+		logger.Info("Progress changed. Adding new entry", "timestamp", result.Latest.Timestamp,
+			"current", latestProgress, "previous", latestProgressFromHistory.WorksInProgress)
+	}
+	if err = checker.History.AddNewProgressEntry(ctx, result.Latest); err != nil {
+		return ProgressCheckResult{}, fmt.Errorf("add new history entry: %w", err)
+	}
+	checker.Metrics.Count(metrics.EntriesWritten, 1)
+
+	return result, nil
+}
+
+func (checker *ProgressChecker) now() time.Time {
+	if checker.Now == nil {
+		return time.Now()
+	}
+	return checker.Now()
+}
@@ -0,0 +1,111 @@
+package storminglambdas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/metrics"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProgressGetter struct {
+	wips []progress.WorkInProgress
+	err  error
+}
+
+func (f *fakeProgressGetter) GetProgress(ctx context.Context) ([]progress.WorkInProgress, error) {
+	return f.wips, f.err
+}
+
+func TestProgressChecker_Check(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(100, 0).UTC()
+	getter := &fakeProgressGetter{wips: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 80}}}
+	store := history.NewMemoryStore()
+	checker := ProgressChecker{
+		Checker: getter,
+		History: store,
+		Now:     func() time.Time { return now },
+	}
+
+	// The first check always records an entry
+	result, err := checker.Check(ctx)
+	require.NoError(t, err)
+	require.True(t, result.Changed)
+	require.Equal(t, history.ProgressEntry{}, result.Previous)
+	first := result.Latest
+
+	// Nothing changed, so nothing is recorded
+	now = now.Add(time.Minute)
+	result, err = checker.Check(ctx)
+	require.NoError(t, err)
+	require.False(t, result.Changed)
+	count, err := store.GetEntryCount(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+
+	getter.wips = []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 90}}
+	now = now.Add(time.Minute)
+	result, err = checker.Check(ctx)
+	require.NoError(t, err)
+	require.True(t, result.Changed)
+	require.Equal(t, first, result.Previous)
+	require.Equal(t, history.ProgressEntry{Timestamp: now, WorksInProgress: getter.wips}, result.Latest)
+
+	latest, err := store.GetLatestProgressEntry(ctx)
+	require.NoError(t, err)
+	require.Equal(t, result.Latest, latest)
+}
+
+func TestProgressChecker_CheckMetrics(t *testing.T) {
+	ctx := context.Background()
+	var out bytes.Buffer
+	getter := &fakeProgressGetter{wips: []progress.WorkInProgress{{Title: "Moment Zero 2.0", Progress: 80}}}
+	checker := ProgressChecker{
+		Checker: getter,
+		History: history.NewMemoryStore(),
+		Metrics: &metrics.Recorder{Writer: &out, Environment: "test"},
+	}
+
+	recorded := func() map[string]float64 {
+		values := map[string]float64{}
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var fields map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &fields))
+			for _, name := range []string{metrics.ScrapeLatency, metrics.ScrapeFailures, metrics.EntriesWritten} {
+				if value, ok := fields[name].(float64); ok {
+					values[name] += value
+				}
+			}
+		}
+		out.Reset()
+		return values
+	}
+
+	_, err := checker.Check(ctx)
+	require.NoError(t, err)
+	values := recorded()
+	require.Contains(t, values, metrics.ScrapeLatency)
+	require.Equal(t, 0.0, values[metrics.ScrapeFailures])
+	require.Equal(t, 1.0, values[metrics.EntriesWritten])
+
+	_, err = checker.Check(ctx)
+	require.NoError(t, err)
+	values = recorded()
+	require.Equal(t, 0.0, values[metrics.EntriesWritten])
+
+	getter.err = errors.New("site is down")
+	_, err = checker.Check(ctx)
+	require.Error(t, err)
+	values = recorded()
+	require.Contains(t, values, metrics.ScrapeLatency)
+	require.Equal(t, 1.0, values[metrics.ScrapeFailures])
+	require.NotContains(t, values, metrics.EntriesWritten)
+}
@@ -0,0 +1,104 @@
+package storminglambdas
+
+import (
+	"context"
+	"fmt"
+
+	appconfig "github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/firebase"
+	"github.com/Rhionin/SanderServer/internal/message"
+	"github.com/Rhionin/SanderServer/internal/slack"
+	"github.com/Rhionin/SanderServer/internal/webpush"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+type (
+	// PushTargetStores are where subscribers are kept. Targets whose store is nil are skipped.
+	PushTargetStores struct {
+		SlackSubscriptions   *slack.DynamoSubscriptionStore
+		FCMDevices           *firebase.DynamoDeviceStore
+		WebPushSubscriptions *webpush.DynamoSubscriptionStore
+	}
+)
+
+// NewDynamoPushTargetStores returns the DynamoDB stores for the configured tables
+func NewDynamoPushTargetStores(dynamoClient *dynamodb.Client, cfg appconfig.Config) PushTargetStores {
+	return PushTargetStores{
+		SlackSubscriptions:   slack.NewDynamoSubscriptionStore(dynamoClient, cfg.SlackSubscriptionsTable),
+		FCMDevices:           firebase.NewDynamoDeviceStore(dynamoClient, cfg.FCMDevicesTable),
+		WebPushSubscriptions: webpush.NewDynamoSubscriptionStore(dynamoClient, cfg.WebPushSubscriptionsTable),
+	}
+}
+
+// NewPushTargets builds every push target enabled by the config and secrets, each wrapped in its notification rules if
+// it has any. When the config has a DRY_RUN_OUTPUT, the
+// targets write their requests there instead of sending them, and need no credentials.
+func NewPushTargets(ctx context.Context, cfg appconfig.Config, config StormlightArchive, stores PushTargetStores) ([]PushTarget, error) {
+	var dryRun *dryrun.Writer
+	if cfg.DryRunOutput != "" {
+		var err error
+		dryRun, err = dryrun.Open(cfg.DryRunOutput)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statusPageURL := cfg.StatusPageURL
+	pushTargets, err := newSlackTargets(config, statusPageURL, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("new slack targets: %w", err)
+	}
+
+	if (config.SlackBotToken != "" || dryRun != nil) && stores.SlackSubscriptions != nil {
+		subscriptionClient := slack.NewSubscriptionUpdateClient(slack.NewWebAPIClient(config.SlackBotToken), stores.SlackSubscriptions)
+		subscriptionClient.Locale = config.SlackLocale
+		subscriptionClient.StatusPageURL = statusPageURL
+		subscriptionClient.DryRun = dryRun
+		if messageConfig, ok := config.MessageTemplates["slack"]; ok {
+			subscriptionClient.Messages, err = message.New(subscriptionClient.GetName(), messageConfig, slack.DefaultMessages)
+			if err != nil {
+				return nil, fmt.Errorf("load slack message templates: %w", err)
+			}
+		}
+		target, err := withRules(subscriptionClient, config.SlackSubscriptionNotificationRules)
+		if err != nil {
+			return nil, fmt.Errorf("slack subscriptions: %w", err)
+		}
+		pushTargets = append(pushTargets, target)
+	}
+
+	fcmTargets, err := newFCMTargets(ctx, cfg, config, stores.FCMDevices, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("new fcm targets: %w", err)
+	}
+	pushTargets = append(pushTargets, fcmTargets...)
+
+	if (config.VAPIDPrivateKey != "" || dryRun != nil) && stores.WebPushSubscriptions != nil {
+		var sender *webpush.Client
+		if config.VAPIDPrivateKey != "" {
+			vapidKey, err := webpush.ParseVAPIDKey(config.VAPIDPrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("parse vapid key: %w", err)
+			}
+			sender = webpush.NewClient(vapidKey, config.VAPIDSubject)
+		}
+		webPushClient := webpush.NewUpdateClient(sender, stores.WebPushSubscriptions)
+		webPushClient.StatusPageURL = statusPageURL
+		webPushClient.DryRun = dryRun
+		if messageConfig, ok := config.MessageTemplates["webpush"]; ok {
+			webPushClient.Messages, err = message.New(webPushClient.GetName(), messageConfig, webpush.DefaultMessages)
+			if err != nil {
+				return nil, fmt.Errorf("load webpush message templates: %w", err)
+			}
+		}
+		target, err := withRules(webPushClient, config.WebPushNotificationRules)
+		if err != nil {
+			return nil, fmt.Errorf("webpush: %w", err)
+		}
+		pushTargets = append(pushTargets, target)
+	}
+
+	return pushTargets, nil
+}
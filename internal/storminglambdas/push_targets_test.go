@@ -0,0 +1,56 @@
+package storminglambdas
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	appconfig "github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/firebase"
+	"github.com/Rhionin/SanderServer/internal/slack"
+	"github.com/Rhionin/SanderServer/internal/webpush"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPushTargets_Rules(t *testing.T) {
+	var secrets StormlightArchive
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"SLACK_WEBHOOK_URL": "https://hooks.slack.com/services/default",
+		"SLACK_NOTIFICATION_RULES": {"onlyCompletions": true},
+		"SLACK_SUBSCRIPTION_NOTIFICATION_RULES": {"minPercentDelta": 5},
+		"FCM_NOTIFICATION_RULES": {"titlePatterns": ["Stormlight"]},
+		"WEBPUSH_NOTIFICATION_RULES": {"quietHours": {"start": "22:00", "end": "07:00", "timeZone": "America/Denver"}}
+	}`), &secrets))
+	cfg := appconfig.Config{DryRunOutput: filepath.Join(t.TempDir(), "dryrun.jsonl")}
+	stores := PushTargetStores{
+		SlackSubscriptions:   slack.NewDynamoSubscriptionStore(nil, "subscriptions"),
+		FCMDevices:           firebase.NewDynamoDeviceStore(nil, "devices"),
+		WebPushSubscriptions: webpush.NewDynamoSubscriptionStore(nil, "webpush"),
+	}
+
+	targets, err := NewPushTargets(context.Background(), cfg, secrets, stores)
+	require.NoError(t, err)
+
+	rules := map[string]NotificationRules{}
+	for _, target := range targets {
+		ruled, ok := target.(*RuledPushTarget)
+		require.True(t, ok, "%s has no rules", target.GetName())
+		rules[target.GetName()] = ruled.Rules
+	}
+	require.True(t, rules["slack"].OnlyCompletions)
+	require.Equal(t, 5, rules["slack-subscriptions"].MinPercentDelta)
+	require.Equal(t, []string{"Stormlight"}, rules["fcm-devices"].TitlePatterns)
+	require.Equal(t, "America/Denver", rules["webpush"].QuietHours.TimeZone)
+	require.Len(t, rules, 4)
+}
+
+func TestNewPushTargets_InvalidRules(t *testing.T) {
+	secrets := StormlightArchive{WebPushNotificationRules: &NotificationRules{MinPercentDelta: -1}}
+	cfg := appconfig.Config{DryRunOutput: filepath.Join(t.TempDir(), "dryrun.jsonl")}
+
+	_, err := NewPushTargets(context.Background(), cfg, secrets, PushTargetStores{
+		WebPushSubscriptions: webpush.NewDynamoSubscriptionStore(nil, "webpush"),
+	})
+	require.ErrorContains(t, err, "min percent delta")
+}
@@ -4,30 +4,47 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
-	appconfig "github.com/Rhionin/SanderServer/internal/config"
 	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/metrics"
 	"github.com/Rhionin/SanderServer/internal/progress"
-	"github.com/Rhionin/SanderServer/internal/slack"
+	"github.com/Rhionin/SanderServer/internal/tracing"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
-)
-
-const (
-	secretName = "StormlightArchive"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type (
 	PushUpdateHandler struct {
 		History     historyClient
 		PushTargets []PushTarget
+		// CoalesceWindow merges updates that occur within the window into a single notification. Zero disables coalescing.
+		CoalesceWindow time.Duration
+		// Metrics records pushes sent and failed per target. Nil discards them.
+		Metrics *metrics.Recorder
+		// DryRun leaves the history and metrics untouched, for push targets that render their requests rather than
+		// send them. Notified entries are kept in memory instead. It does not make the targets dry run.
+		DryRun bool
+		// Now returns the current time. Defaults to time.Now.
+		Now func() time.Time
+
+		// dryRunNotified stands in for the notified entries a dry run does not record, so that a coalescing window
+		// is rendered once rather than on every flush
+		dryRunNotified   map[string]history.ProgressEntry
+		dryRunNotifiedMu sync.Mutex
 	}
 
 	historyClient interface {
+		GetLatestProgressEntry(ctx context.Context) (history.ProgressEntry, error)
 		GetLatestProgressEntryBeforeID(ctx context.Context, targetEntry history.ProgressDynamoEntry) (history.ProgressEntry, error)
+		GetEarliestProgressEntryAfter(ctx context.Context, timestamp time.Time) (history.ProgressEntry, error)
+		GetLatestNotifiedEntry(ctx context.Context, target string) (history.ProgressEntry, error)
+		AddNotifiedEntry(ctx context.Context, entry history.ProgressEntry, target string) error
+		DeleteNotifiedEntry(ctx context.Context, entry history.ProgressEntry, target string) error
 	}
 
 	// PushTarget the interface for sending push notifications
@@ -37,11 +54,15 @@ type (
 	}
 )
 
+// containerPushUpdateHandler is the push update handler shared by every invocation in the Lambda container
+var containerPushUpdateHandler = perContainer(NewPushUpdateHandlerFromContext)
+
 // PushUpdates sends notifications when a progress update occurs
-func PushUpdates(ctx context.Context, event events.DynamoDBEvent) error {
-	handler, err := NewPushUpdateHandlerFromContext(ctx)
+func PushUpdates(ctx context.Context, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	ctx = logging.WithLambdaRequest(ctx)
+	handler, err := containerPushUpdateHandler(ctx)
 	if err != nil {
-		return fmt.Errorf("new push update handler from context: %w", err)
+		return events.DynamoDBEventResponse{}, fmt.Errorf("new push update handler from context: %w", err)
 	}
 
 	return handler.PushUpdates(ctx, event)
@@ -49,70 +70,253 @@ func PushUpdates(ctx context.Context, event events.DynamoDBEvent) error {
 
 // NewPushUpdateHandlerFromContext creates a new push update handler by initializing dependencies from ctx
 func NewPushUpdateHandlerFromContext(ctx context.Context) (*PushUpdateHandler, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(appconfig.AWSRegion))
+	cfg, awsCfg, secrets, err := loadConfig(ctx, true)
 	if err != nil {
-		return nil, fmt.Errorf("load default config: %w", err)
+		return nil, err
 	}
 
-	dynamoClient := dynamodb.NewFromConfig(cfg)
-	historyClient, err := history.NewDynamoClient(dynamoClient)
+	dynamoClient := dynamodb.NewFromConfig(awsCfg)
+	historyClient, err := history.NewDynamoClient(dynamoClient, cfg.HistoryTable)
 	if err != nil {
 		return nil, fmt.Errorf("new history dynamo client: %w", err)
 	}
 
-	secretsManager := secretsmanager.NewFromConfig(cfg)
-	stormlightArchiveClient := NewStormlightArchiveClient(secretsManager)
-	config, err := stormlightArchiveClient.GetSecrets(ctx)
+	pushTargets, err := NewPushTargets(ctx, cfg, secrets, NewDynamoPushTargetStores(dynamoClient, cfg))
 	if err != nil {
-		return nil, fmt.Errorf("get stormlight archive: %w", err)
-	}
-
-	slackChannelOverride := "" // Post to the default channel
-	pushTargets := []PushTarget{
-		slack.NewUpdateClient(config.SlackWebhookURL, slackChannelOverride),
+		return nil, fmt.Errorf("new push targets: %w", err)
 	}
 
 	return &PushUpdateHandler{
-		History:     historyClient,
-		PushTargets: pushTargets,
+		History:        historyClient,
+		PushTargets:    pushTargets,
+		CoalesceWindow: cfg.CoalesceWindow.Duration,
+		Metrics:        metrics.New(cfg.Profile),
+		DryRun:         cfg.DryRunOutput != "",
 	}, nil
 }
 
-// PushUpdates sends notifications when a progress update occurs
-func (handler *PushUpdateHandler) PushUpdates(ctx context.Context, event events.DynamoDBEvent) error {
-	if len(event.Records) != 1 {
-		return fmt.Errorf("expected 1 record in event trigger, but got %d", len(event.Records))
+// PushUpdates sends notifications for every record in the stream batch, in order. Processing stops at the first
+// record that fails, and it and every record after it are reported back as batch item failures so that they alone are
+// retried. When coalescing is enabled, records only open a coalescing window and notifications are sent once the
+// window closes; the scheduled invocation with no records exists to flush those windows.
+func (handler *PushUpdateHandler) PushUpdates(ctx context.Context, event events.DynamoDBEvent) (_ events.DynamoDBEventResponse, err error) {
+	ctx, span := tracing.Start(ctx, "PushUpdateHandler.PushUpdates", attribute.Int("stormwatch.records.count", len(event.Records)))
+	defer tracing.End(span, &err)
+
+	response := events.DynamoDBEventResponse{
+		BatchItemFailures: []events.DynamoDBBatchItemFailure{},
+	}
+
+	for i, record := range event.Records {
+		recordCtx := logging.With(ctx, "event_id", record.EventID, "sequence_number", record.Change.SequenceNumber)
+		if err := handler.pushRecordUpdate(recordCtx, record); err != nil {
+			logging.FromContext(recordCtx).Error("Failed to process record", "error", err)
+			// The stream is retried from the first failure, so records after it would be pushed twice. Leave them
+			// to the retry.
+			for _, unprocessed := range event.Records[i:] {
+				response.BatchItemFailures = append(response.BatchItemFailures, events.DynamoDBBatchItemFailure{
+					ItemIdentifier: unprocessed.Change.SequenceNumber,
+				})
+			}
+			break
+		}
+	}
+
+	if handler.CoalesceWindow > 0 {
+		if err := handler.FlushCoalescedUpdates(ctx); err != nil {
+			return response, fmt.Errorf("flush coalesced updates: %w", err)
+		}
 	}
 
-	latestUpdate := event.Records[0]
-	if latestUpdate.EventName != "INSERT" {
-		fmt.Printf("Not processing %q operation\n", latestUpdate.EventName)
+	return response, nil
+}
+
+// pushRecordUpdate sends notifications for a single stream record
+func (handler *PushUpdateHandler) pushRecordUpdate(ctx context.Context, record events.DynamoDBEventRecord) error {
+	switch events.DynamoDBOperationType(record.EventName) {
+	case events.DynamoDBOperationTypeInsert:
+		// Handled below
+	case events.DynamoDBOperationTypeModify, events.DynamoDBOperationTypeRemove:
+		logging.FromContext(ctx).Debug("Ignoring operation", "operation", record.EventName)
 		return nil
+	default:
+		return fmt.Errorf("unrecognized operation %q", record.EventName)
 	}
 
 	var latestHistoryEntry history.ProgressDynamoEntry
-	if err := UnmarshalStreamImage(latestUpdate.Change.NewImage, &latestHistoryEntry); err != nil {
+	if err := UnmarshalStreamImage(record.Change.NewImage, &latestHistoryEntry); err != nil {
 		return fmt.Errorf("unmarshal stream image: %w", err)
 	}
+	if !latestHistoryEntry.IsProgressEntry() {
+		logging.FromContext(ctx).Debug("Ignoring entry", "id", latestHistoryEntry.ID)
+		return nil
+	}
+	if latestHistoryEntry.Historical {
+		logging.FromContext(ctx).Debug("Ignoring historical entry", "timestamp", latestHistoryEntry.TimestampUnixNano)
+		return nil
+	}
+	latest := latestHistoryEntry.ToProgressEntry()
+	ctx = withEntryCorrelationID(ctx, latest)
 
 	penultimateUpdate, err := handler.History.GetLatestProgressEntryBeforeID(ctx, latestHistoryEntry)
-	if err != nil && !errors.Is(err, history.ErrEmptyHistory) {
+	if errors.Is(err, history.ErrNoEntryBeforeTarget) {
+		logging.FromContext(ctx).Info("This appears to be the first history entry. No updates to push.")
+		return nil
+	} else if err != nil {
 		return fmt.Errorf("get penultimate progress update entry: %w", err)
-	} else if errors.Is(err, history.ErrEmptyHistory) {
-		return history.ErrEmptyHistory
-	} else if errors.Is(err, history.ErrNoEntryBeforeTarget) {
-		fmt.Println("This appears to be the first history entry. No updates to push.")
+	}
+
+	return handler.PushEntry(ctx, latest, penultimateUpdate)
+}
+
+// PushEntry sends notifications for what changed between the previous entry and the latest one. When coalescing is
+// enabled, nothing is sent until FlushCoalescedUpdates finds the window closed.
+func (handler *PushUpdateHandler) PushEntry(ctx context.Context, latest, previous history.ProgressEntry) error {
+	ctx = withEntryCorrelationID(ctx, latest)
+	if handler.CoalesceWindow > 0 {
+		logging.FromContext(ctx).Info("Coalescing entry", "timestamp", latest.Timestamp)
 		return nil
 	}
-	updates := progress.GetProgressUpdate(latestHistoryEntry.WorksInProgress, penultimateUpdate.WorksInProgress)
 
+	updates := progress.GetProgressUpdate(latest.WorksInProgress, previous.WorksInProgress)
+	return handler.sendUpdates(ctx, latest, updates)
+}
+
+// sendUpdates delivers the entry's updates to every push target, and returns the errors of those it could not deliver
+// to. Targets already notified of the entry, on a retry of its record, are skipped.
+func (handler *PushUpdateHandler) sendUpdates(ctx context.Context, latest history.ProgressEntry, updates []progress.ProgressUpdate) error {
+	var errs []error
 	for _, target := range handler.PushTargets {
-		targetName := target.GetName()
+		if err := handler.deliver(ctx, target, latest, updates); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deliver claims the target's notification of the entry with a conditional write and then sends it the updates. A
+// failed send releases the claim, so that a retry sends to that target alone.
+func (handler *PushUpdateHandler) deliver(ctx context.Context, target PushTarget, entry history.ProgressEntry, updates []progress.ProgressUpdate) error {
+	targetName := target.GetName()
+	if err := handler.addNotifiedEntry(ctx, entry, targetName); errors.Is(err, history.ErrAlreadyNotified) {
+		logging.FromContext(ctx).Info("The target has already been notified of the entry", "target", targetName)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("(%s) add notified entry: %w", targetName, err)
+	}
+
+	if err := handler.sendTargetUpdates(ctx, target, updates, entry.Timestamp); err != nil {
+		if releaseErr := handler.deleteNotifiedEntry(ctx, entry, targetName); releaseErr != nil {
+			return errors.Join(err, fmt.Errorf("(%s) delete notified entry: %w", targetName, releaseErr))
+		}
+		return err
+	}
+	return nil
+}
+
+// sendTargetUpdates sends the updates that the target's notification rules let through
+func (handler *PushUpdateHandler) sendTargetUpdates(ctx context.Context, target PushTarget, updates []progress.ProgressUpdate, entryTimestamp time.Time) (err error) {
+	targetName := target.GetName()
+	ctx = logging.With(ctx, "target", targetName)
+	ctx = progress.WithUpdateTime(ctx, entryTimestamp)
+	ctx, span := tracing.Start(ctx, "PushTarget.SendUpdate",
+		tracing.TargetKey.String(targetName),
+		tracing.CorrelationIDKey.String(logging.CorrelationID(ctx)),
+	)
+	defer tracing.End(span, &err)
+
+	if ruled, ok := target.(rulesProvider); ok {
+		updates, err = ruled.GetNotificationRules().Apply(updates, entryTimestamp)
+		if err != nil {
+			return fmt.Errorf("(%s) apply notification rules: %w", targetName, err)
+		}
+		if len(updates) == 0 {
+			logging.FromContext(ctx).Info("No updates left after applying notification rules")
+			return nil
+		}
+	}
+	span.SetAttributes(tracing.UpdatesCountKey.Int(len(updates)))
+
+	if handler.DryRun {
 		if err := target.SendUpdate(ctx, updates); err != nil {
-			return fmt.Errorf("(%s) send update: %w", targetName, err)
+			return fmt.Errorf("(%s) render update: %w", targetName, err)
 		}
-		fmt.Println("Update sent via", targetName)
+		logging.FromContext(ctx).Info("Update rendered for dry run", "updates", len(updates))
+		return nil
 	}
 
+	targetDimension := metrics.Dimension{Name: metrics.TargetDimension, Value: targetName}
+	if err := target.SendUpdate(ctx, updates); err != nil {
+		handler.Metrics.Count(metrics.PushesFailed, 1, targetDimension)
+		return fmt.Errorf("(%s) send update: %w", targetName, err)
+	}
+	handler.Metrics.Count(metrics.PushesSent, 1, targetDimension)
+	logging.FromContext(ctx).Info("Update sent", "updates", len(updates))
 	return nil
 }
+
+// withEntryCorrelationID tags the context's logs with the correlation ID of the check that wrote the entry. Entries
+// without one keep the context's correlation ID, if it has one.
+func withEntryCorrelationID(ctx context.Context, entry history.ProgressEntry) context.Context {
+	if entry.CorrelationID == "" || entry.CorrelationID == logging.CorrelationID(ctx) {
+		return ctx
+	}
+	return logging.WithCorrelationID(ctx, entry.CorrelationID)
+}
+
+// getLatestNotifiedEntry returns the entry last notified to the target. A dry run also sees the entries it has
+// rendered.
+func (handler *PushUpdateHandler) getLatestNotifiedEntry(ctx context.Context, target string) (history.ProgressEntry, error) {
+	notified, err := handler.History.GetLatestNotifiedEntry(ctx, target)
+	if !handler.DryRun || (err != nil && !errors.Is(err, history.ErrNoNotifiedEntry)) {
+		return notified, err
+	}
+
+	handler.dryRunNotifiedMu.Lock()
+	defer handler.dryRunNotifiedMu.Unlock()
+	if rendered, ok := handler.dryRunNotified[target]; ok && (err != nil || rendered.Timestamp.After(notified.Timestamp)) {
+		return rendered, nil
+	}
+	return notified, err
+}
+
+// addNotifiedEntry records the entry as notified to the target. A dry run records it in memory rather than in the
+// history.
+func (handler *PushUpdateHandler) addNotifiedEntry(ctx context.Context, entry history.ProgressEntry, target string) error {
+	if !handler.DryRun {
+		return handler.History.AddNotifiedEntry(ctx, entry, target)
+	}
+
+	handler.dryRunNotifiedMu.Lock()
+	defer handler.dryRunNotifiedMu.Unlock()
+	if rendered, ok := handler.dryRunNotified[target]; ok && rendered.Timestamp.Equal(entry.Timestamp) {
+		return history.ErrAlreadyNotified
+	}
+	if handler.dryRunNotified == nil {
+		handler.dryRunNotified = map[string]history.ProgressEntry{}
+	}
+	handler.dryRunNotified[target] = entry
+	logging.FromContext(ctx).Info("Dry run, recording the notified entry in memory", "timestamp", entry.Timestamp, "target", target)
+	return nil
+}
+
+// deleteNotifiedEntry releases the entry's claim on the target
+func (handler *PushUpdateHandler) deleteNotifiedEntry(ctx context.Context, entry history.ProgressEntry, target string) error {
+	if !handler.DryRun {
+		return handler.History.DeleteNotifiedEntry(ctx, entry, target)
+	}
+
+	handler.dryRunNotifiedMu.Lock()
+	defer handler.dryRunNotifiedMu.Unlock()
+	if rendered, ok := handler.dryRunNotified[target]; ok && rendered.Timestamp.Equal(entry.Timestamp) {
+		delete(handler.dryRunNotified, target)
+	}
+	return nil
+}
+
+func (handler *PushUpdateHandler) now() time.Time {
+	if handler.Now == nil {
+		return time.Now()
+	}
+	return handler.Now()
+}
@@ -0,0 +1,356 @@
+package storminglambdas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/tracing"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type (
+	fakeHistoryClient struct {
+		entries  map[int64]history.ProgressEntry
+		notified map[string][]history.ProgressEntry
+	}
+
+	fakePushTarget struct {
+		name           string
+		err            error
+		received       [][]progress.ProgressUpdate
+		correlationIDs []string
+	}
+)
+
+func (f *fakeHistoryClient) GetLatestProgressEntry(ctx context.Context) (history.ProgressEntry, error) {
+	if len(f.entries) == 0 {
+		return history.ProgressEntry{}, history.ErrEmptyHistory
+	}
+	return f.GetLatestProgressEntryBeforeID(ctx, history.ProgressDynamoEntry{TimestampUnixNano: math.MaxInt64})
+}
+
+func (f *fakeHistoryClient) GetLatestProgressEntryBeforeID(ctx context.Context, targetEntry history.ProgressDynamoEntry) (history.ProgressEntry, error) {
+	var latest history.ProgressEntry
+	found := false
+	for ts, entry := range f.entries {
+		if ts < targetEntry.TimestampUnixNano && (!found || entry.Timestamp.After(latest.Timestamp)) {
+			latest = entry
+			found = true
+		}
+	}
+	if !found {
+		return history.ProgressEntry{}, history.ErrNoEntryBeforeTarget
+	}
+	return latest, nil
+}
+
+func (f *fakeHistoryClient) GetEarliestProgressEntryAfter(ctx context.Context, timestamp time.Time) (history.ProgressEntry, error) {
+	var earliest history.ProgressEntry
+	found := false
+	for _, entry := range f.entries {
+		if entry.Timestamp.After(timestamp) && (!found || entry.Timestamp.Before(earliest.Timestamp)) {
+			earliest = entry
+			found = true
+		}
+	}
+	if !found {
+		return history.ProgressEntry{}, history.ErrNoEntryAfterTarget
+	}
+	return earliest, nil
+}
+
+func (f *fakeHistoryClient) GetLatestNotifiedEntry(ctx context.Context, target string) (history.ProgressEntry, error) {
+	notified := f.notified[target]
+	if len(notified) == 0 {
+		return history.ProgressEntry{}, history.ErrNoNotifiedEntry
+	}
+	return notified[len(notified)-1], nil
+}
+
+func (f *fakeHistoryClient) AddNotifiedEntry(ctx context.Context, entry history.ProgressEntry, target string) error {
+	for _, notified := range f.notified[target] {
+		if notified.Timestamp.Equal(entry.Timestamp) {
+			return history.ErrAlreadyNotified
+		}
+	}
+	if f.notified == nil {
+		f.notified = map[string][]history.ProgressEntry{}
+	}
+	f.notified[target] = append(f.notified[target], entry)
+	return nil
+}
+
+func (f *fakeHistoryClient) DeleteNotifiedEntry(ctx context.Context, entry history.ProgressEntry, target string) error {
+	f.notified[target] = slices.DeleteFunc(f.notified[target], func(notified history.ProgressEntry) bool {
+		return notified.Timestamp.Equal(entry.Timestamp)
+	})
+	return nil
+}
+
+func (f *fakePushTarget) GetName() string {
+	return f.name
+}
+
+func (f *fakePushTarget) SendUpdate(ctx context.Context, updates []progress.ProgressUpdate) error {
+	f.correlationIDs = append(f.correlationIDs, logging.CorrelationID(ctx))
+	if f.err != nil {
+		return f.err
+	}
+	f.received = append(f.received, updates)
+	return nil
+}
+
+func newInsertRecord(sequenceNumber string, timestampUnixNano int64, progressValue string) events.DynamoDBEventRecord {
+	return events.DynamoDBEventRecord{
+		EventName: string(events.DynamoDBOperationTypeInsert),
+		Change: events.DynamoDBStreamRecord{
+			SequenceNumber: sequenceNumber,
+			NewImage: map[string]events.DynamoDBAttributeValue{
+				"ID":                events.NewStringAttribute("latest_entry"),
+				"TimestampUnixNano": events.NewNumberAttribute(strconv.FormatInt(timestampUnixNano, 10)),
+				"WorksInProgress": events.NewListAttribute([]events.DynamoDBAttributeValue{
+					events.NewMapAttribute(map[string]events.DynamoDBAttributeValue{
+						"Title":    events.NewStringAttribute("Book 1"),
+						"Progress": events.NewNumberAttribute(progressValue),
+					}),
+				}),
+			},
+		},
+	}
+}
+
+func TestPushUpdates(t *testing.T) {
+	ctx := context.Background()
+	newHistoryClient := func() *fakeHistoryClient {
+		return &fakeHistoryClient{
+			entries: map[int64]history.ProgressEntry{
+				100: {
+					Timestamp:       time.Unix(0, 100),
+					WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 10}},
+				},
+				200: {
+					Timestamp:       time.Unix(0, 200),
+					WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 20}},
+				},
+			},
+		}
+	}
+
+	t.Run("processes every record in order", func(t *testing.T) {
+		target := &fakePushTarget{name: "fake"}
+		handler := PushUpdateHandler{History: newHistoryClient(), PushTargets: []PushTarget{target}}
+
+		response, err := handler.PushUpdates(ctx, events.DynamoDBEvent{
+			Records: []events.DynamoDBEventRecord{
+				newInsertRecord("1", 200, "20"),
+				newInsertRecord("2", 300, "30"),
+			},
+		})
+		require.NoError(t, err)
+		require.Empty(t, response.BatchItemFailures)
+		require.Equal(t, [][]progress.ProgressUpdate{
+			{{Title: "Book 1", Progress: 20, PrevProgress: 10}},
+			{{Title: "Book 1", Progress: 30, PrevProgress: 20}},
+		}, target.received)
+	})
+
+	t.Run("ignores modify and remove records", func(t *testing.T) {
+		target := &fakePushTarget{name: "fake"}
+		handler := PushUpdateHandler{History: newHistoryClient(), PushTargets: []PushTarget{target}}
+
+		modify := newInsertRecord("1", 200, "20")
+		modify.EventName = string(events.DynamoDBOperationTypeModify)
+		remove := newInsertRecord("2", 200, "20")
+		remove.EventName = string(events.DynamoDBOperationTypeRemove)
+
+		response, err := handler.PushUpdates(ctx, events.DynamoDBEvent{
+			Records: []events.DynamoDBEventRecord{modify, remove},
+		})
+		require.NoError(t, err)
+		require.Empty(t, response.BatchItemFailures)
+		require.Empty(t, target.received)
+	})
+
+	t.Run("skips the first history entry", func(t *testing.T) {
+		target := &fakePushTarget{name: "fake"}
+		handler := PushUpdateHandler{History: newHistoryClient(), PushTargets: []PushTarget{target}}
+
+		response, err := handler.PushUpdates(ctx, events.DynamoDBEvent{
+			Records: []events.DynamoDBEventRecord{newInsertRecord("1", 100, "10")},
+		})
+		require.NoError(t, err)
+		require.Empty(t, response.BatchItemFailures)
+		require.Empty(t, target.received)
+	})
+
+	t.Run("reports failed records", func(t *testing.T) {
+		target := &fakePushTarget{name: "fake", err: errors.New("boom")}
+		handler := PushUpdateHandler{History: newHistoryClient(), PushTargets: []PushTarget{target}}
+
+		response, err := handler.PushUpdates(ctx, events.DynamoDBEvent{
+			Records: []events.DynamoDBEventRecord{
+				newInsertRecord("1", 100, "10"),
+				newInsertRecord("2", 200, "20"),
+				newInsertRecord("3", 300, "30"),
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []events.DynamoDBBatchItemFailure{
+			{ItemIdentifier: "2"},
+			{ItemIdentifier: "3"},
+		}, response.BatchItemFailures)
+		require.Len(t, target.correlationIDs, 1, "records after the failure are left to the retry")
+	})
+
+	t.Run("retries only the targets that failed", func(t *testing.T) {
+		sent := &fakePushTarget{name: "sent"}
+		failed := &fakePushTarget{name: "failed", err: errors.New("boom")}
+		after := &fakePushTarget{name: "after"}
+		handler := PushUpdateHandler{History: newHistoryClient(), PushTargets: []PushTarget{sent, failed, after}}
+		event := events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{newInsertRecord("1", 200, "20")}}
+
+		response, err := handler.PushUpdates(ctx, event)
+		require.NoError(t, err)
+		require.Equal(t, []events.DynamoDBBatchItemFailure{{ItemIdentifier: "1"}}, response.BatchItemFailures)
+		require.Len(t, sent.received, 1)
+		require.Len(t, after.received, 1, "targets after the failed one are still sent to")
+
+		failed.err = nil
+		response, err = handler.PushUpdates(ctx, event)
+		require.NoError(t, err)
+		require.Empty(t, response.BatchItemFailures)
+		require.Len(t, sent.received, 1)
+		require.Len(t, failed.received, 1)
+		require.Len(t, after.received, 1)
+	})
+
+	t.Run("reports unrecognized operations", func(t *testing.T) {
+		handler := PushUpdateHandler{History: newHistoryClient(), PushTargets: []PushTarget{&fakePushTarget{name: "fake"}}}
+		unknown := newInsertRecord("3", 300, "30")
+		unknown.EventName = "UNKNOWN"
+
+		response, err := handler.PushUpdates(ctx, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{unknown}})
+		require.NoError(t, err)
+		require.Equal(t, []events.DynamoDBBatchItemFailure{{ItemIdentifier: "3"}}, response.BatchItemFailures)
+	})
+}
+
+func TestPushUpdates_CorrelationID(t *testing.T) {
+	var logs bytes.Buffer
+	ctx := logging.WithLogger(context.Background(), logging.New(&logs, slog.LevelDebug))
+	target := &fakePushTarget{name: "fake"}
+	handler := PushUpdateHandler{
+		History: &fakeHistoryClient{entries: map[int64]history.ProgressEntry{
+			100: {Timestamp: time.Unix(0, 100), WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 10}}},
+		}},
+		PushTargets: []PushTarget{target},
+	}
+
+	record := newInsertRecord("1", 200, "20")
+	record.Change.NewImage["CorrelationID"] = events.NewStringAttribute("check-1")
+	response, err := handler.PushUpdates(ctx, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{record}})
+	require.NoError(t, err)
+	require.Empty(t, response.BatchItemFailures)
+	require.Equal(t, []string{"check-1"}, target.correlationIDs)
+
+	// Every line logged for the entry carries the check's correlation ID
+	lines := bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n"))
+	require.NotEmpty(t, lines)
+	for _, line := range lines {
+		var fields map[string]any
+		require.NoError(t, json.Unmarshal(line, &fields))
+		require.Equal(t, "check-1", fields[logging.CorrelationIDKey], string(line))
+	}
+}
+
+func TestPushUpdates_Historical(t *testing.T) {
+	ctx := context.Background()
+	newHandler := func(target *fakePushTarget, coalesceWindow time.Duration) PushUpdateHandler {
+		return PushUpdateHandler{
+			History: &fakeHistoryClient{
+				entries: map[int64]history.ProgressEntry{
+					100: {Timestamp: time.Unix(0, 100), WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 10}}},
+					200: {Timestamp: time.Unix(0, 200), WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 20}}, Historical: true},
+				},
+				notified: map[string][]history.ProgressEntry{},
+			},
+			PushTargets:    []PushTarget{target},
+			CoalesceWindow: coalesceWindow,
+			Now:            func() time.Time { return time.Unix(0, 200).Add(time.Hour) },
+		}
+	}
+
+	t.Run("stream insert", func(t *testing.T) {
+		target := &fakePushTarget{name: "fake"}
+		handler := newHandler(target, 0)
+		record := newInsertRecord("1", 200, "20")
+		record.Change.NewImage["Historical"] = events.NewBooleanAttribute(true)
+		response, err := handler.PushUpdates(ctx, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{record}})
+		require.NoError(t, err)
+		require.Empty(t, response.BatchItemFailures)
+		require.Empty(t, target.received)
+	})
+
+	t.Run("coalescing flush", func(t *testing.T) {
+		target := &fakePushTarget{name: "fake"}
+		handler := newHandler(target, time.Minute)
+		_, err := handler.PushUpdates(ctx, events.DynamoDBEvent{})
+		require.NoError(t, err)
+		require.Empty(t, target.received)
+	})
+}
+
+func TestPushUpdates_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	handler := PushUpdateHandler{
+		History: &fakeHistoryClient{entries: map[int64]history.ProgressEntry{
+			100: {Timestamp: time.Unix(0, 100), WorksInProgress: []progress.WorkInProgress{{Title: "Book 1", Progress: 10}}},
+		}},
+		PushTargets: []PushTarget{&fakePushTarget{name: "sent"}, &fakePushTarget{name: "failed", err: errors.New("boom")}},
+	}
+	_, err := handler.PushUpdates(context.Background(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{newInsertRecord("1", 200, "20")}})
+	require.NoError(t, err)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		name := span.Name()
+		for _, attr := range span.Attributes() {
+			if attr.Key == tracing.TargetKey {
+				name += "/" + attr.Value.AsString()
+			}
+		}
+		spans[name] = span
+	}
+	root := spans["PushUpdateHandler.PushUpdates"]
+	require.NotNil(t, root)
+	require.Contains(t, root.Attributes(), attribute.Int("stormwatch.records.count", 1))
+	for _, target := range []string{"sent", "failed"} {
+		span := spans["PushTarget.SendUpdate/"+target]
+		require.NotNil(t, span, target)
+		require.Equal(t, root.SpanContext().TraceID(), span.SpanContext().TraceID())
+		require.Contains(t, span.Attributes(), tracing.UpdatesCountKey.Int(1))
+	}
+	require.Equal(t, codes.Unset, spans["PushTarget.SendUpdate/sent"].Status().Code)
+	require.Equal(t, codes.Error, spans["PushTarget.SendUpdate/failed"].Status().Code)
+}
@@ -5,50 +5,73 @@ import (
 	"encoding/json"
 	"fmt"
 
+	appconfig "github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/message"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 )
 
 type (
-	StormlightArchiveClient struct {
-		SecretsManager awsSecretsManager
-	}
-
 	StormlightArchive struct {
-		SlackWebhookURL string `json:"SLACK_WEBHOOK_URL"`
-	}
-
-	awsSecretsManager interface {
-		GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+		// SlackWebhookURL, SlackNotificationRules and SlackLocale configure the original, default Slack destination.
+		// Additional workspaces and channels go in SlackDestinations.
+		SlackWebhookURL        string             `json:"SLACK_WEBHOOK_URL"`
+		SlackNotificationRules *NotificationRules `json:"SLACK_NOTIFICATION_RULES,omitempty"`
+		SlackLocale            string             `json:"SLACK_LOCALE,omitempty"`
+		SlackDestinations      []SlackDestination `json:"SLACK_DESTINATIONS,omitempty"`
+		// SlackBotToken and SlackSigningSecret enable the Slack app: slash commands and per-channel subscriptions
+		SlackBotToken      string `json:"SLACK_BOT_TOKEN,omitempty"`
+		SlackSigningSecret string `json:"SLACK_SIGNING_SECRET,omitempty"`
+		// SlackSubscriptionNotificationRules decides which updates are posted to subscribed channels
+		SlackSubscriptionNotificationRules *NotificationRules `json:"SLACK_SUBSCRIPTION_NOTIFICATION_RULES,omitempty"`
+		// FirebaseCredentials is the Firebase service account key. It enables FCM notifications to registered devices,
+		// and to the configured FCM topic if there is one.
+		FirebaseCredentials json.RawMessage `json:"FIREBASE_CREDENTIALS,omitempty"`
+		// FCMNotificationRules decides which updates are sent over FCM
+		FCMNotificationRules *NotificationRules `json:"FCM_NOTIFICATION_RULES,omitempty"`
+		// VAPIDPrivateKey enables web push notifications for status page visitors. VAPIDSubject is a mailto: or https:
+		// URL push services can use to contact us.
+		VAPIDPrivateKey string `json:"VAPID_PRIVATE_KEY,omitempty"`
+		VAPIDSubject    string `json:"VAPID_SUBJECT,omitempty"`
+		// WebPushNotificationRules decides which updates are sent to status page visitors
+		WebPushNotificationRules *NotificationRules `json:"WEBPUSH_NOTIFICATION_RULES,omitempty"`
+		// MessageTemplates overrides the notification templates, keyed by push target name
+		MessageTemplates map[string]message.Config `json:"MESSAGE_TEMPLATES,omitempty"`
 	}
 )
 
-func NewStormlightArchiveClient(secretsManager awsSecretsManager) *StormlightArchiveClient {
-
-	return &StormlightArchiveClient{
-		SecretsManager: secretsManager,
+// loadConfig loads the config for the Lambda's environment, and the AWS config for its region. With loadSecrets, the
+// secret is loaded from Secrets Manager and decoded too.
+func loadConfig(ctx context.Context, loadSecrets bool) (appconfig.Config, aws.Config, StormlightArchive, error) {
+	cfg, err := appconfig.Load(ctx, appconfig.LoadOptions{LoadSecret: loadSecrets})
+	if err != nil {
+		return appconfig.Config{}, aws.Config{}, StormlightArchive{}, fmt.Errorf("load config: %w", err)
 	}
-}
-
-func (client *StormlightArchiveClient) GetSecrets(ctx context.Context) (StormlightArchive, error) {
-	result, err := client.SecretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretName),
-		VersionStage: aws.String("AWSCURRENT"), // VersionStage defaults to AWSCURRENT if unspecified
-	})
+	if err := logging.SetLevel(cfg.LogLevel); err != nil {
+		return appconfig.Config{}, aws.Config{}, StormlightArchive{}, fmt.Errorf("set log level: %w", err)
+	}
+	awsCfg, err := cfg.AWSConfig(ctx)
 	if err != nil {
-		// For a list of exceptions thrown, see
-		// https://docs.aws.amazon.com/secretsmanager/latest/apireference/API_GetSecretValue.html
-		return StormlightArchive{}, fmt.Errorf("get secrets: %w", err)
+		return appconfig.Config{}, aws.Config{}, StormlightArchive{}, err
 	}
 
-	// Decrypts secret using the associated KMS key.
-	// The value is this string is the json-encoded object found at https://us-west-2.console.aws.amazon.com/secretsmanager/secret?name=StormlightArchive&region=us-west-2
-	var secretString string = *result.SecretString
+	var secrets StormlightArchive
+	if loadSecrets {
+		secrets, err = ParseStormlightArchive(cfg.Secret)
+		if err != nil {
+			return appconfig.Config{}, aws.Config{}, StormlightArchive{}, err
+		}
+	}
+	return cfg, awsCfg, secrets, nil
+}
 
+// ParseStormlightArchive decodes the secret. Config keys in the secret, such as PROGRESS_URL, are ignored here; they
+// are loaded into the config instead.
+func ParseStormlightArchive(secret []byte) (StormlightArchive, error) {
 	var secrets StormlightArchive
-	if err = json.Unmarshal([]byte(secretString), &secrets); err != nil {
+	if err := json.Unmarshal(secret, &secrets); err != nil {
 		return StormlightArchive{}, fmt.Errorf("unmarshal secrets: %w", err)
 	}
-
 	return secrets, nil
 }
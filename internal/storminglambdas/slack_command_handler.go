@@ -0,0 +1,319 @@
+package storminglambdas
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/slack"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+const (
+	slackCommandUsage = "Usage: `/stormwatch status`, `/stormwatch history <work>`, `/stormwatch subscribe <work>`, " +
+		"`/stormwatch unsubscribe <work>` or `/stormwatch subscriptions`"
+
+	// historyCommandEntryLimit bounds how far back `/stormwatch history` looks
+	historyCommandEntryLimit = 500
+	// historyCommandChangeLimit bounds how many changes `/stormwatch history` lists
+	historyCommandChangeLimit = 15
+
+	slackResponseEphemeral = "ephemeral"
+	slackResponseInChannel = "in_channel"
+)
+
+type (
+	// SlackCommandHandler answers the /stormwatch slash command
+	SlackCommandHandler struct {
+		SigningSecret string
+		History       slackCommandHistory
+		Subscriptions slackSubscriptionStore
+		// Now returns the current time. Defaults to time.Now.
+		Now func() time.Time
+	}
+
+	slackCommandHistory interface {
+		GetLatestProgressEntry(ctx context.Context) (history.ProgressEntry, error)
+		GetProgressEntries(ctx context.Context, limit int32) ([]history.ProgressEntry, error)
+	}
+
+	slackSubscriptionStore interface {
+		GetSubscription(ctx context.Context, channelID string) (slack.Subscription, error)
+		Subscribe(ctx context.Context, channelID, workID string) error
+		Unsubscribe(ctx context.Context, channelID, workID string) error
+	}
+
+	slackCommandResponse struct {
+		ResponseType string `json:"response_type"`
+		Text         string `json:"text"`
+	}
+)
+
+// containerSlackCommandHandler is the slack command handler shared by every invocation in the Lambda container, so
+// that the config and secret are not loaded within Slack's three second deadline
+var containerSlackCommandHandler = perContainer(NewSlackCommandHandlerFromContext)
+
+// HandleSlackCommand answers a slash command request received through the Function URL
+func HandleSlackCommand(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	ctx = logging.WithLambdaRequest(ctx)
+	handler, err := containerSlackCommandHandler(ctx)
+	if err != nil {
+		return events.LambdaFunctionURLResponse{}, fmt.Errorf("new slack command handler from context: %w", err)
+	}
+
+	return handler.HandleSlackCommand(ctx, req)
+}
+
+// NewSlackCommandHandlerFromContext creates a new slack command handler by initializing dependencies from ctx
+func NewSlackCommandHandlerFromContext(ctx context.Context) (*SlackCommandHandler, error) {
+	cfg, awsCfg, secrets, err := loadConfig(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(awsCfg)
+	historyClient, err := history.NewDynamoClient(dynamoClient, cfg.HistoryTable)
+	if err != nil {
+		return nil, fmt.Errorf("new history dynamo client: %w", err)
+	}
+
+	return &SlackCommandHandler{
+		SigningSecret: secrets.SlackSigningSecret,
+		History:       historyClient,
+		Subscriptions: slack.NewDynamoSubscriptionStore(dynamoClient, cfg.SlackSubscriptionsTable),
+	}, nil
+}
+
+// HandleSlackCommand verifies that the request came from Slack and answers the command it carries
+func (handler *SlackCommandHandler) HandleSlackCommand(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return textResponse(http.StatusBadRequest, "invalid body"), nil
+		}
+	}
+
+	if err := slack.VerifyRequestSignature(handler.SigningSecret, req.Headers["x-slack-request-timestamp"], req.Headers["x-slack-signature"], body, handler.now()); err != nil {
+		logging.FromContext(ctx).Warn("Rejecting slack request", "error", err)
+		return textResponse(http.StatusUnauthorized, "invalid signature"), nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return textResponse(http.StatusBadRequest, "invalid form"), nil
+	}
+
+	response, err := handler.runCommand(ctx, form.Get("channel_id"), form.Get("text"))
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to run slack command", "command", form.Get("text"), "error", err)
+		response = slackCommandResponse{ResponseType: slackResponseEphemeral, Text: "Sorry, something went wrong. Please try again later."}
+	}
+
+	responseBody, _ := json.Marshal(response)
+	return events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(responseBody),
+	}, nil
+}
+
+func (handler *SlackCommandHandler) runCommand(ctx context.Context, channelID, text string) (slackCommandResponse, error) {
+	subcommand, argument, _ := strings.Cut(strings.TrimSpace(text), " ")
+	argument = strings.TrimSpace(argument)
+
+	switch strings.ToLower(subcommand) {
+	case "status", "":
+		return handler.status(ctx)
+	case "history":
+		return handler.history(ctx, argument)
+	case "subscribe":
+		return handler.subscribe(ctx, channelID, argument)
+	case "unsubscribe":
+		return handler.unsubscribe(ctx, channelID, argument)
+	case "subscriptions":
+		return handler.subscriptions(ctx, channelID)
+	default:
+		return ephemeral(slackCommandUsage), nil
+	}
+}
+
+func (handler *SlackCommandHandler) status(ctx context.Context) (slackCommandResponse, error) {
+	latest, err := handler.History.GetLatestProgressEntry(ctx)
+	if errors.Is(err, history.ErrEmptyHistory) {
+		return ephemeral("No progress has been recorded yet."), nil
+	} else if err != nil {
+		return slackCommandResponse{}, fmt.Errorf("get latest progress entry: %w", err)
+	}
+
+	lines := []string{fmt.Sprintf("*Brandon Sanderson's progress* (as of %s)", latest.Timestamp.UTC().Format(time.DateOnly))}
+	for _, wip := range latest.WorksInProgress {
+		lines = append(lines, "• "+wip.String())
+	}
+	return slackCommandResponse{ResponseType: slackResponseInChannel, Text: strings.Join(lines, "\n")}, nil
+}
+
+func (handler *SlackCommandHandler) history(ctx context.Context, query string) (slackCommandResponse, error) {
+	work, problem, err := handler.findWork(ctx, query)
+	if err != nil {
+		return slackCommandResponse{}, err
+	} else if problem != "" {
+		return ephemeral(problem), nil
+	}
+
+	entries, err := handler.History.GetProgressEntries(ctx, historyCommandEntryLimit)
+	if err != nil {
+		return slackCommandResponse{}, fmt.Errorf("get progress entries: %w", err)
+	}
+
+	// Entries are newest first; walk them oldest first and keep the entries where the work's progress changed
+	changes := []string{}
+	previous := -1
+	for i := len(entries) - 1; i >= 0; i-- {
+		for _, wip := range entries[i].WorksInProgress {
+			if wip.ID() == work.ID() && wip.Progress != previous {
+				changes = append(changes, fmt.Sprintf("• %s: %d%%", entries[i].Timestamp.UTC().Format(time.DateOnly), wip.Progress))
+				previous = wip.Progress
+			}
+		}
+	}
+	if len(changes) > historyCommandChangeLimit {
+		changes = changes[len(changes)-historyCommandChangeLimit:]
+	}
+
+	text := fmt.Sprintf("*%s* progress history:\n%s", work.Title, strings.Join(changes, "\n"))
+	return slackCommandResponse{ResponseType: slackResponseInChannel, Text: text}, nil
+}
+
+func (handler *SlackCommandHandler) subscribe(ctx context.Context, channelID, query string) (slackCommandResponse, error) {
+	work, problem, err := handler.findWork(ctx, query)
+	if err != nil {
+		return slackCommandResponse{}, err
+	} else if problem != "" {
+		return ephemeral(problem), nil
+	}
+
+	if err := handler.Subscriptions.Subscribe(ctx, channelID, work.ID()); err != nil {
+		return slackCommandResponse{}, fmt.Errorf("subscribe: %w", err)
+	}
+	return slackCommandResponse{
+		ResponseType: slackResponseInChannel,
+		Text:         fmt.Sprintf("This channel will be notified when *%s* progresses.", work.Title),
+	}, nil
+}
+
+func (handler *SlackCommandHandler) unsubscribe(ctx context.Context, channelID, query string) (slackCommandResponse, error) {
+	if query == "" {
+		return ephemeral(slackCommandUsage), nil
+	}
+
+	subscription, err := handler.Subscriptions.GetSubscription(ctx, channelID)
+	if err != nil {
+		return slackCommandResponse{}, fmt.Errorf("get subscription: %w", err)
+	}
+	workID := progress.WorkID(query)
+	if !slices.Contains(subscription.WorkIDs, workID) {
+		// Allow unsubscribing by partial title, including from works that are no longer in progress
+		workID = ""
+		for _, subscribedWorkID := range subscription.WorkIDs {
+			if strings.Contains(subscribedWorkID, progress.WorkID(query)) {
+				workID = subscribedWorkID
+				break
+			}
+		}
+	}
+	if workID == "" {
+		return ephemeral(fmt.Sprintf("This channel is not subscribed to %q.", query)), nil
+	}
+
+	if err := handler.Subscriptions.Unsubscribe(ctx, channelID, workID); err != nil {
+		return slackCommandResponse{}, fmt.Errorf("unsubscribe: %w", err)
+	}
+	return slackCommandResponse{
+		ResponseType: slackResponseInChannel,
+		Text:         fmt.Sprintf("This channel will no longer be notified about `%s`.", workID),
+	}, nil
+}
+
+func (handler *SlackCommandHandler) subscriptions(ctx context.Context, channelID string) (slackCommandResponse, error) {
+	subscription, err := handler.Subscriptions.GetSubscription(ctx, channelID)
+	if err != nil {
+		return slackCommandResponse{}, fmt.Errorf("get subscription: %w", err)
+	}
+	if len(subscription.WorkIDs) == 0 {
+		return ephemeral("This channel is not subscribed to any works."), nil
+	}
+	return ephemeral("This channel is subscribed to: `" + strings.Join(subscription.WorkIDs, "`, `") + "`"), nil
+}
+
+// findWork looks up a work in progress by ID or by a case-insensitive part of its title. If the query does not
+// identify exactly one work, the problem is described for the user instead.
+func (handler *SlackCommandHandler) findWork(ctx context.Context, query string) (progress.WorkInProgress, string, error) {
+	if query == "" {
+		return progress.WorkInProgress{}, slackCommandUsage, nil
+	}
+
+	latest, err := handler.History.GetLatestProgressEntry(ctx)
+	if err != nil && !errors.Is(err, history.ErrEmptyHistory) {
+		return progress.WorkInProgress{}, "", fmt.Errorf("get latest progress entry: %w", err)
+	}
+
+	matches := []progress.WorkInProgress{}
+	for _, wip := range latest.WorksInProgress {
+		if wip.ID() == progress.WorkID(query) {
+			return wip, "", nil
+		}
+		if strings.Contains(strings.ToLower(wip.Title), strings.ToLower(query)) {
+			matches = append(matches, wip)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0], "", nil
+	case 0:
+		return progress.WorkInProgress{}, fmt.Sprintf("No work in progress matches %q.", query), nil
+	default:
+		titles := make([]string, len(matches))
+		for i, match := range matches {
+			titles[i] = "• " + match.Title
+		}
+		return progress.WorkInProgress{}, fmt.Sprintf("%q matches several works:\n%s", query, strings.Join(titles, "\n")), nil
+	}
+}
+
+func (handler *SlackCommandHandler) now() time.Time {
+	if handler.Now == nil {
+		return time.Now()
+	}
+	return handler.Now()
+}
+
+func ephemeral(text string) slackCommandResponse {
+	return slackCommandResponse{ResponseType: slackResponseEphemeral, Text: text}
+}
+
+func textResponse(statusCode int, body string) events.LambdaFunctionURLResponse {
+	return events.LambdaFunctionURLResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type": "text/plain",
+		},
+		Body: body,
+	}
+}
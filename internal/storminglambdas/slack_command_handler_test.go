@@ -0,0 +1,176 @@
+package storminglambdas
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/history"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/Rhionin/SanderServer/internal/slack"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+const testSigningSecret = "shhh"
+
+type (
+	fakeCommandHistory struct {
+		entries []history.ProgressEntry // newest first
+	}
+
+	fakeSubscriptionStore struct {
+		subscriptions map[string][]string
+	}
+)
+
+func (f *fakeCommandHistory) GetLatestProgressEntry(ctx context.Context) (history.ProgressEntry, error) {
+	if len(f.entries) == 0 {
+		return history.ProgressEntry{}, history.ErrEmptyHistory
+	}
+	return f.entries[0], nil
+}
+
+func (f *fakeCommandHistory) GetProgressEntries(ctx context.Context, limit int32) ([]history.ProgressEntry, error) {
+	return f.entries[:min(int(limit), len(f.entries))], nil
+}
+
+func (f *fakeSubscriptionStore) GetSubscription(ctx context.Context, channelID string) (slack.Subscription, error) {
+	return slack.Subscription{ChannelID: channelID, WorkIDs: f.subscriptions[channelID]}, nil
+}
+
+func (f *fakeSubscriptionStore) Subscribe(ctx context.Context, channelID, workID string) error {
+	if !slices.Contains(f.subscriptions[channelID], workID) {
+		f.subscriptions[channelID] = append(f.subscriptions[channelID], workID)
+	}
+	return nil
+}
+
+func (f *fakeSubscriptionStore) Unsubscribe(ctx context.Context, channelID, workID string) error {
+	f.subscriptions[channelID] = slices.DeleteFunc(f.subscriptions[channelID], func(id string) bool { return id == workID })
+	return nil
+}
+
+func newSlackCommandRequest(t *testing.T, now time.Time, text string) events.LambdaFunctionURLRequest {
+	t.Helper()
+	body := url.Values{
+		"command":    {"/stormwatch"},
+		"text":       {text},
+		"channel_id": {"C123"},
+	}.Encode()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testSigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+	return events.LambdaFunctionURLRequest{
+		Headers: map[string]string{
+			"x-slack-request-timestamp": timestamp,
+			"x-slack-signature":         "v0=" + hex.EncodeToString(mac.Sum(nil)),
+		},
+		Body: body,
+	}
+}
+
+func TestHandleSlackCommand(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	subscriptions := &fakeSubscriptionStore{subscriptions: map[string][]string{}}
+	handler := SlackCommandHandler{
+		SigningSecret: testSigningSecret,
+		History: &fakeCommandHistory{
+			entries: []history.ProgressEntry{
+				{
+					Timestamp: now.Add(-24 * time.Hour),
+					WorksInProgress: []progress.WorkInProgress{
+						{Title: "Moment Zero 2.0", Progress: 100},
+						{Title: "White Sand (Prose Version)", Progress: 20},
+					},
+				},
+				{
+					Timestamp: now.Add(-48 * time.Hour),
+					WorksInProgress: []progress.WorkInProgress{
+						{Title: "Moment Zero 2.0", Progress: 90},
+						{Title: "White Sand (Prose Version)", Progress: 20},
+					},
+				},
+				{
+					Timestamp: now.Add(-72 * time.Hour),
+					WorksInProgress: []progress.WorkInProgress{
+						{Title: "Moment Zero 2.0", Progress: 80},
+					},
+				},
+			},
+		},
+		Subscriptions: subscriptions,
+		Now:           func() time.Time { return now },
+	}
+
+	testCases := []struct {
+		text     string
+		expected string
+	}{
+		{
+			text:     "status",
+			expected: `{"response_type":"in_channel","text":"*Brandon Sanderson's progress* (as of 2024-02-29)\n• Moment Zero 2.0 (100%)\n• White Sand (Prose Version) (20%)"}`,
+		},
+		{
+			text:     "history moment zero",
+			expected: `{"response_type":"in_channel","text":"*Moment Zero 2.0* progress history:\n• 2024-02-27: 80%\n• 2024-02-28: 90%\n• 2024-02-29: 100%"}`,
+		},
+		{
+			text:     "history nothing",
+			expected: `{"response_type":"ephemeral","text":"No work in progress matches \"nothing\"."}`,
+		},
+		{
+			text:     "subscribe white sand",
+			expected: `{"response_type":"in_channel","text":"This channel will be notified when *White Sand (Prose Version)* progresses."}`,
+		},
+		{
+			text:     "subscriptions",
+			expected: "{\"response_type\":\"ephemeral\",\"text\":\"This channel is subscribed to: `white-sand-prose-version`\"}",
+		},
+		{
+			text:     "unsubscribe white",
+			expected: "{\"response_type\":\"in_channel\",\"text\":\"This channel will no longer be notified about `white-sand-prose-version`.\"}",
+		},
+		{
+			text:     "subscriptions",
+			expected: `{"response_type":"ephemeral","text":"This channel is not subscribed to any works."}`,
+		},
+		{
+			text:     "dance",
+			expected: `{"response_type":"ephemeral","text":"` + slackCommandUsage + `"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.text, func(t *testing.T) {
+			response, err := handler.HandleSlackCommand(context.Background(), newSlackCommandRequest(t, now, tc.text))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, response.StatusCode)
+			require.JSONEq(t, tc.expected, response.Body)
+		})
+	}
+}
+
+func TestHandleSlackCommand_RejectsBadSignature(t *testing.T) {
+	now := time.Now()
+	handler := SlackCommandHandler{SigningSecret: testSigningSecret, Now: func() time.Time { return now }}
+
+	req := newSlackCommandRequest(t, now, "status")
+	req.Headers["x-slack-signature"] = "v0=forged"
+	response, err := handler.HandleSlackCommand(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	req = newSlackCommandRequest(t, now.Add(-time.Hour), "status")
+	response, err = handler.HandleSlackCommand(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+}
@@ -0,0 +1,87 @@
+package storminglambdas
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/message"
+	"github.com/Rhionin/SanderServer/internal/slack"
+)
+
+// SlackDestination is a Slack incoming webhook to notify, each with its own channel, rules and templates. Webhooks
+// belong to a single workspace, so each workspace to notify needs its own destination.
+type SlackDestination struct {
+	// Name identifies the destination in logs. Defaults to the channel, or the destination's position in the list.
+	Name       string             `json:"name,omitempty"`
+	WebhookURL string             `json:"webhookUrl"`
+	Channel    string             `json:"channel,omitempty"` // Defaults to the webhook's channel
+	Locale     string             `json:"locale,omitempty"`
+	Rules      *NotificationRules `json:"rules,omitempty"`
+	Template   *message.Config    `json:"template,omitempty"`
+}
+
+// newSlackTargets builds one push target per Slack destination, starting with the default destination if configured
+func newSlackTargets(secrets StormlightArchive, statusPageURL string, dryRun *dryrun.Writer) ([]PushTarget, error) {
+	destinations := []SlackDestination{}
+	if secrets.SlackWebhookURL != "" {
+		defaultDestination := SlackDestination{
+			WebhookURL: secrets.SlackWebhookURL,
+			Locale:     secrets.SlackLocale,
+			Rules:      secrets.SlackNotificationRules,
+		}
+		if messageConfig, ok := secrets.MessageTemplates["slack"]; ok {
+			defaultDestination.Template = &messageConfig
+		}
+		destinations = append(destinations, defaultDestination)
+	}
+	for i, destination := range secrets.SlackDestinations {
+		if destination.Name == "" {
+			destination.Name = destination.Channel
+		}
+		if destination.Name == "" {
+			destination.Name = fmt.Sprint(i)
+		}
+		destinations = append(destinations, destination)
+	}
+
+	targets := []PushTarget{}
+	names := map[string]bool{}
+	var errs []error
+	for _, destination := range destinations {
+		target, err := newSlackTarget(destination, statusPageURL, dryRun)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("slack destination %q: %w", destination.Name, err))
+			continue
+		}
+		if names[target.GetName()] {
+			errs = append(errs, fmt.Errorf("duplicate slack destination %q", destination.Name))
+			continue
+		}
+		names[target.GetName()] = true
+		targets = append(targets, target)
+	}
+
+	return targets, errors.Join(errs...)
+}
+
+func newSlackTarget(destination SlackDestination, statusPageURL string, dryRun *dryrun.Writer) (PushTarget, error) {
+	if destination.WebhookURL == "" {
+		return nil, slack.ErrNoWebhookURL
+	}
+
+	client := slack.NewUpdateClient(destination.WebhookURL, destination.Channel)
+	client.Name = destination.Name
+	client.Locale = destination.Locale
+	client.StatusPageURL = statusPageURL
+	client.DryRun = dryRun
+	if destination.Template != nil {
+		var err error
+		client.Messages, err = message.New(client.GetName(), *destination.Template, slack.DefaultMessages)
+		if err != nil {
+			return nil, fmt.Errorf("load message templates: %w", err)
+		}
+	}
+
+	return withRules(client, destination.Rules)
+}
@@ -0,0 +1,66 @@
+package storminglambdas
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Rhionin/SanderServer/internal/message"
+	"github.com/Rhionin/SanderServer/internal/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSlackTargets(t *testing.T) {
+	var secrets StormlightArchive
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"SLACK_WEBHOOK_URL": "https://hooks.slack.com/services/default",
+		"SLACK_DESTINATIONS": [
+			{
+				"webhookUrl": "https://hooks.slack.com/services/team-a",
+				"channel": "#stormwatch",
+				"rules": {"onlyCompletions": true}
+			},
+			{
+				"name": "team-b",
+				"webhookUrl": "https://hooks.slack.com/services/team-b",
+				"locale": "es",
+				"template": {"title": "Actualización", "locales": {"es": {"title": "¡Actualización!"}}}
+			}
+		]
+	}`), &secrets))
+
+	targets, err := newSlackTargets(secrets, "https://status.example.com/", nil)
+	require.NoError(t, err)
+	require.Len(t, targets, 3)
+
+	defaultClient := targets[0].(*slack.UpdateClient)
+	require.Equal(t, "slack", defaultClient.GetName())
+	require.Equal(t, "https://status.example.com/", defaultClient.StatusPageURL)
+
+	teamA := targets[1].(*RuledPushTarget)
+	require.Equal(t, "slack:#stormwatch", teamA.GetName())
+	require.True(t, teamA.Rules.OnlyCompletions)
+	require.Equal(t, "#stormwatch", teamA.PushTarget.(*slack.UpdateClient).ChannelOverride)
+
+	teamB := targets[2].(*slack.UpdateClient)
+	require.Equal(t, "slack:team-b", teamB.GetName())
+	require.Equal(t, "es", teamB.Locale)
+	msg, err := teamB.Messages.Render(teamB.Locale, nil)
+	require.NoError(t, err)
+	require.Equal(t, "¡Actualización!", msg.Title)
+}
+
+func TestNewSlackTargets_Invalid(t *testing.T) {
+	_, err := newSlackTargets(StormlightArchive{
+		SlackDestinations: []SlackDestination{
+			{Name: "no-webhook"},
+			{Name: "bad-rules", WebhookURL: "https://hooks.slack.com/services/a", Rules: &NotificationRules{TitlePatterns: []string{"("}}},
+			{Name: "bad-template", WebhookURL: "https://hooks.slack.com/services/b", Template: &message.Config{Item: "{{.Nope}}"}},
+			{Name: "dupe", WebhookURL: "https://hooks.slack.com/services/c"},
+			{Name: "dupe", WebhookURL: "https://hooks.slack.com/services/d"},
+		},
+	}, "", nil)
+	require.ErrorIs(t, err, slack.ErrNoWebhookURL)
+	require.ErrorContains(t, err, `"bad-rules"`)
+	require.ErrorContains(t, err, `"bad-template"`)
+	require.ErrorContains(t, err, `duplicate slack destination "dupe"`)
+}
@@ -0,0 +1,64 @@
+package storminglambdas
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+
+	appconfig "github.com/Rhionin/SanderServer/internal/config"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/tracing"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// Start sets up logging and tracing from the config, then runs the Lambda handler. Every invocation is traced in a
+// span with the function's name.
+func Start[Request, Response any](name string, handler func(context.Context, Request) (Response, error)) {
+	logging.Init()
+	ctx := context.Background()
+	cfg, err := appconfig.Load(ctx, appconfig.LoadOptions{})
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+		os.Exit(1)
+	}
+	if err := logging.SetLevel(cfg.LogLevel); err != nil {
+		slog.Error("Failed to set log level", "error", err)
+		os.Exit(1)
+	}
+	if err := tracing.Setup(ctx, tracing.Options{
+		Exporter:     cfg.TraceExporter,
+		OTLPEndpoint: cfg.OTLPEndpoint,
+		ServiceName:  name,
+	}); err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	lambda.Start(tracing.WrapHandler(name, handler))
+}
+
+// perContainer returns a function that builds the value on its first successful call and returns the same value on
+// every later call, so that a Lambda container loads its config, secrets and clients once rather than per invocation.
+// A failed build is retried on the next call.
+func perContainer[T any](build func(context.Context) (T, error)) func(context.Context) (T, error) {
+	var (
+		mu    sync.Mutex
+		value T
+		built bool
+	)
+	return func(ctx context.Context) (T, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if built {
+			return value, nil
+		}
+		v, err := build(ctx)
+		if err != nil {
+			return v, err
+		}
+		value, built = v, true
+		return value, nil
+	}
+}
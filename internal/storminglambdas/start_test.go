@@ -0,0 +1,32 @@
+package storminglambdas
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerContainer(t *testing.T) {
+	ctx := context.Background()
+	builds := 0
+	get := perContainer(func(context.Context) (int, error) {
+		builds++
+		if builds == 1 {
+			return 0, errors.New("unavailable")
+		}
+		return builds, nil
+	})
+
+	_, err := get(ctx)
+	require.Error(t, err)
+
+	// The failed build is retried, and the value is built once it succeeds
+	for range 2 {
+		value, err := get(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, value)
+	}
+	require.Equal(t, 2, builds)
+}
@@ -0,0 +1,162 @@
+package storminglambdas
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/webpush"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+const (
+	// WebPushSubscriptionsPath is where the status page registers push subscriptions
+	WebPushSubscriptionsPath = "/push/subscriptions"
+
+	maxEndpointLength = 2048
+)
+
+// pushServiceHosts are the push services browsers subscribe with: FCM for Chrome, Mozilla autopush, Apple and Windows.
+// Endpoints may be on the hosts or their subdomains.
+var pushServiceHosts = []string{"fcm.googleapis.com", "push.services.mozilla.com", "push.apple.com", "notify.windows.com"}
+
+type (
+	// WebPushHandler lets status page visitors subscribe to web push notifications
+	WebPushHandler struct {
+		// PublicKey is the VAPID public key browsers subscribe with. Web push is disabled without it.
+		PublicKey     string
+		Subscriptions webPushSubscriptionStore
+		// Now returns the current time. Defaults to time.Now.
+		Now func() time.Time
+	}
+
+	webPushSubscriptionStore interface {
+		Subscribe(ctx context.Context, subscription webpush.Subscription) error
+		Unsubscribe(ctx context.Context, endpoints ...string) error
+	}
+
+	// webPushSubscriptionRequest is the browser's PushSubscription as JSON
+	webPushSubscriptionRequest struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+)
+
+// containerWebPushHandler is the web push handler shared by every invocation in the Lambda container
+var containerWebPushHandler = perContainer(NewWebPushHandlerFromContext)
+
+// ContainerWebPushHandler returns the web push handler for the Lambda container, so that the secret is loaded once
+// rather than on every page view
+func ContainerWebPushHandler(ctx context.Context) (*WebPushHandler, error) {
+	return containerWebPushHandler(ctx)
+}
+
+// NewWebPushHandlerFromContext creates a new web push handler by initializing dependencies from ctx
+func NewWebPushHandlerFromContext(ctx context.Context) (*WebPushHandler, error) {
+	cfg, awsCfg, secrets, err := loadConfig(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := &WebPushHandler{
+		Subscriptions: webpush.NewDynamoSubscriptionStore(dynamodb.NewFromConfig(awsCfg), cfg.WebPushSubscriptionsTable),
+	}
+	if secrets.VAPIDPrivateKey != "" {
+		key, err := webpush.ParseVAPIDKey(secrets.VAPIDPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("parse vapid key: %w", err)
+		}
+		handler.PublicKey = key.PublicKey()
+	}
+	return handler, nil
+}
+
+// HandleSubscriptionRequest adds the subscription in the request with PUT and removes it with DELETE
+func (handler *WebPushHandler) HandleSubscriptionRequest(ctx context.Context, req events.LambdaFunctionURLRequest) events.LambdaFunctionURLResponse {
+	if handler.PublicKey == "" {
+		return textResponse(http.StatusNotFound, "web push is not enabled")
+	}
+	method := req.RequestContext.HTTP.Method
+	if method != http.MethodPut && method != http.MethodDelete {
+		return textResponse(http.StatusMethodNotAllowed, "method not allowed")
+	}
+
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return textResponse(http.StatusBadRequest, "invalid body")
+		}
+	}
+	var request webPushSubscriptionRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return textResponse(http.StatusBadRequest, "invalid body")
+	}
+	if !isPushServiceEndpoint(request.Endpoint) {
+		return textResponse(http.StatusBadRequest, "invalid endpoint")
+	}
+
+	if method == http.MethodDelete {
+		if err := handler.Subscriptions.Unsubscribe(ctx, request.Endpoint); err != nil {
+			logging.FromContext(ctx).Error("Failed to remove web push subscription", "error", err)
+			return textResponse(http.StatusInternalServerError, "failed to unsubscribe")
+		}
+		return events.LambdaFunctionURLResponse{StatusCode: http.StatusNoContent}
+	}
+
+	if p256dh, err := base64.RawURLEncoding.DecodeString(request.Keys.P256dh); err != nil || len(p256dh) != 65 {
+		return textResponse(http.StatusBadRequest, "invalid p256dh key")
+	}
+	if auth, err := base64.RawURLEncoding.DecodeString(request.Keys.Auth); err != nil || len(auth) != 16 {
+		return textResponse(http.StatusBadRequest, "invalid auth secret")
+	}
+
+	if err := handler.Subscriptions.Subscribe(ctx, webpush.Subscription{
+		Endpoint:  request.Endpoint,
+		P256dh:    request.Keys.P256dh,
+		Auth:      request.Keys.Auth,
+		CreatedAt: handler.now(),
+	}); err != nil {
+		logging.FromContext(ctx).Error("Failed to add web push subscription", "error", err)
+		return textResponse(http.StatusInternalServerError, "failed to subscribe")
+	}
+	return events.LambdaFunctionURLResponse{StatusCode: http.StatusNoContent}
+}
+
+// isPushServiceEndpoint reports whether the endpoint is on a known push service. Since we POST to every stored
+// endpoint, any other host is refused to keep the push lambda from being aimed at other services.
+func isPushServiceEndpoint(endpoint string) bool {
+	if endpoint == "" || len(endpoint) > maxEndpointLength {
+		return false
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Scheme != "https" || endpointURL.User != nil || endpointURL.Port() != "" {
+		return false
+	}
+	host := strings.ToLower(endpointURL.Hostname())
+	for _, pushServiceHost := range pushServiceHosts {
+		if host == pushServiceHost || strings.HasSuffix(host, "."+pushServiceHost) {
+			return true
+		}
+	}
+	return false
+}
+
+func (handler *WebPushHandler) now() time.Time {
+	if handler.Now == nil {
+		return time.Now()
+	}
+	return handler.Now()
+}
@@ -0,0 +1,121 @@
+package storminglambdas
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/webpush"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testP256dh = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	testAuth   = "BTBZMqHH6r4Tts7J_aSIgg"
+)
+
+type fakeWebPushSubscriptionStore struct {
+	subscriptions map[string]webpush.Subscription
+}
+
+func (f *fakeWebPushSubscriptionStore) Subscribe(ctx context.Context, subscription webpush.Subscription) error {
+	f.subscriptions[subscription.Endpoint] = subscription
+	return nil
+}
+
+func (f *fakeWebPushSubscriptionStore) Unsubscribe(ctx context.Context, endpoints ...string) error {
+	for _, endpoint := range endpoints {
+		delete(f.subscriptions, endpoint)
+	}
+	return nil
+}
+
+func newWebPushRequest(method, body string) events.LambdaFunctionURLRequest {
+	req := events.LambdaFunctionURLRequest{RawPath: WebPushSubscriptionsPath, Body: body}
+	req.RequestContext.HTTP.Method = method
+	return req
+}
+
+func TestHandleSubscriptionRequest(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &fakeWebPushSubscriptionStore{subscriptions: map[string]webpush.Subscription{}}
+	handler := WebPushHandler{PublicKey: "key", Subscriptions: store, Now: func() time.Time { return now }}
+
+	testCases := []struct {
+		name           string
+		req            events.LambdaFunctionURLRequest
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			name:           "subscribe",
+			req:            newWebPushRequest(http.MethodPut, `{"endpoint":"https://fcm.googleapis.com/fcm/send/abc","expirationTime":null,"keys":{"p256dh":"`+testP256dh+`","auth":"`+testAuth+`"}}`),
+			expectedStatus: http.StatusNoContent,
+			expectedCount:  1,
+		},
+		{
+			name:           "plain http endpoint",
+			req:            newWebPushRequest(http.MethodPut, `{"endpoint":"http://fcm.googleapis.com/fcm/send/abc","keys":{"p256dh":"`+testP256dh+`","auth":"`+testAuth+`"}}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedCount:  1,
+		},
+		{
+			name:           "ip address endpoint",
+			req:            newWebPushRequest(http.MethodPut, `{"endpoint":"https://169.254.169.254/latest","keys":{"p256dh":"`+testP256dh+`","auth":"`+testAuth+`"}}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedCount:  1,
+		},
+		{
+			name:           "unknown push service",
+			req:            newWebPushRequest(http.MethodPut, `{"endpoint":"https://internal.example.com/abc","keys":{"p256dh":"`+testP256dh+`","auth":"`+testAuth+`"}}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedCount:  1,
+		},
+		{
+			name:           "lookalike host",
+			req:            newWebPushRequest(http.MethodPut, `{"endpoint":"https://evilfcm.googleapis.com.example.com/abc","keys":{"p256dh":"`+testP256dh+`","auth":"`+testAuth+`"}}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedCount:  1,
+		},
+		{
+			name:           "subscribe on a push service subdomain",
+			req:            newWebPushRequest(http.MethodPut, `{"endpoint":"https://wns2-par02p.notify.windows.com/w/?token=abc","keys":{"p256dh":"`+testP256dh+`","auth":"`+testAuth+`"}}`),
+			expectedStatus: http.StatusNoContent,
+			expectedCount:  2,
+		},
+		{
+			name:           "bad keys",
+			req:            newWebPushRequest(http.MethodPut, `{"endpoint":"https://fcm.googleapis.com/fcm/send/def","keys":{"p256dh":"short","auth":"`+testAuth+`"}}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedCount:  2,
+		},
+		{
+			name:           "wrong method",
+			req:            newWebPushRequest(http.MethodGet, ""),
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedCount:  2,
+		},
+		{
+			name:           "unsubscribe",
+			req:            newWebPushRequest(http.MethodDelete, `{"endpoint":"https://fcm.googleapis.com/fcm/send/abc"}`),
+			expectedStatus: http.StatusNoContent,
+			expectedCount:  1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := handler.HandleSubscriptionRequest(context.Background(), tc.req)
+			require.Equal(t, tc.expectedStatus, response.StatusCode)
+			require.Len(t, store.subscriptions, tc.expectedCount)
+		})
+	}
+}
+
+func TestHandleSubscriptionRequest_Disabled(t *testing.T) {
+	handler := WebPushHandler{}
+	response := handler.HandleSubscriptionRequest(context.Background(), newWebPushRequest(http.MethodPut, "{}"))
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+}
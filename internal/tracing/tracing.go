@@ -0,0 +1,134 @@
+// Package tracing exports OpenTelemetry spans for the scrape, storage and delivery steps, so that it can be seen where
+// a Lambda invocation's time goes.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Rhionin/SanderServer/internal/logging"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters
+const (
+	// ExporterNone records nothing
+	ExporterNone = "none"
+	// ExporterStdout writes spans to stdout as JSON, for local runs
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends spans to an OTLP/HTTP collector
+	ExporterOTLP = "otlp"
+)
+
+// Span attribute keys
+const (
+	WorksCountKey    = attribute.Key("stormwatch.works.count")
+	TargetKey        = attribute.Key("stormwatch.push.target")
+	UpdatesCountKey  = attribute.Key("stormwatch.push.updates")
+	CorrelationIDKey = attribute.Key("stormwatch.correlation_id")
+)
+
+const instrumentationName = "github.com/Rhionin/SanderServer"
+
+// provider is the installed tracer provider. It is nil until Setup installs one.
+var provider *sdktrace.TracerProvider
+
+// Options configure Setup
+type Options struct {
+	// Exporter is ExporterNone, ExporterStdout or ExporterOTLP. Empty means ExporterNone.
+	Exporter string
+	// OTLPEndpoint is the collector's URL, e.g. http://localhost:4318. It defaults to the standard
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	OTLPEndpoint string
+	// ServiceName names the process in traces
+	ServiceName string
+}
+
+// Setup installs the global tracer provider. With ExporterNone, the default no-op provider is kept.
+func Setup(ctx context.Context, options Options) error {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch options.Exporter {
+	case "", ExporterNone:
+		return nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+	case ExporterOTLP:
+		var otlpOptions []otlptracehttp.Option
+		if options.OTLPEndpoint != "" {
+			otlpOptions = append(otlpOptions, otlptracehttp.WithEndpointURL(options.OTLPEndpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, otlpOptions...)
+	default:
+		return fmt.Errorf("unknown trace exporter %q", options.Exporter)
+	}
+	if err != nil {
+		return fmt.Errorf("new %s exporter: %w", options.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", options.ServiceName)))
+	if err != nil {
+		return fmt.Errorf("new resource: %w", err)
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// Flush exports every finished span. Lambdas must flush before returning, as they may be frozen until the next
+// invocation.
+func Flush(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.ForceFlush(ctx)
+}
+
+// Shutdown flushes and stops the tracer provider
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Start starts a span as a child of the context's span
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records the error, if any, on the span and ends it. It is meant to be deferred with a pointer to a named error
+// result.
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// WrapHandler runs every invocation of a Lambda handler in a span named after it, and flushes the spans before the
+// invocation returns. Spans that cannot be exported are logged rather than failing the invocation.
+func WrapHandler[Request, Response any](name string, handler func(context.Context, Request) (Response, error)) func(context.Context, Request) (Response, error) {
+	return func(ctx context.Context, req Request) (response Response, err error) {
+		ctx, span := Start(ctx, name)
+		defer func() {
+			End(span, &err)
+			if flushErr := Flush(ctx); flushErr != nil {
+				logging.FromContext(ctx).Warn("Failed to export spans", "error", flushErr)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWrapHandler(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	handler := WrapHandler("Handler", func(ctx context.Context, fail bool) (string, error) {
+		_, span := Start(ctx, "Child", TargetKey.String("slack"))
+		span.End()
+		if fail {
+			return "", errors.New("failed")
+		}
+		return "ok", nil
+	})
+
+	response, err := handler(context.Background(), false)
+	require.NoError(t, err)
+	require.Equal(t, "ok", response)
+	_, err = handler(context.Background(), true)
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 4)
+	child, root := spans[0], spans[1]
+	require.Equal(t, "Child", child.Name())
+	require.Equal(t, "Handler", root.Name())
+	require.Equal(t, root.SpanContext().SpanID(), child.Parent().SpanID())
+	require.Contains(t, child.Attributes(), TargetKey.String("slack"))
+	require.Equal(t, codes.Unset, root.Status().Code)
+	require.Equal(t, codes.Error, spans[3].Status().Code)
+}
+
+func TestSetup(t *testing.T) {
+	require.NoError(t, Setup(context.Background(), Options{Exporter: ExporterNone}))
+	require.NoError(t, Flush(context.Background()))
+	require.Error(t, Setup(context.Background(), Options{Exporter: "jaeger"}))
+}
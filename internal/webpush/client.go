@@ -0,0 +1,116 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/justinrixx/retryhttp"
+)
+
+var (
+	// ErrSubscriptionGone means the push service no longer accepts messages for the subscription, so it should be
+	// removed
+	ErrSubscriptionGone = errors.New("subscription gone")
+
+	DefaultHTTPClient = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: retryhttp.New(),
+	}
+)
+
+type (
+	// Client sends encrypted messages to push services on behalf of a VAPID key
+	Client struct {
+		Key *VAPIDKey
+		// Subject is a mailto: or https: URL the push service can use to contact us
+		Subject    string
+		HTTPClient *http.Client
+	}
+
+	// Message is a message for one subscription
+	Message struct {
+		Payload []byte
+		// TTL is how long the push service keeps the message while the browser is offline
+		TTL time.Duration
+		// Topic replaces a pending message with the same topic
+		Topic string
+	}
+)
+
+func NewClient(key *VAPIDKey, subject string) *Client {
+	return &Client{
+		Key:     key,
+		Subject: subject,
+	}
+}
+
+// Send encrypts the message for the subscription and delivers it to the subscription's push service
+func (client *Client) Send(ctx context.Context, subscription Subscription, msg Message) error {
+	uaPublicKey, err := base64.RawURLEncoding.DecodeString(subscription.P256dh)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSubscription, err)
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(subscription.Auth)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSubscription, err)
+	}
+
+	asPrivateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	body, err := encrypt(msg.Payload, uaPublicKey, authSecret, salt, asPrivateKey)
+	if err != nil {
+		return err
+	}
+
+	authorization, err := client.Key.authorization(subscription.Endpoint, client.Subject, time.Now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(msg.TTL.Seconds())))
+	req.Header.Set("Urgency", "normal")
+	if msg.Topic != "" {
+		req.Header.Set("Topic", msg.Topic)
+	}
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = DefaultHTTPClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("push service responded %d: %s", resp.StatusCode, respBody)
+	}
+	return nil
+}
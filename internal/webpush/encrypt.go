@@ -0,0 +1,87 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// recordSize is the aes128gcm record size. Payloads are sent as a single record.
+	recordSize = 4096
+	saltLength = 16
+	// headerLength is the salt, the record size, the key ID length and the uncompressed P-256 public key as key ID
+	headerLength = saltLength + 4 + 1 + 65
+	// MaxPayloadLength is the largest payload that fits in one record alongside the header, GCM tag and delimiter
+	MaxPayloadLength = recordSize - headerLength - aes.BlockSize - 1
+)
+
+var (
+	ErrPayloadTooLarge     = errors.New("payload too large")
+	ErrInvalidSubscription = errors.New("invalid subscription keys")
+)
+
+// encrypt encrypts the payload for the user agent as specified by RFC 8291, using the aes128gcm content coding from
+// RFC 8188. The application server key and salt must be new for every message.
+func encrypt(payload []byte, uaPublicKey, authSecret, salt []byte, asPrivateKey *ecdh.PrivateKey) ([]byte, error) {
+	if len(payload) > MaxPayloadLength {
+		return nil, fmt.Errorf("%w: %d bytes, the limit is %d", ErrPayloadTooLarge, len(payload), MaxPayloadLength)
+	}
+	if len(authSecret) != 16 {
+		return nil, fmt.Errorf("%w: auth secret must be 16 bytes", ErrInvalidSubscription)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSubscription, err)
+	}
+	ecdhSecret, err := asPrivateKey.ECDH(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSubscription, err)
+	}
+	asPublicKey := asPrivateKey.PublicKey().Bytes()
+
+	// Combine the shared secret with the auth secret (RFC 8291 section 3.4)
+	keyInfo := "WebPush: info\x00" + string(uaPublicKey) + string(asPublicKey)
+	ikm, err := hkdf.Key(sha256.New, ecdhSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the content encryption key and nonce (RFC 8188 section 2.2 and 2.3)
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, 0, headerLength+len(payload)+1+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, recordSize)
+	body = append(body, byte(len(asPublicKey)))
+	body = append(body, asPublicKey...)
+
+	// The 0x02 delimiter marks the last record
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	return gcm.Seal(body, nonce, plaintext, nil), nil
+}
@@ -0,0 +1,44 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func decodeBase64URL(t *testing.T, value string) []byte {
+	t.Helper()
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	require.NoError(t, err)
+	return decoded
+}
+
+// TestEncrypt checks the example from RFC 8291 appendix A
+func TestEncrypt(t *testing.T) {
+	asPrivateKey, err := ecdh.P256().NewPrivateKey(decodeBase64URL(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	require.NoError(t, err)
+
+	encrypted, err := encrypt(
+		[]byte("When I grow up, I want to be a watermelon"),
+		decodeBase64URL(t, "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"),
+		decodeBase64URL(t, "BTBZMqHH6r4Tts7J_aSIgg"),
+		decodeBase64URL(t, "DGv6ra1nlYgDCS1FRnbzlw"),
+		asPrivateKey,
+	)
+	require.NoError(t, err)
+	require.Equal(t,
+		"DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN",
+		base64.RawURLEncoding.EncodeToString(encrypted),
+	)
+}
+
+func TestEncrypt_PayloadTooLarge(t *testing.T) {
+	asPrivateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	_, err = encrypt(make([]byte, MaxPayloadLength+1), asPrivateKey.PublicKey().Bytes(), make([]byte, 16), make([]byte, saltLength), asPrivateKey)
+	require.ErrorIs(t, err, ErrPayloadTooLarge)
+}
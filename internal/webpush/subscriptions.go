@@ -0,0 +1,85 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type (
+	// Subscription is a browser's push subscription, as produced by PushSubscription.toJSON() but flattened
+	Subscription struct {
+		Endpoint string
+		// P256dh is the browser's public key and Auth its authentication secret, both unpadded base64url
+		P256dh    string
+		Auth      string
+		CreatedAt time.Time
+	}
+
+	// DynamoSubscriptionStore stores push subscriptions in DynamoDB, one item per endpoint
+	DynamoSubscriptionStore struct {
+		client    *dynamodb.Client
+		tableName string
+	}
+)
+
+func NewDynamoSubscriptionStore(dbClient *dynamodb.Client, tableName string) *DynamoSubscriptionStore {
+	return &DynamoSubscriptionStore{client: dbClient, tableName: tableName}
+}
+
+// GetSubscriptions returns every push subscription
+func (s *DynamoSubscriptionStore) GetSubscriptions(ctx context.Context) ([]Subscription, error) {
+	subscriptions := []Subscription{}
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName: aws.String(s.tableName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("scan web push subscriptions: %w", err)
+		}
+		var pageSubscriptions []Subscription
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageSubscriptions); err != nil {
+			return nil, fmt.Errorf("unmarshal web push subscriptions: %w", err)
+		}
+		subscriptions = append(subscriptions, pageSubscriptions...)
+	}
+	return subscriptions, nil
+}
+
+// Subscribe adds the subscription, replacing any earlier one for its endpoint
+func (s *DynamoSubscriptionStore) Subscribe(ctx context.Context, subscription Subscription) error {
+	item, err := attributevalue.MarshalMap(subscription)
+	if err != nil {
+		return fmt.Errorf("marshal web push subscription: %w", err)
+	}
+	if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	}); err != nil {
+		return fmt.Errorf("put web push subscription: %w", err)
+	}
+	return nil
+}
+
+// Unsubscribe removes the subscriptions with the given endpoints. Unknown endpoints are ignored.
+func (s *DynamoSubscriptionStore) Unsubscribe(ctx context.Context, endpoints ...string) error {
+	var errs []error
+	for _, endpoint := range endpoints {
+		if _, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.tableName),
+			Key: map[string]types.AttributeValue{
+				"Endpoint": &types.AttributeValueMemberS{Value: endpoint},
+			},
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("delete web push subscription: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,135 @@
+package webpush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/logging"
+	"github.com/Rhionin/SanderServer/internal/message"
+	"github.com/Rhionin/SanderServer/internal/progress"
+)
+
+const (
+	notificationTTL = 24 * time.Hour
+	// notificationTag makes a newer notification replace an older one, both in the push service and the browser
+	notificationTag = "progress_update"
+)
+
+var (
+	ErrNoProgressUpdates = errors.New("no progress updates")
+
+	// DefaultMessages are the notification templates used when none are configured
+	DefaultMessages = message.Config{
+		Title: "Stormwatch",
+		Body:  "{{.Summary}}",
+		Item:  "{{.Text}}",
+	}
+)
+
+type (
+	// UpdateClient pushes updates to every browser subscribed on the status page, and removes subscriptions the push
+	// services no longer accept
+	UpdateClient struct {
+		Sender        sender
+		Subscriptions subscriptionStore
+		Messages      *message.Templates
+		Locale        string
+		// StatusPageURL is opened when the notification is clicked
+		StatusPageURL string
+		// DryRun, if set, gets the payload for each subscription instead of Sender. Payloads are written before they
+		// are encrypted.
+		DryRun *dryrun.Writer
+	}
+
+	sender interface {
+		Send(ctx context.Context, subscription Subscription, msg Message) error
+	}
+
+	subscriptionStore interface {
+		GetSubscriptions(ctx context.Context) ([]Subscription, error)
+		Unsubscribe(ctx context.Context, endpoints ...string) error
+	}
+
+	// payload is read by the service worker
+	payload struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+		Tag   string `json:"tag"`
+		URL   string `json:"url,omitempty"`
+	}
+)
+
+func NewUpdateClient(sender sender, subscriptions subscriptionStore) *UpdateClient {
+	return &UpdateClient{
+		Sender:        sender,
+		Subscriptions: subscriptions,
+		Messages:      message.MustNew("webpush", DefaultMessages),
+	}
+}
+
+func (client *UpdateClient) GetName() string {
+	return "webpush"
+}
+
+// SendUpdate sends the update to every subscription
+func (client *UpdateClient) SendUpdate(ctx context.Context, wips []progress.ProgressUpdate) error {
+	if len(wips) == 0 {
+		return ErrNoProgressUpdates
+	}
+
+	msg, err := client.Messages.Render(client.Locale, wips)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(payload{Title: msg.Title, Body: msg.Body, Tag: notificationTag, URL: client.StatusPageURL})
+	if err != nil {
+		return err
+	}
+
+	subscriptions, err := client.Subscriptions.GetSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("get subscriptions: %w", err)
+	}
+
+	gone := []string{}
+	failures := 0
+	var firstErr error
+	for _, subscription := range subscriptions {
+		err := client.send(ctx, subscription, Message{Payload: body, TTL: notificationTTL, Topic: notificationTag})
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrSubscriptionGone), errors.Is(err, ErrInvalidSubscription):
+			gone = append(gone, subscription.Endpoint)
+		default:
+			failures++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	logging.FromContext(ctx).Info("Sent web push message", "sent", len(subscriptions)-len(gone)-failures, "subscriptions", len(subscriptions))
+
+	var errs []error
+	if len(gone) > 0 {
+		logging.FromContext(ctx).Info("Removing expired web push subscriptions", "count", len(gone))
+		if err := client.Subscriptions.Unsubscribe(ctx, gone...); err != nil {
+			errs = append(errs, fmt.Errorf("remove expired subscriptions: %w", err))
+		}
+	}
+	if failures > 0 {
+		// Every failure is usually the same error, so only report the first
+		errs = append(errs, fmt.Errorf("send to %d of %d subscriptions failed: %w", failures, len(subscriptions), firstErr))
+	}
+	return errors.Join(errs...)
+}
+
+func (client *UpdateClient) send(ctx context.Context, subscription Subscription, msg Message) error {
+	if client.DryRun == nil {
+		return client.Sender.Send(ctx, subscription, msg)
+	}
+	return client.DryRun.Write(dryrun.Request{Target: client.GetName(), Destination: subscription.Endpoint, Payload: msg.Payload})
+}
@@ -0,0 +1,101 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Rhionin/SanderServer/internal/dryrun"
+	"github.com/Rhionin/SanderServer/internal/progress"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	fakeSender struct {
+		results map[string]error
+		sent    map[string]payload
+	}
+
+	fakeSubscriptionStore struct {
+		subscriptions []Subscription
+	}
+)
+
+func (f *fakeSender) Send(ctx context.Context, subscription Subscription, msg Message) error {
+	if err := f.results[subscription.Endpoint]; err != nil {
+		return err
+	}
+	var sent payload
+	if err := json.Unmarshal(msg.Payload, &sent); err != nil {
+		return err
+	}
+	f.sent[subscription.Endpoint] = sent
+	return nil
+}
+
+func (f *fakeSubscriptionStore) GetSubscriptions(ctx context.Context) ([]Subscription, error) {
+	return f.subscriptions, nil
+}
+
+func (f *fakeSubscriptionStore) Unsubscribe(ctx context.Context, endpoints ...string) error {
+	f.subscriptions = slices.DeleteFunc(f.subscriptions, func(subscription Subscription) bool {
+		return slices.Contains(endpoints, subscription.Endpoint)
+	})
+	return nil
+}
+
+func TestUpdateClient_SendUpdate(t *testing.T) {
+	errUnavailable := errors.New("push service unavailable")
+	sender := &fakeSender{
+		results: map[string]error{
+			"https://push.example.com/gone":        ErrSubscriptionGone,
+			"https://push.example.com/unavailable": errUnavailable,
+		},
+		sent: map[string]payload{},
+	}
+	store := &fakeSubscriptionStore{subscriptions: []Subscription{
+		{Endpoint: "https://push.example.com/ok"},
+		{Endpoint: "https://push.example.com/gone"},
+		{Endpoint: "https://push.example.com/unavailable"},
+	}}
+	client := NewUpdateClient(sender, store)
+	client.StatusPageURL = "https://example.com/status"
+
+	err := client.SendUpdate(context.Background(), []progress.ProgressUpdate{
+		{Title: "Moment Zero 2.0", Progress: 90, PrevProgress: 80},
+	})
+	require.ErrorIs(t, err, errUnavailable)
+
+	require.Equal(t, map[string]payload{
+		"https://push.example.com/ok": {
+			Title: "Stormwatch",
+			Body:  "Moment Zero 2.0 (80% => 90%)",
+			Tag:   notificationTag,
+			URL:   "https://example.com/status",
+		},
+	}, sender.sent)
+	require.Equal(t, []Subscription{
+		{Endpoint: "https://push.example.com/ok"},
+		{Endpoint: "https://push.example.com/unavailable"},
+	}, store.subscriptions)
+}
+
+func TestUpdateClient_SendUpdate_DryRun(t *testing.T) {
+	out := &bytes.Buffer{}
+	store := &fakeSubscriptionStore{subscriptions: []Subscription{{Endpoint: "https://push.example.com/ok"}}}
+	client := NewUpdateClient(nil, store)
+	client.DryRun = dryrun.New(out)
+
+	err := client.SendUpdate(context.Background(), []progress.ProgressUpdate{
+		{Title: "Moment Zero 2.0", Progress: 90, PrevProgress: 80},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"target": "webpush",
+		"destination": "https://push.example.com/ok",
+		"payload": {"title": "Stormwatch", "body": "Moment Zero 2.0 (80% => 90%)", "tag": "progress_update"}
+	}`, out.String())
+}
@@ -0,0 +1,102 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+)
+
+// vapidTokenLifetime is how long the VAPID token authorizing a push is valid. RFC 8292 allows up to 24 hours.
+const vapidTokenLifetime = 12 * time.Hour
+
+var ErrInvalidVAPIDKey = errors.New("invalid vapid key")
+
+// VAPIDKey identifies this server to push services (RFC 8292)
+type VAPIDKey struct {
+	privateKey *ecdsa.PrivateKey
+}
+
+// GenerateVAPIDKey returns a new, random VAPID key
+func GenerateVAPIDKey() (*VAPIDKey, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKey{privateKey: privateKey}, nil
+}
+
+// ParseVAPIDKey parses a VAPID private key in the unpadded base64url format used by web-push tools
+func ParseVAPIDKey(privateKey string) (*VAPIDKey, error) {
+	d, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidVAPIDKey, err)
+	}
+	ecdhKey, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidVAPIDKey, err)
+	}
+
+	// Uncompressed point: 0x04 || X || Y
+	publicKey := ecdhKey.PublicKey().Bytes()
+	return &VAPIDKey{privateKey: &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(publicKey[1:33]),
+			Y:     new(big.Int).SetBytes(publicKey[33:]),
+		},
+		D: new(big.Int).SetBytes(d),
+	}}, nil
+}
+
+// PrivateKey returns the private key in the format read by ParseVAPIDKey
+func (key *VAPIDKey) PrivateKey() string {
+	return base64.RawURLEncoding.EncodeToString(key.privateKey.D.FillBytes(make([]byte, 32)))
+}
+
+// PublicKey returns the public key browsers need to subscribe, the applicationServerKey
+func (key *VAPIDKey) PublicKey() string {
+	publicKey, _ := key.privateKey.PublicKey.ECDH()
+	return base64.RawURLEncoding.EncodeToString(publicKey.Bytes())
+}
+
+// authorization returns the Authorization header value that authorizes a push to the endpoint on behalf of subject,
+// a mailto: or https: URL the push service can use to contact us
+func (key *VAPIDKey) authorization(endpoint, subject string, now time.Time) (string, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("parse endpoint: %w", err)
+	}
+
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	claims, err := json.Marshal(map[string]any{
+		"aud": endpointURL.Scheme + "://" + endpointURL.Host,
+		"exp": now.Add(vapidTokenLifetime).Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key.privateKey, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("sign vapid token: %w", err)
+	}
+	// JWS wants the raw 64-byte r || s signature rather than ASN.1
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return "vapid t=" + token + ", k=" + key.PublicKey(), nil
+}
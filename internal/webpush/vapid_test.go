@@ -0,0 +1,54 @@
+package webpush
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVAPIDKey(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	require.NoError(t, err)
+
+	parsed, err := ParseVAPIDKey(key.PrivateKey())
+	require.NoError(t, err)
+	require.Equal(t, key.PublicKey(), parsed.PublicKey())
+
+	_, err = ParseVAPIDKey("not a key")
+	require.ErrorIs(t, err, ErrInvalidVAPIDKey)
+}
+
+func TestAuthorization(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	require.NoError(t, err)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	authorization, err := key.authorization("https://push.example.com/send/abc?x=1", "mailto:stormwatch@example.com", now)
+	require.NoError(t, err)
+
+	token, publicKey, ok := strings.Cut(strings.TrimPrefix(authorization, "vapid t="), ", k=")
+	require.True(t, ok)
+	require.Equal(t, key.PublicKey(), publicKey)
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	var claims map[string]any
+	require.NoError(t, json.Unmarshal(decodeBase64URL(t, parts[1]), &claims))
+	require.Equal(t, map[string]any{
+		"aud": "https://push.example.com",
+		"exp": float64(now.Add(vapidTokenLifetime).Unix()),
+		"sub": "mailto:stormwatch@example.com",
+	}, claims)
+
+	signature := decodeBase64URL(t, parts[2])
+	require.Len(t, signature, 64)
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	require.True(t, ecdsa.Verify(&key.privateKey.PublicKey, hash[:], r, s))
+}